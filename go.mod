@@ -6,4 +6,11 @@ require (
 	github.com/Nubes3/common v1.1.11
 	github.com/arangodb/go-driver v0.0.0-20210304082257-d7e0ea043b7f
 	github.com/gin-gonic/gin v1.7.1
+	github.com/nats-io/nats.go v1.10.1-0.20210330225420-a0b1f60162f8
+	github.com/prometheus/client_golang v1.11.0
+	github.com/spf13/viper v1.7.1
+	golang.org/x/crypto v0.0.0-20210314154223-e6e6c4f2bb5b
+	golang.org/x/net v0.0.0-20210226172049-e18ecbb05110
+	google.golang.org/grpc v1.40.0
+	google.golang.org/protobuf v1.27.1
 )
@@ -0,0 +1,60 @@
+package aggregate
+
+import (
+	"github.com/Nubes3/file-service/internal/api/apierror"
+	"github.com/Nubes3/file-service/internal/job"
+	arango "github.com/Nubes3/file-service/internal/repo/arangodb"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"strconv"
+)
+
+// ReconcileAdmin starts the consistency reconciler, for one bucket when
+// bucketId is given, and answers with the run to poll for its report. It
+// only reports drift unless repair=true.
+func ReconcileAdmin(c *gin.Context) {
+	repair, err := strconv.ParseBool(c.DefaultQuery("repair", "false"))
+	if err != nil {
+		apierror.Abort(c, http.StatusBadRequest, "invalid repair flag")
+
+		return
+	}
+
+	run, err := job.StartReconcileRun(c.DefaultQuery("bucketId", ""), repair)
+	if err != nil {
+		apierror.Respond(c, err)
+		return
+	}
+
+	c.JSON(http.StatusAccepted, run)
+}
+
+// GetReconcileRunAdmin returns a reconcile run, with its report once done.
+func GetReconcileRunAdmin(c *gin.Context) {
+	run, err := arango.FindReconcileRun(c.Param("id"))
+	if err != nil {
+		apierror.Respond(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, run)
+}
+
+// GetCascadesAdmin lists the cascades started by bucket and folder
+// deletions with their progress, unfinished ones first.
+func GetCascadesAdmin(c *gin.Context) {
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "100"))
+	if err != nil || limit <= 0 {
+		apierror.Abort(c, http.StatusBadRequest, "invalid limit format")
+
+		return
+	}
+
+	cascades, err := arango.FindCascades(limit)
+	if err != nil {
+		apierror.Respond(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, cascades)
+}
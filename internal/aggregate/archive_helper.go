@@ -0,0 +1,161 @@
+package aggregate
+
+import (
+	"github.com/Nubes3/common/models/arangodb"
+	"github.com/Nubes3/common/utils"
+	"github.com/Nubes3/file-service/internal/api/apierror"
+	"github.com/Nubes3/file-service/internal/archive"
+	"github.com/Nubes3/file-service/internal/config"
+	"github.com/Nubes3/file-service/internal/policy"
+	arango "github.com/Nubes3/file-service/internal/repo/arangodb"
+	"github.com/Nubes3/file-service/internal/repo/nats"
+	"github.com/gin-gonic/gin"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// resolveArchiveFiles returns the files selected either by ids or by a folder
+// path, and the folder the archive entry names are relative to.
+func resolveArchiveFiles(bucket *arangodb.Bucket, path string, ids []string,
+	p *policy.Policy) (string, []arangodb.FileMetadata, error) {
+	showHidden := p.Has(policy.DownloadHidden)
+	if len(ids) > 0 {
+		ids = uniqueIds(ids)
+		files, err := arango.FindMetadataByIds(ids)
+		if err != nil {
+			return "", nil, err
+		}
+
+		if len(files) != len(ids) {
+			return "", nil, &utils.ModelError{
+				Msg:     "file not found",
+				ErrType: utils.NotFound,
+			}
+		}
+		for _, f := range files {
+			if f.BucketId != *bucket.Id || (f.IsHidden && !showHidden) || !p.AllowsFile(&f) {
+				return "", nil, &utils.ModelError{
+					Msg:     "file not found",
+					ErrType: utils.NotFound,
+				}
+			}
+		}
+
+		return "/" + bucket.Name, files, nil
+	}
+
+	if path == "" {
+		return "", nil, &utils.ModelError{
+			Msg:     "missing path or fileId",
+			ErrType: utils.Invalid,
+		}
+	}
+
+	path = utils.StandardizedPath(path, true)
+	if path == "" || utils.GetBucketName(path) != bucket.Name {
+		return "", nil, &utils.ModelError{
+			Msg:     "invalid bucket name",
+			ErrType: utils.Invalid,
+		}
+	}
+
+	files, err := arango.FindMetadataByPathPrefix(*bucket.Id, path, showHidden)
+	if err != nil {
+		return "", nil, err
+	}
+
+	return path, p.Filter(files), nil
+}
+
+func uniqueIds(ids []string) []string {
+	seen := make(map[string]bool, len(ids))
+	unique := make([]string, 0, len(ids))
+	for _, id := range ids {
+		if !seen[id] {
+			seen[id] = true
+			unique = append(unique, id)
+		}
+	}
+
+	return unique
+}
+
+// streamArchive writes files as an archive straight from SeaweedFS to the
+// response. Once the first byte is written a failure can only abort the
+// stream, leaving the client with a truncated archive.
+func streamArchive(c *gin.Context, format archive.Format, base string, files []arangodb.FileMetadata) {
+	var totalSize int64
+	for _, f := range files {
+		totalSize += f.Size
+	}
+
+	if totalSize > config.Conf.ArchiveMaxSize {
+		apierror.Abort(c, http.StatusRequestEntityTooLarge, "archive too large")
+
+		return
+	}
+
+	name := utils.GetFileName(base)
+	if name == "" {
+		name = "download"
+	}
+
+	c.Header("Content-Disposition", attachmentDisposition(name+format.Extension()))
+	c.Header("Content-Type", format.ContentType())
+	c.Status(http.StatusOK)
+
+	aw := archive.NewWriter(format, c.Writer)
+	for _, f := range files {
+		f := f
+		entry := strings.TrimPrefix(f.Path+"/"+f.Name, base+"/")
+
+		err := arango.DownloadFile(&f, func(reader io.Reader) error {
+			return aw.Add(entry, f.Size, f.UploadedDate, reader)
+		})
+		if err != nil {
+			_ = c.Error(err)
+			c.Abort()
+
+			//_ = nats.SendErrorEvent("archive failed: "+err.Error(),
+			//	"File Error")
+			return
+		}
+
+		_ = nats.SendDownloadFileEvent(&f)
+	}
+
+	_ = aw.Close()
+}
+
+// attachmentDisposition is the Content-Disposition of a download named name,
+// as RFC 6266 describes: a quoted ASCII fallback for old clients and the
+// UTF-8 name percent encoded in filename*.
+func attachmentDisposition(name string) string {
+	var fallback, encoded strings.Builder
+	for _, r := range name {
+		switch {
+		case r == '"' || r == '\\':
+			fallback.WriteByte('\\')
+			fallback.WriteRune(r)
+		case r < 0x20 || r > 0x7e:
+			fallback.WriteByte('_')
+		default:
+			fallback.WriteRune(r)
+		}
+	}
+
+	const hex = "0123456789ABCDEF"
+	for _, b := range []byte(name) {
+		if b >= 'a' && b <= 'z' || b >= 'A' && b <= 'Z' || b >= '0' && b <= '9' ||
+			strings.IndexByte("!#$&+-.^_`|~", b) >= 0 {
+			encoded.WriteByte(b)
+		} else {
+			encoded.WriteByte('%')
+			encoded.WriteByte(hex[b>>4])
+			encoded.WriteByte(hex[b&0xf])
+		}
+	}
+
+	return `attachment; filename="` + fallback.String() + `"; filename*=UTF-8''` + encoded.String()
+}
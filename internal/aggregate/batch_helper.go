@@ -0,0 +1,147 @@
+package aggregate
+
+import (
+	"github.com/Nubes3/common/models/arangodb"
+	"github.com/Nubes3/common/utils"
+	"github.com/Nubes3/file-service/internal/api/apierror"
+	"github.com/Nubes3/file-service/internal/config"
+	"github.com/Nubes3/file-service/internal/policy"
+	arango "github.com/Nubes3/file-service/internal/repo/arangodb"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"strconv"
+	"sync"
+)
+
+type batchOperation struct {
+	Op     string `json:"op" binding:"required"`
+	FileId string `json:"file_id" binding:"required"`
+	Path   string `json:"path"`
+	Name   string `json:"name"`
+}
+
+type batchReq struct {
+	Operations []batchOperation `json:"operations" binding:"required"`
+}
+
+type batchResult struct {
+	Index  int                    `json:"index"`
+	Op     string                 `json:"op"`
+	FileId string                 `json:"file_id"`
+	Status int                    `json:"status"`
+	Code   string                 `json:"code,omitempty"`
+	Error  string                 `json:"error,omitempty"`
+	File   *arangodb.FileMetadata `json:"file,omitempty"`
+}
+
+// batchPermission returns the permission an operation requires, false for
+// an unknown operation.
+func batchPermission(op string) (policy.Permission, bool) {
+	switch op {
+	case "hide", "unhide":
+		return policy.MarkHidden, true
+	case "delete":
+		return policy.DeleteFile, true
+	case "move":
+		return policy.Upload, true
+	default:
+		return -1, false
+	}
+}
+
+// runBatch executes the operations of the request body against bucket with
+// bounded concurrency. allowed reports whether the caller holds a permission.
+func runBatch(c *gin.Context, bucket *arangodb.Bucket, p *policy.Policy) {
+	var req batchReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		apierror.Abort(c, http.StatusBadRequest, err.Error())
+
+		return
+	}
+
+	if len(req.Operations) > config.Conf.BatchMaxSize {
+		apierror.Abort(c, http.StatusBadRequest, "too many operations, max "+strconv.Itoa(config.Conf.BatchMaxSize))
+
+		return
+	}
+
+	results := make([]batchResult, len(req.Operations))
+	sem := make(chan struct{}, config.Conf.BatchConcurrency)
+	var wg sync.WaitGroup
+
+	for i, op := range req.Operations {
+		results[i] = batchResult{Index: i, Op: op.Op, FileId: op.FileId}
+
+		perm, ok := batchPermission(op.Op)
+		if !ok {
+			results[i].Status = http.StatusBadRequest
+			results[i].Error = "unknown operation: " + op.Op
+			continue
+		}
+		if !p.Has(perm) {
+			results[i].Status = http.StatusForbidden
+			results[i].Error = "not have permission"
+			continue
+		}
+
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int, op batchOperation) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			fm, err := executeBatchOperation(bucket, p, op)
+			if err != nil {
+				results[i].Status, results[i].Code, results[i].Error = apierror.Status(err)
+				return
+			}
+			results[i].Status = http.StatusOK
+			results[i].File = fm
+		}(i, op)
+	}
+	wg.Wait()
+
+	c.JSON(http.StatusOK, gin.H{
+		"results": results,
+	})
+}
+
+func executeBatchOperation(bucket *arangodb.Bucket, p *policy.Policy, op batchOperation) (*arangodb.FileMetadata, error) {
+	fm, err := arango.FindMetadataById(op.FileId)
+	if err != nil || fm.BucketId != *bucket.Id || !p.AllowsFile(fm) {
+		return nil, &utils.ModelError{
+			Msg:     "file not found",
+			ErrType: utils.NotFound,
+		}
+	}
+
+	switch op.Op {
+	case "hide":
+		return arango.SetFileHidden(fm.Id, true)
+	case "unhide":
+		return arango.SetFileHidden(fm.Id, false)
+	case "delete":
+		return arango.DeleteFile(fm.Id)
+	default:
+		path := utils.StandardizedPath(op.Path, true)
+		if path == "" || utils.GetBucketName(path) != bucket.Name {
+			return nil, &utils.ModelError{
+				Msg:     "invalid path",
+				ErrType: utils.Invalid,
+			}
+		}
+
+		name := op.Name
+		if name == "" {
+			name = fm.Name
+		}
+		if !p.AllowsPath(policy.FullPath(path, name)) {
+			return nil, &utils.ModelError{
+				Msg:     "path outside of key scope",
+				ErrType: utils.Invalid,
+			}
+		}
+
+		return arango.MoveFile(fm.Id, path, name)
+	}
+}
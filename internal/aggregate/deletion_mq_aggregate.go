@@ -0,0 +1,61 @@
+package aggregate
+
+import (
+	"encoding/json"
+	"github.com/Nubes3/common/models/arangodb"
+	"github.com/Nubes3/common/utils"
+	"github.com/Nubes3/file-service/internal/config"
+	arango "github.com/Nubes3/file-service/internal/repo/arangodb"
+	natsGo "github.com/nats-io/nats.go"
+	"log"
+)
+
+// HandleBucketDeleted records the cascade deleting every file of a bucket
+// deleted by the bucket service. The event carries the bucket.
+func HandleBucketDeleted(m *natsGo.Msg) {
+	var bucket arangodb.Bucket
+	if err := json.Unmarshal(m.Data, &bucket); err != nil || bucket.Id == nil {
+		log.Println("invalid bucket deleted event")
+		return
+	}
+
+	createCascade(arango.CascadeBucket, *bucket.Id, "")
+}
+
+// folderDeletedEvent is the folder the folder service deleted, with the id
+// of its bucket.
+type folderDeletedEvent struct {
+	arangodb.Folder
+	BucketId string `json:"bucket_id"`
+}
+
+// HandleFolderDeleted records the cascade deleting every file under a
+// folder deleted by the folder service. The event carries the folder and
+// its bucket id.
+func HandleFolderDeleted(m *natsGo.Msg) {
+	var event folderDeletedEvent
+	if err := json.Unmarshal(m.Data, &event); err != nil || event.BucketId == "" {
+		log.Println("invalid folder deleted event")
+		return
+	}
+
+	path := utils.StandardizedPath(event.Fullpath, true)
+	if path == "" {
+		log.Println("invalid folder deleted event")
+		return
+	}
+	if path == "/"+utils.GetBucketName(path) {
+		// The root folder goes with its bucket, whose event carries the
+		// bucket id.
+		return
+	}
+
+	createCascade(arango.CascadeFolder, event.BucketId, path)
+}
+
+func createCascade(scope string, bid string, path string) {
+	_, err := arango.CreateCascade(scope, bid, path, config.Conf.CascadeMode)
+	if err != nil {
+		log.Println("create " + scope + " cascade failed: " + err.Error())
+	}
+}
@@ -0,0 +1,103 @@
+package aggregate
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/Nubes3/common/utils"
+	"github.com/Nubes3/file-service/internal/api/apierror"
+	"github.com/Nubes3/file-service/internal/policy"
+	arango "github.com/Nubes3/file-service/internal/repo/arangodb"
+	"github.com/gin-gonic/gin"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	eventStreamPoll      = time.Second
+	eventStreamHeartbeat = time.Second * 15
+	eventStreamBatch     = 100
+)
+
+// streamBucketEvents serves the file events of a bucket the caller's policy
+// allows as Server-Sent Events. The event id is the log sequence, which
+// follows commit order, so a reconnecting client resumes with Last-Event-ID
+// (or the lastEventId query parameter) without missing events.
+func streamBucketEvents(c *gin.Context, bid string, p *policy.Policy) {
+	prefix := c.DefaultQuery("path", "")
+	if prefix != "" {
+		prefix = utils.StandardizedPath(prefix, true)
+	}
+
+	var cursor int64
+	lastId := c.GetHeader("Last-Event-ID")
+	if lastId == "" {
+		lastId = c.DefaultQuery("lastEventId", "")
+	}
+	if lastId != "" {
+		seq, err := strconv.ParseInt(lastId, 10, 64)
+		if err != nil {
+			apierror.Abort(c, http.StatusBadRequest, "invalid Last-Event-ID")
+
+			return
+		}
+		cursor = seq
+	} else {
+		seq, err := arango.LastOutboxSeq()
+		if err != nil {
+			apierror.Respond(c, err)
+
+			return
+		}
+		cursor = seq
+	}
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+
+	poll := time.NewTicker(eventStreamPoll)
+	defer poll.Stop()
+	lastWrite := time.Now()
+
+	c.Stream(func(w io.Writer) bool {
+		select {
+		case <-c.Request.Context().Done():
+			return false
+		case <-poll.C:
+		}
+
+		events, err := arango.FindBucketEvents(bid, prefix, p.Has(policy.GetFileListHidden),
+			cursor, eventStreamBatch)
+		if err != nil {
+			_, _ = fmt.Fprintf(w, "event: error\ndata: %s\n\n", "event log unavailable")
+			return false
+		}
+
+		for _, e := range events {
+			cursor = e.Seq
+			if !p.AllowsPath(policy.FullPath(e.Event.File.Path, e.Event.File.Name)) {
+				continue
+			}
+
+			data, _ := json.Marshal(e.Event)
+			_, err = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", e.Seq, e.Event.Type, data)
+			if err != nil {
+				return false
+			}
+			lastWrite = time.Now()
+		}
+
+		if time.Since(lastWrite) >= eventStreamHeartbeat {
+			if _, err = fmt.Fprint(w, ": heartbeat\n\n"); err != nil {
+				return false
+			}
+			lastWrite = time.Now()
+		}
+
+		return true
+	})
+}
@@ -0,0 +1,159 @@
+package aggregate
+
+import (
+	"bytes"
+	"github.com/Nubes3/common/models/arangodb"
+	"github.com/Nubes3/common/utils"
+	"github.com/Nubes3/file-service/internal/api/apierror"
+	"github.com/Nubes3/file-service/internal/archive"
+	"github.com/Nubes3/file-service/internal/config"
+	"github.com/Nubes3/file-service/internal/policy"
+	arango "github.com/Nubes3/file-service/internal/repo/arangodb"
+	"github.com/Nubes3/file-service/internal/repo/nats"
+	"github.com/gin-gonic/gin"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"strings"
+	"time"
+)
+
+type extractResult struct {
+	Entry string                 `json:"entry"`
+	File  *arangodb.FileMetadata `json:"file,omitempty"`
+	Error string                 `json:"error,omitempty"`
+}
+
+// extractUpload unpacks an uploaded archive into path, saving every entry as
+// its own file. Entries fail independently; the response lists each result
+// and uses 207 when anything failed.
+func extractUpload(c *gin.Context, bucket *arangodb.Bucket, path string, uploadFile *multipart.FileHeader,
+	content multipart.File, isHidden bool, ttl time.Duration, attrs *arango.FileAttributes) {
+	format, err := archive.DetectFormat(uploadFile.Filename)
+	if err != nil {
+		apierror.Abort(c, http.StatusBadRequest, err.Error())
+
+		return
+	}
+
+	p := policy.FromContext(c)
+	results := []extractResult{}
+	knownFolders := map[string]bool{path: true}
+	var entryCount int
+	var totalSize int64
+	var failed int
+
+	walkErr := archive.Walk(format, content, uploadFile.Size, func(entry archive.Entry, reader io.Reader) error {
+		entryCount++
+		totalSize += entry.Size
+		if entryCount > config.Conf.ExtractMaxEntries {
+			return &utils.ModelError{
+				Msg:     "too many archive entries",
+				ErrType: utils.Invalid,
+			}
+		}
+		if totalSize > config.Conf.ExtractMaxSize {
+			return &utils.ModelError{
+				Msg:     "archive too large",
+				ErrType: utils.Invalid,
+			}
+		}
+
+		name, err := archive.CleanEntryName(entry.Name)
+		if err != nil {
+			failed++
+			results = append(results, extractResult{Entry: entry.Name, Error: err.Error()})
+			return nil
+		}
+
+		parent := utils.GetParentPath(name)
+		target := path
+		if parent != "/" {
+			target = path + parent
+		}
+
+		if !p.AllowsPath(policy.FullPath(target, utils.GetFileName(name))) {
+			failed++
+			results = append(results, extractResult{Entry: name, Error: "path outside of key scope"})
+			return nil
+		}
+
+		err = checkFolders(path, parent, knownFolders)
+		if err != nil {
+			failed++
+			_, _, msg := apierror.Status(err)
+			results = append(results, extractResult{Entry: name, Error: msg})
+			return nil
+		}
+
+		cType, reader := sniffContentType(io.LimitReader(reader, entry.Size))
+		fm, err := arango.SaveFile(reader, *bucket.Id, target, utils.GetFileName(name), isHidden,
+			cType, entry.Size, ttl, attrs)
+		if err != nil {
+			failed++
+			_, _, msg := apierror.Status(err)
+			results = append(results, extractResult{Entry: name, Error: msg})
+			return nil
+		}
+
+		results = append(results, extractResult{Entry: name, File: fm})
+		return nil
+	})
+
+	if walkErr != nil && len(results) == 0 {
+		apierror.Abort(c, http.StatusBadRequest, walkErr.Error())
+
+		return
+	}
+
+	res := gin.H{
+		"extracted": len(results) - failed,
+		"failed":    failed,
+		"results":   results,
+	}
+	if walkErr != nil {
+		res["error"] = walkErr.Error()
+	}
+
+	if failed > 0 || walkErr != nil {
+		c.JSON(http.StatusMultiStatus, res)
+		return
+	}
+
+	c.JSON(http.StatusOK, res)
+}
+
+// checkFolders checks that every folder of the relative path rel below base
+// exists, remembering the ones already found in known. Folders are created
+// through the folder service, which offers no request to create them here.
+func checkFolders(base string, rel string, known map[string]bool) error {
+	current := base
+	for _, segment := range strings.Split(strings.Trim(rel, "/"), "/") {
+		if segment == "" {
+			continue
+		}
+
+		current = current + "/" + segment
+		if known[current] {
+			continue
+		}
+		if _, err := nats.FindFolderByFullpath(current); err != nil {
+			return &utils.ModelError{
+				Msg:     "folder " + current + " not found",
+				ErrType: utils.NotFound,
+			}
+		}
+		known[current] = true
+	}
+
+	return nil
+}
+
+// sniffContentType detects the content type from the first 512 bytes and
+// returns a reader that still yields the whole content.
+func sniffContentType(reader io.Reader) (string, io.Reader) {
+	buffer := make([]byte, 512)
+	n, _ := io.ReadFull(reader, buffer)
+
+	return http.DetectContentType(buffer[:n]), io.MultiReader(bytes.NewReader(buffer[:n]), reader)
+}
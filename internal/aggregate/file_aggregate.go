@@ -0,0 +1,491 @@
+package aggregate
+
+import (
+	"github.com/Nubes3/common/models/arangodb"
+	"github.com/Nubes3/common/utils"
+	"github.com/Nubes3/file-service/internal/api/apierror"
+	"github.com/Nubes3/file-service/internal/archive"
+	"github.com/Nubes3/file-service/internal/policy"
+	"github.com/Nubes3/file-service/internal/principal"
+	arango "github.com/Nubes3/file-service/internal/repo/arangodb"
+	"github.com/Nubes3/file-service/internal/repo/nats"
+	"github.com/gin-gonic/gin"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// The handlers below serve every route group. The group's authentication
+// middleware puts a principal on the context; the bucket comes from the
+// principal for keys and from the bucketId parameter for users, and the
+// principal's policy decides what may be done in it.
+
+// findBucket resolves the bucket of the request for its principal,
+// answering the request itself when that fails.
+func findBucket(c *gin.Context, bid string) (*arangodb.Bucket, bool) {
+	p, ok := principal.From(c)
+	if !ok {
+		apierror.Abort(c, http.StatusUnauthorized, "unauthorized")
+
+		return nil, false
+	}
+
+	bucket, err := p.Bucket(bid)
+	if err != nil {
+		apierror.Respond(c, err)
+
+		//_ = nats.SendErrorEvent(err.Error()+" at "+c.FullPath(),
+		//	"Db Error")
+		return nil, false
+	}
+
+	return bucket, true
+}
+
+func GetAllFile(c *gin.Context) {
+	getAllFile(c, false)
+}
+
+func GetAllFileIncludeHidden(c *gin.Context) {
+	getAllFile(c, true)
+}
+
+func getAllFile(c *gin.Context, showHidden bool) {
+	limit, err := strconv.ParseInt(c.DefaultQuery("limit", "10"), 10, 64)
+	if err != nil {
+		apierror.Abort(c, http.StatusBadRequest, "invalid limit format")
+
+		return
+	}
+	offset, err := strconv.ParseInt(c.DefaultQuery("offset", "0"), 10, 64)
+	if err != nil {
+		apierror.Abort(c, http.StatusBadRequest, "invalid offset format")
+
+		return
+	}
+
+	bucket, ok := findBucket(c, c.DefaultQuery("bucketId", ""))
+	if !ok {
+		return
+	}
+
+	// Bucket owners have always been shown their hidden files here.
+	if p, _ := principal.From(c); p.Kind == principal.User {
+		showHidden = true
+	}
+
+	pol := policy.FromContext(c)
+	res, err := arango.FindMetadataByBid(*bucket.Id, limit, offset, showHidden, c.QueryArray("tag"), pol.PathPattern())
+	if err != nil {
+		apierror.Respond(c, err)
+
+		//_ = nats.SendErrorEvent(err.Error()+" at files/all:",
+		//	"Db Error")
+		return
+	}
+
+	c.JSON(http.StatusOK, res)
+}
+
+func SearchFile(c *gin.Context) {
+	searchFile(c, false)
+}
+
+func SearchFileIncludeHidden(c *gin.Context) {
+	searchFile(c, true)
+}
+
+func searchFile(c *gin.Context, showHidden bool) {
+	limit, err := strconv.ParseInt(c.DefaultQuery("limit", "10"), 10, 64)
+	if err != nil {
+		apierror.Abort(c, http.StatusBadRequest, "invalid limit format")
+
+		return
+	}
+	offset, err := strconv.ParseInt(c.DefaultQuery("offset", "0"), 10, 64)
+	if err != nil {
+		apierror.Abort(c, http.StatusBadRequest, "invalid offset format")
+
+		return
+	}
+	mode, err := arango.ParseSearchMode(c.DefaultQuery("mode", "substring"))
+	if err != nil {
+		apierror.Abort(c, http.StatusBadRequest, err.Error())
+
+		return
+	}
+	q := c.DefaultQuery("q", "")
+	if q == "" {
+		apierror.Abort(c, http.StatusBadRequest, "missing q")
+
+		return
+	}
+
+	bucket, ok := findBucket(c, c.DefaultQuery("bucketId", ""))
+	if !ok {
+		return
+	}
+
+	// Bucket owners are shown their hidden files, as in getAllFile.
+	if p, _ := principal.From(c); p.Kind == principal.User {
+		showHidden = true
+	}
+
+	res, err := arango.SearchMetadata(*bucket.Id, c.DefaultQuery("field", "name"), q, mode, limit, offset, showHidden,
+		policy.FromContext(c).PathPattern())
+	if err != nil {
+		apierror.Respond(c, err)
+
+		//_ = nats.SendErrorEvent(err.Error()+" at files/search:",
+		//	"Db Error")
+		return
+	}
+
+	c.JSON(http.StatusOK, res)
+}
+
+func UploadFile(c *gin.Context) {
+	bucket, ok := findBucket(c, c.DefaultPostForm("bucket_id", ""))
+	if !ok {
+		return
+	}
+
+	uploadFile, err := c.FormFile("file")
+	if err != nil {
+		apierror.Abort(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	queryPath := c.DefaultPostForm("path", "/")
+	path := utils.StandardizedPath("/"+bucket.Name+"/"+queryPath, true)
+
+	fileName := c.DefaultPostForm("name", uploadFile.Filename)
+
+	fileContent, err := uploadFile.Open()
+	if err != nil {
+		apierror.Respond(c, err)
+
+		//_ = nats.SendErrorEvent("open file failed at /files/upload:",
+		//	"File Error")
+		return
+	}
+
+	fileSize := uploadFile.Size
+	ttl, err := strconv.ParseInt(c.DefaultPostForm("ttl", "0"), 10, 64)
+	if err != nil {
+		apierror.Abort(c, http.StatusBadRequest, "invalid ttl format")
+
+		return
+	}
+
+	isHidden, err := strconv.ParseBool(c.DefaultPostForm("hidden", "false"))
+	if err != nil {
+		apierror.Abort(c, http.StatusBadRequest, "invalid hidden format")
+
+		return
+	}
+
+	attrs, err := parseFileAttributes(c)
+	if err != nil {
+		apierror.Abort(c, http.StatusBadRequest, err.Error())
+
+		return
+	}
+
+	extract, err := strconv.ParseBool(c.DefaultPostForm("extract", "false"))
+	if err != nil {
+		apierror.Abort(c, http.StatusBadRequest, "invalid extract format")
+
+		return
+	}
+
+	if extract {
+		extractUpload(c, bucket, path, uploadFile, fileContent, isHidden, time.Duration(ttl)*time.Second, attrs)
+		return
+	}
+
+	if !policy.FromContext(c).AllowsPath(policy.FullPath(path, fileName)) {
+		apierror.Abort(c, http.StatusForbidden, "path outside of key scope")
+
+		return
+	}
+
+	cType, err := utils.GetFileContentType(fileContent)
+	if err != nil {
+		apierror.Abort(c, http.StatusBadRequest, "unknown file content type")
+
+		return
+	}
+
+	res, err := arango.SaveFile(fileContent, *bucket.Id, path, fileName, isHidden,
+		cType, fileSize, time.Duration(ttl)*time.Second, attrs)
+	if err != nil {
+		apierror.Respond(c, err)
+
+		//_ = nats.SendErrorEvent("db error at /files/upload: "+err.Error(),
+		//	"File Error")
+		return
+	}
+
+	c.JSON(http.StatusOK, res)
+}
+
+func DownloadFileById(c *gin.Context) {
+	bucket, ok := findBucket(c, c.DefaultQuery("bucketId", ""))
+	if !ok {
+		return
+	}
+
+	fileMeta, err := arango.FindMetadataById(c.DefaultQuery("fileId", ""))
+	if err != nil {
+		apierror.Respond(c, err)
+		return
+	}
+
+	downloadFile(c, bucket, fileMeta)
+}
+
+func DownloadFileByPath(c *gin.Context) {
+	fullpath := utils.StandardizedPath(c.Param("fullpath"), true)
+	bucketName := utils.GetBucketName(fullpath)
+	parentPath := utils.GetParentPath(fullpath)
+	fileName := utils.GetFileName(fullpath)
+
+	bucket, ok := findBucket(c, c.DefaultQuery("bucketId", ""))
+	if !ok {
+		return
+	}
+
+	if bucket.Name != bucketName {
+		apierror.Abort(c, http.StatusBadRequest, "invalid bucket name")
+
+		return
+	}
+
+	fileMeta, err := arango.FindMetadataByFilename(parentPath, fileName, *bucket.Id)
+	if err != nil {
+		apierror.Respond(c, err)
+		return
+	}
+
+	downloadFile(c, bucket, fileMeta)
+}
+
+// downloadFile streams a file of bucket, hiding the files the caller's
+// policy does not let it see.
+func downloadFile(c *gin.Context, bucket *arangodb.Bucket, fileMeta *arangodb.FileMetadata) {
+	p := policy.FromContext(c)
+	if fileMeta.BucketId != *bucket.Id || (fileMeta.IsHidden && !p.Has(policy.DownloadHidden)) ||
+		!p.AllowsFile(fileMeta) {
+		apierror.Abort(c, http.StatusNotFound, "file not found")
+
+		return
+	}
+
+	err := arango.DownloadFile(fileMeta, func(reader io.Reader) error {
+		extraHeaders := map[string]string{
+			"Content-Disposition": `attachment; filename=` + fileMeta.Name,
+		}
+
+		attrs, err := arango.FindAttributesById(fileMeta.Id)
+		if err != nil {
+			return err
+		}
+		withAttributeHeaders(extraHeaders, attrs)
+
+		c.DataFromReader(http.StatusOK, fileMeta.Size, fileMeta.ContentType, reader, extraHeaders)
+
+		_ = nats.SendDownloadFileEvent(fileMeta)
+
+		return nil
+	})
+
+	if err != nil {
+		apierror.Respond(c, err)
+
+		//_ = nats.SendErrorEvent("download failed: "+err.Error()+" at /files/download:",
+		//	"File Error")
+		return
+	}
+}
+
+func DownloadArchive(c *gin.Context) {
+	format, err := archive.ParseFormat(c.DefaultQuery("format", "zip"))
+	if err != nil {
+		apierror.Abort(c, http.StatusBadRequest, err.Error())
+
+		return
+	}
+
+	bucket, ok := findBucket(c, c.DefaultQuery("bucketId", ""))
+	if !ok {
+		return
+	}
+
+	base, files, err := resolveArchiveFiles(bucket, c.DefaultQuery("path", ""), c.QueryArray("fileId"),
+		policy.FromContext(c))
+	if err != nil {
+		apierror.Respond(c, err)
+		return
+	}
+
+	streamArchive(c, format, base, files)
+}
+
+// ToggleHidden hides or unhides a single file, given by fileId or by path
+// and name, or every file under the folder at path when no file is named.
+// It answers the files whose status changed.
+func ToggleHidden(c *gin.Context) {
+	isHidden, err := strconv.ParseBool(c.DefaultQuery("hidden", "false"))
+	if err != nil {
+		apierror.Abort(c, http.StatusBadRequest, "invalid hidden format")
+
+		return
+	}
+
+	bucket, ok := findBucket(c, c.DefaultQuery("bucketId", ""))
+	if !ok {
+		return
+	}
+
+	p := policy.FromContext(c)
+	fileId, path, name := c.DefaultQuery("fileId", ""), c.DefaultQuery("path", ""), c.DefaultQuery("name", "")
+	if fileId == "" && name == "" {
+		toggleFolderHidden(c, bucket, path, isHidden, p)
+		return
+	}
+
+	var fm *arangodb.FileMetadata
+	if fileId != "" {
+		fm, err = arango.FindMetadataById(fileId)
+	} else {
+		fm, err = arango.FindMetadataByFilename(path, name, *bucket.Id)
+	}
+	if err != nil || fm.BucketId != *bucket.Id || !p.AllowsFile(fm) {
+		apierror.Abort(c, http.StatusNotFound, "file not found")
+
+		//_ = nats.SendErrorEvent("find file failed at /files/hidden:",
+		//	"File Error")
+		return
+	}
+
+	files := []arangodb.FileMetadata{}
+	if fm.IsHidden != isHidden {
+		fm, err = arango.SetFileHidden(fm.Id, isHidden)
+		if err != nil {
+			apierror.Respond(c, err)
+
+			//_ = nats.SendErrorEvent("toggle failed at /files/hidden:",
+			//	"File Error")
+			return
+		}
+		files = append(files, *fm)
+	}
+
+	c.JSON(http.StatusOK, files)
+}
+
+func toggleFolderHidden(c *gin.Context, bucket *arangodb.Bucket, path string, isHidden bool, p *policy.Policy) {
+	path = utils.StandardizedPath(path, true)
+	if path == "" || utils.GetBucketName(path) != bucket.Name {
+		apierror.Abort(c, http.StatusBadRequest, "invalid path")
+
+		return
+	}
+
+	files, err := arango.SetFolderHidden(*bucket.Id, path, isHidden, p.AllowsFile)
+	if err != nil && files != nil {
+		// The files changed, only their folder entries may be out of date.
+		c.JSON(http.StatusMultiStatus, gin.H{
+			"files": files,
+			"error": err.Error(),
+		})
+
+		return
+	}
+	if err != nil {
+		apierror.Respond(c, err)
+
+		//_ = nats.SendErrorEvent("toggle failed at /files/hidden:",
+		//	"File Error")
+		return
+	}
+
+	c.JSON(http.StatusOK, files)
+}
+
+func GetFileMetadata(c *gin.Context) {
+	bucket, ok := findBucket(c, c.DefaultQuery("bucketId", ""))
+	if !ok {
+		return
+	}
+
+	detail, err := arango.FindMetadataDetailById(c.DefaultQuery("fileId", ""))
+	if err != nil {
+		apierror.Respond(c, err)
+		return
+	}
+
+	p := policy.FromContext(c)
+	if detail.BucketId != *bucket.Id || (detail.IsHidden && !p.Has(policy.GetFileListHidden)) ||
+		!p.AllowsFile(&detail.FileMetadata) {
+		apierror.Abort(c, http.StatusNotFound, "file not found")
+
+		return
+	}
+
+	respondFileMetadata(c, detail)
+}
+
+func UpdateFileMetadata(c *gin.Context) {
+	req, ok := bindUpdateAttributes(c)
+	if !ok {
+		return
+	}
+
+	bucket, ok := findBucket(c, c.DefaultQuery("bucketId", ""))
+	if !ok {
+		return
+	}
+
+	fm, err := arango.FindMetadataById(c.DefaultQuery("fileId", ""))
+	if err != nil {
+		apierror.Respond(c, err)
+		return
+	}
+
+	if fm.BucketId != *bucket.Id || !policy.FromContext(c).AllowsFile(fm) {
+		apierror.Abort(c, http.StatusNotFound, "file not found")
+
+		return
+	}
+
+	detail, err := arango.UpdateAttributes(fm.Id, req.Metadata, req.Tags)
+	if err != nil {
+		apierror.Respond(c, err)
+
+		//_ = nats.SendErrorEvent("update metadata failed at /files/metadata:",
+		//	"Db Error")
+		return
+	}
+
+	c.JSON(http.StatusOK, detail)
+}
+
+func BatchFile(c *gin.Context) {
+	bucket, ok := findBucket(c, c.DefaultQuery("bucketId", ""))
+	if !ok {
+		return
+	}
+
+	runBatch(c, bucket, policy.FromContext(c))
+}
+
+func StreamEvents(c *gin.Context) {
+	bucket, ok := findBucket(c, c.DefaultQuery("bucketId", ""))
+	if !ok {
+		return
+	}
+
+	streamBucketEvents(c, *bucket.Id, policy.FromContext(c))
+}
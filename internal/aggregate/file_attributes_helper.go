@@ -0,0 +1,151 @@
+package aggregate
+
+import (
+	"github.com/Nubes3/common/utils"
+	"github.com/Nubes3/file-service/internal/api/apierror"
+	arango "github.com/Nubes3/file-service/internal/repo/arangodb"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const (
+	metaPrefix      = "x-meta-"
+	tagsHeader      = "X-Tags"
+	maxMetadataSize = 2048
+)
+
+var metaKeyRegex = regexp.MustCompile(`^[a-z0-9_-]+$`)
+
+type updateAttributesReq struct {
+	Metadata map[string]*string `json:"metadata"`
+	Tags     []string           `json:"tags"`
+}
+
+// parseFileAttributes collects x-meta-* form fields and X-Meta-* headers as
+// metadata, and the comma separated "tags" form field and X-Tags header as tags.
+func parseFileAttributes(c *gin.Context) (*arango.FileAttributes, error) {
+	attrs := &arango.FileAttributes{
+		Metadata: map[string]string{},
+		Tags:     []string{},
+	}
+
+	for k, v := range c.Request.Header {
+		if strings.HasPrefix(strings.ToLower(k), metaPrefix) && len(v) > 0 {
+			attrs.Metadata[strings.ToLower(k[len(metaPrefix):])] = v[0]
+		}
+	}
+	if c.Request.MultipartForm != nil {
+		for k, v := range c.Request.MultipartForm.Value {
+			if strings.HasPrefix(strings.ToLower(k), metaPrefix) && len(v) > 0 {
+				attrs.Metadata[strings.ToLower(k[len(metaPrefix):])] = v[0]
+			}
+		}
+	}
+
+	rawTags := c.GetHeader(tagsHeader)
+	if formTags := c.DefaultPostForm("tags", ""); formTags != "" {
+		rawTags += "," + formTags
+	}
+	attrs.Tags = normalizeTags(strings.Split(rawTags, ","))
+
+	if err := validateMetadata(attrs.Metadata); err != nil {
+		return nil, err
+	}
+
+	return attrs, nil
+}
+
+func validateMetadata(metadata map[string]string) error {
+	size := 0
+	for k, v := range metadata {
+		if !metaKeyRegex.MatchString(k) {
+			return &utils.ModelError{
+				Msg:     "invalid metadata key: " + k,
+				ErrType: utils.Invalid,
+			}
+		}
+		size += len(k) + len(v)
+	}
+
+	if size > maxMetadataSize {
+		return &utils.ModelError{
+			Msg:     "metadata too large",
+			ErrType: utils.Invalid,
+		}
+	}
+
+	return nil
+}
+
+func normalizeTags(raw []string) []string {
+	tags := []string{}
+	seen := map[string]bool{}
+	for _, tag := range raw {
+		tag = strings.TrimSpace(tag)
+		if tag == "" || seen[tag] {
+			continue
+		}
+		seen[tag] = true
+		tags = append(tags, tag)
+	}
+
+	return tags
+}
+
+// bindUpdateAttributes parses a PATCH body, answering 400 itself on failure.
+func bindUpdateAttributes(c *gin.Context) (*updateAttributesReq, bool) {
+	var req updateAttributesReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		apierror.Abort(c, http.StatusBadRequest, err.Error())
+		return nil, false
+	}
+
+	check := map[string]string{}
+	for k, v := range req.Metadata {
+		if v != nil {
+			check[k] = *v
+		} else {
+			check[k] = ""
+		}
+	}
+	if err := validateMetadata(check); err != nil {
+		apierror.Abort(c, http.StatusBadRequest, err.Error())
+		return nil, false
+	}
+
+	if req.Tags != nil {
+		req.Tags = normalizeTags(req.Tags)
+	}
+
+	return &req, true
+}
+
+func withAttributeHeaders(headers map[string]string, attrs *arango.FileAttributes) map[string]string {
+	for k, v := range attrs.Metadata {
+		headers["X-Meta-"+k] = v
+	}
+	if len(attrs.Tags) > 0 {
+		headers[tagsHeader] = strings.Join(attrs.Tags, ",")
+	}
+
+	return headers
+}
+
+// respondFileMetadata writes the detail as JSON, or only as headers for HEAD.
+func respondFileMetadata(c *gin.Context, detail *arango.FileMetadataDetail) {
+	if c.Request.Method == http.MethodHead {
+		headers := withAttributeHeaders(map[string]string{}, &detail.FileAttributes)
+		for k, v := range headers {
+			c.Header(k, v)
+		}
+		c.Header("Content-Type", detail.ContentType)
+		c.Header("Content-Length", strconv.FormatInt(detail.Size, 10))
+		c.Status(http.StatusOK)
+		return
+	}
+
+	c.JSON(http.StatusOK, detail)
+}
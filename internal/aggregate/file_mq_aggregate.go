@@ -0,0 +1,224 @@
+package aggregate
+
+import (
+	"encoding/json"
+	"github.com/Nubes3/common/models/arangodb"
+	natsModel "github.com/Nubes3/common/models/nats"
+	"github.com/Nubes3/common/utils"
+	"github.com/Nubes3/file-service/internal/config"
+	arango "github.com/Nubes3/file-service/internal/repo/arangodb"
+	"github.com/Nubes3/file-service/internal/repo/nats"
+	natsGo "github.com/nats-io/nats.go"
+	"log"
+	"strconv"
+)
+
+// fileMqUpdate is the patch of an Update request. Absent fields are left
+// as they are; metadata and tags follow PATCH /metadata.
+type fileMqUpdate struct {
+	IsHidden *bool              `json:"is_hidden"`
+	Path     *string            `json:"path"`
+	Name     *string            `json:"name"`
+	Metadata map[string]*string `json:"metadata"`
+	Tags     []string           `json:"tags"`
+}
+
+// HandleFileRequest answers the requests other services send on the file
+// subject, with the same envelope the folder and bucket services use:
+//
+//	GetById       Data: file id
+//	GetByParams   Data: bucket id, ExtraData: [path, name]
+//	Update        Data: file id, ExtraData: [fileMqUpdate as JSON]
+//
+// Files are answered as FileMetadataDetail. Errors answer IsErr with a
+// natsModel.ErrMsg as data.
+func HandleFileRequest(m *natsGo.Msg) {
+	var msg natsModel.Msg
+	if err := json.Unmarshal(m.Data, &msg); err != nil {
+		respondFileMq(m, nil, &utils.ModelError{
+			Msg:     "invalid message",
+			ErrType: utils.Invalid,
+		})
+		return
+	}
+
+	var res interface{}
+	var err error
+	switch msg.ReqType {
+	case natsModel.GetById:
+		res, err = arango.FindMetadataDetailById(msg.Data)
+	case natsModel.GetByParams:
+		res, err = findFileMqByParams(msg)
+	case natsModel.Update:
+		res, err = updateFileMq(msg)
+	default:
+		err = &utils.ModelError{
+			Msg:     "unsupported request type " + strconv.Itoa(int(msg.ReqType)),
+			ErrType: utils.Invalid,
+		}
+	}
+
+	respondFileMq(m, res, err)
+}
+
+// HandleFileListRequest answers the listings sent on the file list subject,
+// which has a single request and ignores ReqType:
+//
+//	Data: bucket id, ExtraData: [limit, offset, showHidden], all optional
+//
+// Lists are answered as []FileMetadata, errors as in HandleFileRequest.
+func HandleFileListRequest(m *natsGo.Msg) {
+	var msg natsModel.Msg
+	if err := json.Unmarshal(m.Data, &msg); err != nil {
+		respondFileMq(m, nil, &utils.ModelError{
+			Msg:     "invalid message",
+			ErrType: utils.Invalid,
+		})
+		return
+	}
+
+	res, err := listFileMqByBucket(msg)
+	respondFileMq(m, res, err)
+}
+
+func findFileMqByParams(msg natsModel.Msg) (*arango.FileMetadataDetail, error) {
+	if len(msg.ExtraData) < 2 {
+		return nil, &utils.ModelError{
+			Msg:     "missing path or name",
+			ErrType: utils.Invalid,
+		}
+	}
+
+	fm, err := arango.FindMetadataByFilename(msg.ExtraData[0], msg.ExtraData[1], msg.Data)
+	if err != nil {
+		return nil, err
+	}
+
+	return arango.FindMetadataDetailById(fm.Id)
+}
+
+func listFileMqByBucket(msg natsModel.Msg) ([]arangodb.FileMetadata, error) {
+	limit, offset, showHidden := int64(10), int64(0), false
+
+	var err error
+	if len(msg.ExtraData) > 0 && msg.ExtraData[0] != "" {
+		limit, err = strconv.ParseInt(msg.ExtraData[0], 10, 64)
+	}
+	if err == nil && len(msg.ExtraData) > 1 && msg.ExtraData[1] != "" {
+		offset, err = strconv.ParseInt(msg.ExtraData[1], 10, 64)
+	}
+	if err == nil && len(msg.ExtraData) > 2 && msg.ExtraData[2] != "" {
+		showHidden, err = strconv.ParseBool(msg.ExtraData[2])
+	}
+	if err != nil || limit < 0 || offset < 0 || limit > int64(config.Conf.BatchMaxSize) {
+		return nil, &utils.ModelError{
+			Msg:     "invalid limit, offset or showHidden",
+			ErrType: utils.Invalid,
+		}
+	}
+
+	return arango.FindMetadataByBid(msg.Data, limit, offset, showHidden, nil, "")
+}
+
+// updateFileMq validates the whole patch before applying it, then moves the
+// file, toggles its hidden flag and updates its attributes in that order.
+// The update is not atomic: when a step fails the earlier ones stay applied
+// and the error is answered.
+func updateFileMq(msg natsModel.Msg) (*arango.FileMetadataDetail, error) {
+	var req fileMqUpdate
+	if len(msg.ExtraData) < 1 || json.Unmarshal([]byte(msg.ExtraData[0]), &req) != nil {
+		return nil, &utils.ModelError{
+			Msg:     "invalid update",
+			ErrType: utils.Invalid,
+		}
+	}
+
+	fm, err := arango.FindMetadataById(msg.Data)
+	if err != nil {
+		return nil, err
+	}
+
+	if req.Metadata != nil {
+		metadata := map[string]string{}
+		for k, v := range req.Metadata {
+			if v != nil {
+				metadata[k] = *v
+			}
+		}
+		if err := validateMetadata(metadata); err != nil {
+			return nil, err
+		}
+	}
+
+	var tags []string
+	if req.Tags != nil {
+		tags = normalizeTags(req.Tags)
+	}
+
+	move := req.Path != nil || req.Name != nil
+	path, name := fm.Path, fm.Name
+	if move {
+		if req.Path != nil {
+			path = utils.StandardizedPath(*req.Path, true)
+		}
+		if req.Name != nil {
+			name = *req.Name
+		}
+		if path == "" || name == "" {
+			return nil, &utils.ModelError{
+				Msg:     "invalid path or name",
+				ErrType: utils.Invalid,
+			}
+		}
+
+		bucket, err := nats.FindBucketById(fm.BucketId)
+		if err != nil {
+			return nil, err
+		}
+		if utils.GetBucketName(path) != bucket.Name {
+			return nil, &utils.ModelError{
+				Msg:     "path outside of the file's bucket",
+				ErrType: utils.Invalid,
+			}
+		}
+	}
+
+	if move {
+		if fm, err = arango.MoveFile(fm.Id, path, name); err != nil {
+			return nil, err
+		}
+	}
+
+	if req.IsHidden != nil && *req.IsHidden != fm.IsHidden {
+		if _, err = arango.SetFileHidden(fm.Id, *req.IsHidden); err != nil {
+			return nil, err
+		}
+	}
+
+	return arango.UpdateAttributes(fm.Id, req.Metadata, tags)
+}
+
+func respondFileMq(m *natsGo.Msg, res interface{}, err error) {
+	var rep natsModel.MsgResponse
+	if err != nil {
+		errMsg := natsModel.ErrMsg{
+			ErrType: utils.Other,
+			Message: err.Error(),
+		}
+		if e, ok := err.(*utils.ModelError); ok {
+			errMsg.ErrType = e.ErrType
+			errMsg.Message = e.Msg
+		}
+
+		data, _ := json.Marshal(errMsg)
+		rep = natsModel.MsgResponse{IsErr: true, Data: string(data)}
+	} else {
+		data, _ := json.Marshal(res)
+		rep = natsModel.MsgResponse{Data: string(data)}
+	}
+
+	repJson, _ := json.Marshal(rep)
+	if err := m.Respond(repJson); err != nil {
+		log.Println("respond file request failed: " + err.Error())
+	}
+}
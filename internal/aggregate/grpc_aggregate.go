@@ -0,0 +1,362 @@
+package aggregate
+
+import (
+	"context"
+	"github.com/Nubes3/common/models/arangodb"
+	"github.com/Nubes3/common/utils"
+	"github.com/Nubes3/file-service/internal/api/grpc-api/filepb"
+	"github.com/Nubes3/file-service/internal/metrics"
+	"github.com/Nubes3/file-service/internal/policy"
+	"github.com/Nubes3/file-service/internal/principal"
+	arango "github.com/Nubes3/file-service/internal/repo/arangodb"
+	"github.com/Nubes3/file-service/internal/repo/nats"
+	seaweed "github.com/Nubes3/file-service/internal/repo/seaweedfs"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"io"
+	"net/http"
+	"time"
+)
+
+// grpcChunkSize is the size of the content messages Download sends.
+const grpcChunkSize = 64 << 10
+
+// FileServer serves filepb.FileService. Like the REST handlers it only sees
+// the principal the interceptors produced, and the interceptors already
+// checked the permission each method requires.
+type FileServer struct {
+	filepb.UnimplementedFileServiceServer
+}
+
+func (s *FileServer) Upload(stream filepb.FileService_UploadServer) error {
+	req, err := stream.Recv()
+	if err != nil {
+		return err
+	}
+	header := req.GetHeader()
+	if header == nil {
+		return status.Error(codes.InvalidArgument, "first message must be the header")
+	}
+	if header.Name == "" || header.Size < 0 || header.TtlSeconds < 0 {
+		return status.Error(codes.InvalidArgument, "invalid header")
+	}
+
+	p, bucket, err := grpcBucket(stream.Context(), header.BucketId)
+	if err != nil {
+		return err
+	}
+
+	path := utils.StandardizedPath("/"+bucket.Name+"/"+header.Path, true)
+	if !p.Policy.AllowsPath(policy.FullPath(path, header.Name)) {
+		return status.Error(codes.PermissionDenied, "path outside of key scope")
+	}
+
+	attrs := &arango.FileAttributes{
+		Metadata: header.Metadata,
+		Tags:     normalizeTags(header.Tags),
+	}
+	if attrs.Metadata == nil {
+		attrs.Metadata = map[string]string{}
+	}
+	if err := validateMetadata(attrs.Metadata); err != nil {
+		return err
+	}
+
+	reader := &uploadReader{stream: stream, remaining: header.Size}
+	contentType := header.ContentType
+	if contentType == "" {
+		head := make([]byte, 512)
+		n, err := io.ReadFull(reader, head)
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			return err
+		}
+		reader.pending = head[:n]
+		contentType = http.DetectContentType(reader.pending)
+	}
+
+	fm, err := arango.SaveFile(reader, *bucket.Id, path, header.Name, header.Hidden,
+		contentType, header.Size, time.Duration(header.TtlSeconds)*time.Second, attrs)
+	if reader.err != nil {
+		// The storage error only says the content stopped, the stream
+		// knows why.
+		return reader.err
+	}
+	if err != nil {
+		//_ = nats.SendErrorEvent("db error at grpc upload: "+err.Error(),
+		//	"File Error")
+		return err
+	}
+
+	return stream.SendAndClose(grpcFile(fm))
+}
+
+// uploadReader reads the content messages of an Upload stream, failing
+// when they do not add up to the size of the header.
+type uploadReader struct {
+	stream    filepb.FileService_UploadServer
+	remaining int64
+	pending   []byte
+	err       error
+}
+
+func (r *uploadReader) Read(p []byte) (int, error) {
+	for len(r.pending) == 0 {
+		if r.err != nil {
+			return 0, r.err
+		}
+
+		req, err := r.stream.Recv()
+		if err == io.EOF {
+			if r.remaining > 0 {
+				r.err = status.Error(codes.InvalidArgument, "content shorter than size")
+				return 0, r.err
+			}
+			return 0, io.EOF
+		}
+		if err != nil {
+			r.err = err
+			return 0, err
+		}
+
+		chunk := req.GetChunk()
+		if int64(len(chunk)) > r.remaining {
+			r.err = status.Error(codes.InvalidArgument, "content longer than size")
+			return 0, r.err
+		}
+		r.remaining -= int64(len(chunk))
+		r.pending = chunk
+	}
+
+	n := copy(p, r.pending)
+	r.pending = r.pending[n:]
+	return n, nil
+}
+
+func (s *FileServer) Download(req *filepb.DownloadRequest, stream filepb.FileService_DownloadServer) error {
+	if req.Offset < 0 || req.Length < 0 {
+		return status.Error(codes.InvalidArgument, "invalid range")
+	}
+
+	p, bucket, err := grpcBucket(stream.Context(), req.BucketId)
+	if err != nil {
+		return err
+	}
+
+	fm, err := grpcFindFile(bucket, p.Policy, req.File, policy.DownloadHidden)
+	if err != nil {
+		return err
+	}
+
+	if req.Offset > fm.Size {
+		return status.Error(codes.OutOfRange, "offset beyond end of file")
+	}
+	length := fm.Size - req.Offset
+	if req.Length > 0 && req.Length < length {
+		length = req.Length
+	}
+
+	if err := stream.Send(&filepb.DownloadResponse{
+		Data: &filepb.DownloadResponse_File{File: grpcFile(fm)},
+	}); err != nil {
+		return err
+	}
+
+	if length > 0 {
+		body, err := seaweed.OpenBlob(fm.FileId, req.Offset)
+		if err != nil {
+			//_ = nats.SendErrorEvent("download failed: "+err.Error()+" at grpc download:",
+			//	"File Error")
+			return err
+		}
+		transfer := metrics.Track(metrics.Download, fm.BucketId, body)
+		defer transfer.Close()
+
+		reader := io.LimitReader(transfer, length)
+		buf := make([]byte, grpcChunkSize)
+		for {
+			n, err := io.ReadFull(reader, buf)
+			if n > 0 {
+				if err := stream.Send(&filepb.DownloadResponse{
+					Data: &filepb.DownloadResponse_Chunk{Chunk: buf[:n]},
+				}); err != nil {
+					return err
+				}
+			}
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				break
+			}
+			if err != nil {
+				return &utils.ModelError{
+					Msg:     err.Error(),
+					ErrType: utils.FsError,
+				}
+			}
+		}
+	}
+
+	_ = nats.SendDownloadFileEvent(fm)
+
+	return nil
+}
+
+func (s *FileServer) Stat(ctx context.Context, req *filepb.FileRequest) (*filepb.FileDetail, error) {
+	p, bucket, err := grpcBucket(ctx, req.BucketId)
+	if err != nil {
+		return nil, err
+	}
+
+	fm, err := grpcFindFile(bucket, p.Policy, req.File, policy.GetFileListHidden)
+	if err != nil {
+		return nil, err
+	}
+
+	attrs, err := arango.FindAttributesById(fm.Id)
+	if err != nil {
+		return nil, err
+	}
+
+	return &filepb.FileDetail{
+		File:     grpcFile(fm),
+		Metadata: attrs.Metadata,
+		Tags:     attrs.Tags,
+	}, nil
+}
+
+func (s *FileServer) List(ctx context.Context, req *filepb.ListRequest) (*filepb.ListResponse, error) {
+	p, bucket, err := grpcBucket(ctx, req.BucketId)
+	if err != nil {
+		return nil, err
+	}
+
+	if req.IncludeHidden && !p.Policy.Has(policy.GetFileListHidden) {
+		return nil, status.Error(codes.PermissionDenied, "missing permission "+policy.GetFileListHidden.String())
+	}
+	if req.Limit < 0 || req.Offset < 0 {
+		return nil, status.Error(codes.InvalidArgument, "invalid limit or offset")
+	}
+	limit := req.Limit
+	if limit == 0 {
+		limit = 10
+	}
+
+	files, err := arango.FindMetadataByBid(*bucket.Id, limit, req.Offset, req.IncludeHidden, req.Tags,
+		p.Policy.PathPattern())
+	if err != nil {
+		//_ = nats.SendErrorEvent(err.Error()+" at grpc list:",
+		//	"Db Error")
+		return nil, err
+	}
+
+	res := &filepb.ListResponse{Files: []*filepb.File{}}
+	for _, fm := range files {
+		fm := fm
+		res.Files = append(res.Files, grpcFile(&fm))
+	}
+
+	return res, nil
+}
+
+func (s *FileServer) Delete(ctx context.Context, req *filepb.FileRequest) (*filepb.File, error) {
+	p, bucket, err := grpcBucket(ctx, req.BucketId)
+	if err != nil {
+		return nil, err
+	}
+
+	fm, err := grpcFindFile(bucket, p.Policy, req.File, policy.DeleteFile)
+	if err != nil {
+		return nil, err
+	}
+
+	fm, err = arango.DeleteFile(fm.Id)
+	if err != nil {
+		return nil, err
+	}
+
+	return grpcFile(fm), nil
+}
+
+func (s *FileServer) SetHidden(ctx context.Context, req *filepb.SetHiddenRequest) (*filepb.File, error) {
+	p, bucket, err := grpcBucket(ctx, req.BucketId)
+	if err != nil {
+		return nil, err
+	}
+
+	fm, err := grpcFindFile(bucket, p.Policy, req.File, policy.MarkHidden)
+	if err != nil {
+		return nil, err
+	}
+
+	fm, err = arango.SetFileHidden(fm.Id, req.Hidden)
+	if err != nil {
+		return nil, err
+	}
+
+	return grpcFile(fm), nil
+}
+
+// grpcBucket resolves the bucket of a call for the principal the
+// interceptors stored on ctx.
+func grpcBucket(ctx context.Context, bid string) (*principal.Principal, *arangodb.Bucket, error) {
+	p, ok := principal.FromContext(ctx)
+	if !ok {
+		return nil, nil, status.Error(codes.Unauthenticated, "unauthorized")
+	}
+
+	bucket, err := p.Bucket(bid)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return p, bucket, nil
+}
+
+// grpcFindFile resolves ref in bucket, hiding the files outside the
+// policy's scopes and the hidden ones unless it holds hiddenPerm.
+func grpcFindFile(bucket *arangodb.Bucket, p *policy.Policy, ref *filepb.FileRef,
+	hiddenPerm policy.Permission) (*arangodb.FileMetadata, error) {
+	notFound := &utils.ModelError{
+		Msg:     "file not found",
+		ErrType: utils.NotFound,
+	}
+	if ref == nil {
+		return nil, status.Error(codes.InvalidArgument, "missing file")
+	}
+
+	var fm *arangodb.FileMetadata
+	var err error
+	if ref.Id != "" {
+		fm, err = arango.FindMetadataById(ref.Id)
+		if err != nil {
+			return nil, notFound
+		}
+	} else {
+		if ref.Name == "" {
+			return nil, status.Error(codes.InvalidArgument, "missing file id or name")
+		}
+		path := utils.StandardizedPath("/"+bucket.Name+"/"+ref.Path, true)
+		fm, err = arango.FindMetadataByFilename(path, ref.Name, *bucket.Id)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if fm.BucketId != *bucket.Id || (fm.IsHidden && !p.Has(hiddenPerm)) || !p.AllowsFile(fm) {
+		return nil, notFound
+	}
+
+	return fm, nil
+}
+
+func grpcFile(fm *arangodb.FileMetadata) *filepb.File {
+	return &filepb.File{
+		Id:          fm.Id,
+		BucketId:    fm.BucketId,
+		Path:        fm.Path,
+		Name:        fm.Name,
+		ContentType: fm.ContentType,
+		Size:        fm.Size,
+		IsHidden:    fm.IsHidden,
+		ExpiredDate: timestamppb.New(fm.ExpiredDate),
+	}
+}
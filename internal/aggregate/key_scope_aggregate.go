@@ -0,0 +1,130 @@
+package aggregate
+
+import (
+	"github.com/Nubes3/common/models/arangodb"
+	"github.com/Nubes3/common/utils"
+	"github.com/Nubes3/file-service/internal/api/apierror"
+	"github.com/Nubes3/file-service/internal/policy"
+	arango "github.com/Nubes3/file-service/internal/repo/arangodb"
+	"github.com/Nubes3/file-service/internal/repo/nats"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"strings"
+)
+
+type setKeyScopesReq struct {
+	Scopes []string `json:"scopes"`
+}
+
+// keyScopeTarget reads which key of the owned bucket a scope request is
+// about, from the accessKey or keyPair (public key) query parameter.
+func keyScopeTarget(c *gin.Context) (string, string, bool) {
+	if key := c.DefaultQuery("accessKey", ""); key != "" {
+		return arango.AccessKeyScope, key, true
+	}
+	if key := c.DefaultQuery("keyPair", ""); key != "" {
+		return arango.KeyPairScope, key, true
+	}
+
+	apierror.Abort(c, http.StatusBadRequest, "missing accessKey or keyPair")
+	return "", "", false
+}
+
+// checkKeyOfBucket aborts unless the key exists and belongs to bucket, so an
+// owner cannot scope, or unscope, keys of buckets they do not own.
+func checkKeyOfBucket(c *gin.Context, kind string, key string, bucket *arangodb.Bucket) bool {
+	var bid string
+	var err error
+	if kind == arango.AccessKeyScope {
+		var accessKey *arangodb.AccessKey
+		if accessKey, err = nats.FindAccessKeyByKey(key); err == nil {
+			bid = accessKey.BucketId
+		}
+	} else {
+		var keyPair *arangodb.KeyPair
+		if keyPair, err = nats.FindKeyPairByPublic(key); err == nil {
+			bid = keyPair.BucketId
+		}
+	}
+
+	if e, ok := err.(*utils.ModelError); ok && e.ErrType == utils.Timeout {
+		apierror.Respond(c, err)
+		return false
+	}
+	if err != nil || bid != *bucket.Id {
+		apierror.Abort(c, http.StatusNotFound, "key not found in bucket")
+		return false
+	}
+
+	return true
+}
+
+func GetKeyScopesAuth(c *gin.Context) {
+	kind, key, ok := keyScopeTarget(c)
+	if !ok {
+		return
+	}
+
+	bucket, ok := findBucket(c, c.DefaultQuery("bucketId", ""))
+	if !ok || !checkKeyOfBucket(c, kind, key, bucket) {
+		return
+	}
+
+	scopes, err := arango.FindKeyScopes(kind, key, *bucket.Id)
+	if err != nil {
+		apierror.Respond(c, err)
+
+		//_ = nats.SendErrorEvent(err.Error()+" at auth/files/scopes:",
+		//	"Db Error")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"scopes": scopes,
+	})
+}
+
+func SetKeyScopesAuth(c *gin.Context) {
+	kind, key, ok := keyScopeTarget(c)
+	if !ok {
+		return
+	}
+
+	var req setKeyScopesReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		apierror.Abort(c, http.StatusBadRequest, err.Error())
+
+		return
+	}
+
+	bucket, ok := findBucket(c, c.DefaultQuery("bucketId", ""))
+	if !ok || !checkKeyOfBucket(c, kind, key, bucket) {
+		return
+	}
+
+	for _, pattern := range req.Scopes {
+		_, err := policy.ParseScope(pattern)
+		if err != nil {
+			apierror.Respond(c, err)
+			return
+		}
+		if !strings.HasPrefix(pattern+"/", "/"+bucket.Name+"/") {
+			apierror.Abort(c, http.StatusBadRequest, "scope outside of bucket: "+pattern)
+
+			return
+		}
+	}
+
+	err := arango.SetKeyScopes(kind, key, *bucket.Id, req.Scopes)
+	if err != nil {
+		apierror.Respond(c, err)
+
+		//_ = nats.SendErrorEvent(err.Error()+" at auth/files/scopes:",
+		//	"Db Error")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"scopes": req.Scopes,
+	})
+}
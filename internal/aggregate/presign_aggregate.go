@@ -0,0 +1,310 @@
+package aggregate
+
+import (
+	"github.com/Nubes3/common/models/arangodb"
+	"github.com/Nubes3/common/utils"
+	"github.com/Nubes3/file-service/internal/api/apierror"
+	"github.com/Nubes3/file-service/internal/config"
+	"github.com/Nubes3/file-service/internal/policy"
+	"github.com/Nubes3/file-service/internal/presign"
+	arango "github.com/Nubes3/file-service/internal/repo/arangodb"
+	"github.com/Nubes3/file-service/internal/repo/nats"
+	"github.com/gin-gonic/gin"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+type presignUploadReq struct {
+	Path         string   `json:"path"`
+	Name         string   `json:"name"`
+	MaxSize      int64    `json:"max_size"`
+	ContentTypes []string `json:"content_types"`
+	Ttl          int64    `json:"ttl"`
+}
+
+// multipartOverhead is the room left above a policy's max size for the
+// multipart envelope of the upload request.
+const multipartOverhead = 1 << 20
+
+// PresignDownload mints a download URL for a file the caller may download.
+// Anyone holding the URL can fetch the file until it expires.
+func PresignDownload(c *gin.Context) {
+	if !presign.Enabled() {
+		apierror.Abort(c, http.StatusServiceUnavailable, "presigned urls are disabled")
+
+		return
+	}
+
+	ttl, err := strconv.ParseInt(c.DefaultQuery("ttl", "0"), 10, 64)
+	if err != nil {
+		apierror.Abort(c, http.StatusBadRequest, "invalid ttl format")
+
+		return
+	}
+	expiresAt, err := presign.Expiry(ttl)
+	if err != nil {
+		apierror.Respond(c, err)
+		return
+	}
+
+	bucket, ok := findBucket(c, c.DefaultQuery("bucketId", ""))
+	if !ok {
+		return
+	}
+
+	fileMeta, err := arango.FindMetadataById(c.DefaultQuery("fileId", ""))
+	if err != nil {
+		apierror.Respond(c, err)
+		return
+	}
+
+	p := policy.FromContext(c)
+	if fileMeta.BucketId != *bucket.Id || (fileMeta.IsHidden && !p.Has(policy.DownloadHidden)) ||
+		!p.AllowsFile(fileMeta) {
+		apierror.Abort(c, http.StatusNotFound, "file not found")
+
+		return
+	}
+
+	expires := expiresAt.Unix()
+	query := url.Values{}
+	query.Set("fileId", fileMeta.Id)
+	query.Set("expires", strconv.FormatInt(expires, 10))
+	query.Set("signature", presign.SignDownload(fileMeta.Id, expires))
+
+	c.JSON(http.StatusOK, gin.H{
+		"url":        config.Conf.PublicUrl + "/public/files/download?" + query.Encode(),
+		"expires_at": expiresAt,
+	})
+}
+
+// DownloadPresigned serves a URL minted by PresignDownload. It needs no
+// session: the signature is the authorisation.
+func DownloadPresigned(c *gin.Context) {
+	fileId := c.DefaultQuery("fileId", "")
+	expires, err := strconv.ParseInt(c.DefaultQuery("expires", ""), 10, 64)
+	if err != nil {
+		apierror.Abort(c, http.StatusBadRequest, "invalid expires format")
+
+		return
+	}
+
+	if !respondPresignError(c, presign.VerifyDownload(fileId, expires, c.DefaultQuery("signature", ""))) {
+		return
+	}
+
+	err = arango.GetFileByFid(fileId, func(reader io.Reader, fileMeta *arangodb.FileMetadata) error {
+		extraHeaders := map[string]string{
+			"Content-Disposition": `attachment; filename=` + fileMeta.Name,
+		}
+
+		attrs, err := arango.FindAttributesById(fileMeta.Id)
+		if err != nil {
+			return err
+		}
+		withAttributeHeaders(extraHeaders, attrs)
+
+		c.DataFromReader(http.StatusOK, fileMeta.Size, fileMeta.ContentType, reader, extraHeaders)
+
+		_ = nats.SendDownloadFileEvent(fileMeta)
+
+		return nil
+	})
+
+	if err != nil {
+		apierror.Respond(c, err)
+
+		//_ = nats.SendErrorEvent("download failed: "+err.Error()+" at /public/files/download:",
+		//	"File Error")
+		return
+	}
+}
+
+// PresignUpload mints an upload URL that lets a browser store one file in
+// the caller's bucket without credentials, within the constraints of the
+// signed policy.
+func PresignUpload(c *gin.Context) {
+	if !presign.Enabled() {
+		apierror.Abort(c, http.StatusServiceUnavailable, "presigned urls are disabled")
+
+		return
+	}
+
+	var req presignUploadReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		apierror.Abort(c, http.StatusBadRequest, err.Error())
+
+		return
+	}
+
+	if req.MaxSize == 0 {
+		req.MaxSize = config.Conf.PresignUploadSize
+	}
+	if req.MaxSize < 0 || req.MaxSize > config.Conf.PresignUploadSize {
+		apierror.Abort(c, http.StatusBadRequest, "max size must be between 1 and "+
+			strconv.FormatInt(config.Conf.PresignUploadSize, 10))
+
+		return
+	}
+
+	for _, contentType := range req.ContentTypes {
+		if _, _, err := mime.ParseMediaType(contentType); err != nil {
+			apierror.Abort(c, http.StatusBadRequest, "invalid content type: "+contentType)
+
+			return
+		}
+	}
+
+	expiresAt, err := presign.Expiry(req.Ttl)
+	if err != nil {
+		apierror.Respond(c, err)
+		return
+	}
+
+	bucket, ok := findBucket(c, c.DefaultQuery("bucketId", ""))
+	if !ok {
+		return
+	}
+
+	path := utils.StandardizedPath("/"+bucket.Name+"/"+req.Path, true)
+
+	// A scoped key may only presign the exact file its scopes allow, as
+	// nobody checks the scopes again when the upload arrives.
+	p := policy.FromContext(c)
+	if p.Scoped() && req.Name == "" {
+		apierror.Abort(c, http.StatusBadRequest, "name is required for scoped keys")
+
+		return
+	}
+	if req.Name != "" && !p.AllowsPath(policy.FullPath(path, req.Name)) {
+		apierror.Abort(c, http.StatusForbidden, "path outside of key scope")
+
+		return
+	}
+
+	uploadPolicy := &presign.UploadPolicy{
+		BucketId:     *bucket.Id,
+		Path:         path,
+		Name:         req.Name,
+		MaxSize:      req.MaxSize,
+		ContentTypes: req.ContentTypes,
+		Expires:      expiresAt.Unix(),
+	}
+	encoded, signature, err := presign.SignUpload(uploadPolicy)
+	if err != nil {
+		apierror.Respond(c, err)
+		return
+	}
+
+	query := url.Values{}
+	query.Set("policy", encoded)
+	query.Set("signature", signature)
+
+	c.JSON(http.StatusOK, gin.H{
+		"url":        config.Conf.PublicUrl + "/public/files/upload?" + query.Encode(),
+		"policy":     uploadPolicy,
+		"expires_at": expiresAt,
+	})
+}
+
+// UploadPresigned stores the "file" form field of a multipart request sent
+// to a URL minted by PresignUpload, rejecting anything the policy does not
+// allow.
+func UploadPresigned(c *gin.Context) {
+	uploadPolicy, err := presign.VerifyUpload(c.DefaultQuery("policy", ""), c.DefaultQuery("signature", ""))
+	if !respondPresignError(c, err) {
+		return
+	}
+
+	limit := uploadPolicy.MaxSize + multipartOverhead
+	if c.Request.ContentLength > limit {
+		apierror.Abort(c, http.StatusRequestEntityTooLarge, "file too large")
+
+		return
+	}
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, limit)
+
+	uploadFile, err := c.FormFile("file")
+	if err != nil {
+		apierror.Abort(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if uploadFile.Size > uploadPolicy.MaxSize {
+		apierror.Abort(c, http.StatusRequestEntityTooLarge, "file too large")
+
+		return
+	}
+
+	fileName := uploadPolicy.Name
+	if fileName == "" {
+		fileName = c.DefaultPostForm("name", uploadFile.Filename)
+	}
+	if fileName == "" {
+		apierror.Abort(c, http.StatusBadRequest, "missing file name")
+
+		return
+	}
+
+	attrs, err := parseFileAttributes(c)
+	if err != nil {
+		apierror.Abort(c, http.StatusBadRequest, err.Error())
+
+		return
+	}
+
+	fileContent, err := uploadFile.Open()
+	if err != nil {
+		apierror.Respond(c, err)
+
+		//_ = nats.SendErrorEvent("open file failed at /public/files/upload:",
+		//	"File Error")
+		return
+	}
+
+	cType, err := utils.GetFileContentType(fileContent)
+	if err != nil {
+		apierror.Abort(c, http.StatusBadRequest, "unknown file content type")
+
+		return
+	}
+
+	if !uploadPolicy.AllowsContentType(cType) {
+		apierror.Abort(c, http.StatusUnsupportedMediaType, "content type not allowed: "+cType)
+
+		return
+	}
+
+	res, err := arango.SaveFile(fileContent, uploadPolicy.BucketId, uploadPolicy.Path, fileName, false,
+		cType, uploadFile.Size, time.Duration(0), attrs)
+	if err != nil {
+		apierror.Respond(c, err)
+
+		//_ = nats.SendErrorEvent("db error at /public/files/upload: "+err.Error(),
+		//	"File Error")
+		return
+	}
+
+	c.JSON(http.StatusOK, res)
+}
+
+// respondPresignError answers a failed signature check, reporting an
+// expired URL as such and any other failure as forbidden. It returns true
+// when err is nil.
+func respondPresignError(c *gin.Context, err error) bool {
+	if err == nil {
+		return true
+	}
+
+	if e, ok := err.(*utils.ModelError); ok && e.ErrType == utils.Expired {
+		apierror.Respond(c, err)
+		return false
+	}
+
+	apierror.Abort(c, http.StatusForbidden, err.Error())
+	return false
+}
@@ -0,0 +1,453 @@
+package aggregate
+
+import (
+	"encoding/base64"
+	"github.com/Nubes3/common/models/arangodb"
+	"github.com/Nubes3/common/utils"
+	"github.com/Nubes3/file-service/internal/api/middlewares"
+	"github.com/Nubes3/file-service/internal/api/s3error"
+	"github.com/Nubes3/file-service/internal/policy"
+	"github.com/Nubes3/file-service/internal/principal"
+	arango "github.com/Nubes3/file-service/internal/repo/arangodb"
+	"github.com/Nubes3/file-service/internal/repo/nats"
+	"github.com/gin-gonic/gin"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// S3ListBuckets lists the buckets the key pair can reach, which is only the
+// one it was generated for.
+func S3ListBuckets(c *gin.Context) {
+	p, _ := principal.From(c)
+	bucket, err := p.Bucket("")
+	if err != nil {
+		s3error.Respond(c, err)
+		return
+	}
+
+	c.XML(http.StatusOK, s3ListBucketsResult{
+		Xmlns: s3Namespace,
+		Owner: s3Owner{ID: bucket.Uid, DisplayName: bucket.Uid},
+		Buckets: []s3Bucket{
+			{Name: bucket.Name, CreationDate: bucket.CreatedAt},
+		},
+	})
+}
+
+// S3HeadBucket tells clients whether the bucket exists and is reachable.
+func S3HeadBucket(c *gin.Context) {
+	if _, ok := s3ResolveBucket(c); !ok {
+		return
+	}
+
+	c.Status(http.StatusOK)
+}
+
+// S3GetBucket serves ListObjectsV2, and the bucket location some clients
+// ask for before anything else.
+func S3GetBucket(c *gin.Context) {
+	bucket, ok := s3ResolveBucket(c)
+	if !ok {
+		return
+	}
+
+	if _, ok := c.GetQuery("location"); ok {
+		c.XML(http.StatusOK, s3LocationConstraint{Xmlns: s3Namespace, Region: bucket.Region})
+		return
+	}
+
+	s3ListObjects(c, bucket)
+}
+
+func s3ListObjects(c *gin.Context, bucket *arangodb.Bucket) {
+	if !s3Require(c, policy.GetFileList) {
+		return
+	}
+
+	prefix := c.Query("prefix")
+	delimiter := c.Query("delimiter")
+	maxKeys := s3MaxKeys
+	if raw := c.Query("max-keys"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 0 {
+			s3error.Abort(c, http.StatusBadRequest, s3error.InvalidArgument, "invalid max-keys")
+
+			return
+		}
+		if n < maxKeys {
+			maxKeys = n
+		}
+	}
+
+	marker := c.Query("start-after")
+	token := c.Query("continuation-token")
+	if token != "" {
+		decoded, err := base64.RawURLEncoding.DecodeString(token)
+		if err != nil {
+			s3error.Abort(c, http.StatusBadRequest, s3error.InvalidArgument, "invalid continuation token")
+
+			return
+		}
+		marker = string(decoded)
+	}
+
+	// Only the folder holding the prefix, and what is below it, can match.
+	base := "/" + bucket.Name
+	dir := base
+	if i := strings.LastIndex(prefix, "/"); i > 0 {
+		dir += "/" + strings.Trim(prefix[:i], "/")
+	}
+
+	// One entry more than a page tells whether the listing is truncated.
+	p := policy.FromContext(c)
+	entries, err := arango.ListObjects(*bucket.Id, bucket.Name, dir, prefix, delimiter, marker,
+		maxKeys+1, p.Has(policy.GetFileListHidden), p.PathPattern())
+	if err != nil {
+		s3error.Respond(c, err)
+		return
+	}
+
+	encode := func(s string) string { return s }
+	res := s3ListObjectsResult{
+		Xmlns:             s3Namespace,
+		Name:              bucket.Name,
+		Delimiter:         delimiter,
+		MaxKeys:           maxKeys,
+		ContinuationToken: token,
+		StartAfter:        c.Query("start-after"),
+		Contents:          []s3Object{},
+		CommonPrefixes:    []s3CommonPrefix{},
+	}
+	if c.Query("encoding-type") == "url" {
+		res.EncodingType = "url"
+		encode = url.QueryEscape
+		res.StartAfter = encode(res.StartAfter)
+	}
+	res.Prefix = encode(prefix)
+	res.Delimiter = encode(delimiter)
+
+	// A page ends either on a key or on a whole common prefix and the token
+	// resumes after it.
+	last := ""
+	for _, entry := range entries {
+		if res.KeyCount == maxKeys {
+			res.IsTruncated = true
+			res.NextContinuationToken = base64.RawURLEncoding.EncodeToString([]byte(last))
+			break
+		}
+
+		if entry.Prefix {
+			res.CommonPrefixes = append(res.CommonPrefixes, s3CommonPrefix{Prefix: encode(entry.Key)})
+		} else {
+			res.Contents = append(res.Contents, s3Object{
+				Key:          encode(entry.Key),
+				LastModified: entry.File.UploadedDate,
+				ETag:         s3ETag(entry.File),
+				Size:         entry.File.Size,
+				StorageClass: s3StorageClass,
+			})
+		}
+		res.KeyCount++
+		last = entry.Key
+	}
+
+	c.XML(http.StatusOK, res)
+}
+
+// S3GetObject streams an object, or the requested byte range of it.
+func S3GetObject(c *gin.Context) {
+	bucket, ok := s3ResolveBucket(c)
+	if !ok {
+		return
+	}
+
+	key := strings.TrimPrefix(c.Param("key"), "/")
+	if key == "" {
+		if _, ok := c.GetQuery("location"); ok {
+			c.XML(http.StatusOK, s3LocationConstraint{Xmlns: s3Namespace, Region: bucket.Region})
+			return
+		}
+		s3ListObjects(c, bucket)
+		return
+	}
+
+	if !s3Require(c, policy.Download) {
+		return
+	}
+
+	fm, ok := s3FindObject(c, bucket, key)
+	if !ok {
+		return
+	}
+
+	start, length, partial, ok := parseByteRange(c.GetHeader("Range"), fm.Size)
+	if !ok {
+		c.Header("Content-Range", "bytes */"+strconv.FormatInt(fm.Size, 10))
+		s3error.Abort(c, http.StatusRequestedRangeNotSatisfiable, s3error.InvalidRange, "invalid range")
+
+		return
+	}
+
+	if err := s3ObjectHeaders(c, fm); err != nil {
+		s3error.Respond(c, err)
+		return
+	}
+
+	err := arango.DownloadFile(fm, func(reader io.Reader) error {
+		status := http.StatusOK
+		extraHeaders := map[string]string{}
+		if partial {
+			if _, err := io.CopyN(ioutil.Discard, reader, start); err != nil {
+				return err
+			}
+			reader = io.LimitReader(reader, length)
+			status = http.StatusPartialContent
+			extraHeaders["Content-Range"] = "bytes " + strconv.FormatInt(start, 10) + "-" +
+				strconv.FormatInt(start+length-1, 10) + "/" + strconv.FormatInt(fm.Size, 10)
+		}
+
+		c.DataFromReader(status, length, fm.ContentType, reader, extraHeaders)
+
+		_ = nats.SendDownloadFileEvent(fm)
+
+		return nil
+	})
+
+	if err != nil {
+		s3error.Respond(c, err)
+
+		//_ = nats.SendErrorEvent("download failed: "+err.Error()+" at s3 GetObject:",
+		//	"File Error")
+		return
+	}
+}
+
+func S3HeadObject(c *gin.Context) {
+	bucket, ok := s3ResolveBucket(c)
+	if !ok {
+		return
+	}
+
+	key := strings.TrimPrefix(c.Param("key"), "/")
+	if key == "" {
+		c.Status(http.StatusOK)
+		return
+	}
+
+	if !s3Require(c, policy.Download) {
+		return
+	}
+
+	fm, ok := s3FindObject(c, bucket, key)
+	if !ok {
+		return
+	}
+
+	if err := s3ObjectHeaders(c, fm); err != nil {
+		s3error.Respond(c, err)
+		return
+	}
+	c.Header("Content-Type", fm.ContentType)
+	c.Header("Content-Length", strconv.FormatInt(fm.Size, 10))
+	c.Status(http.StatusOK)
+}
+
+// S3PutObject stores the request body at the key, replacing any existing
+// object, or copies another object there when x-amz-copy-source is set. A
+// key ending in a slash creates the folder instead.
+func S3PutObject(c *gin.Context) {
+	bucket, ok := s3ResolveBucket(c)
+	if !ok {
+		return
+	}
+
+	if !s3Require(c, policy.Upload) {
+		return
+	}
+
+	key := strings.TrimPrefix(c.Param("key"), "/")
+	if strings.HasSuffix(key, "/") {
+		s3PutFolder(c, bucket, strings.TrimSuffix(key, "/"))
+		return
+	}
+
+	path, name, ok := s3ObjectPath(bucket, key)
+	if !ok {
+		s3error.Abort(c, http.StatusBadRequest, s3error.InvalidArgument, "invalid object key")
+
+		return
+	}
+
+	if !policy.FromContext(c).AllowsPath(policy.FullPath(path, name)) {
+		s3error.Abort(c, http.StatusForbidden, s3error.AccessDenied, "path outside of key scope")
+
+		return
+	}
+
+	if source := c.GetHeader("x-amz-copy-source"); source != "" {
+		s3CopyObject(c, bucket, source, path, name)
+		return
+	}
+
+	body, size := middlewares.S3Body(c)
+	if size < 0 {
+		s3error.Abort(c, http.StatusLengthRequired, s3error.MissingContentLength, "missing content length")
+
+		return
+	}
+
+	attrs, err := s3Metadata(c)
+	if err != nil {
+		s3error.Respond(c, err)
+		return
+	}
+
+	cType := c.GetHeader("Content-Type")
+	if cType == "" {
+		cType, body = sniffContentType(body)
+	}
+
+	fm, err := s3StoreObject(bucket, path, name, io.LimitReader(body, size), size, cType, attrs)
+	if err != nil {
+		s3error.Respond(c, err)
+
+		//_ = nats.SendErrorEvent("db error at s3 PutObject: "+err.Error(),
+		//	"File Error")
+		return
+	}
+
+	c.Header("ETag", s3ETag(fm))
+	c.Status(http.StatusOK)
+}
+
+func s3PutFolder(c *gin.Context, bucket *arangodb.Bucket, key string) {
+	if key != "" {
+		path, name, ok := s3ObjectPath(bucket, key)
+		if !ok {
+			s3error.Abort(c, http.StatusBadRequest, s3error.InvalidArgument, "invalid object key")
+
+			return
+		}
+
+		// Folders are created through the folder service, so only an
+		// existing one can be put.
+		base := "/" + bucket.Name
+		if err := checkFolders(base, strings.TrimPrefix(path, base)+"/"+name,
+			map[string]bool{base: true}); err != nil {
+			s3error.Abort(c, http.StatusNotImplemented, s3error.NotImplemented, "folders cannot be created here")
+			return
+		}
+	}
+
+	c.Status(http.StatusOK)
+}
+
+// s3CopyObject copies the object named by source, "bucket/key" optionally
+// URL encoded, to path/name. Metadata is copied unless the request asks to
+// replace it.
+func s3CopyObject(c *gin.Context, bucket *arangodb.Bucket, source string, path string, name string) {
+	if i := strings.Index(source, "?"); i >= 0 {
+		source = source[:i]
+	}
+	if decoded, err := url.PathUnescape(source); err == nil {
+		source = decoded
+	}
+
+	parts := strings.SplitN(strings.TrimPrefix(source, "/"), "/", 2)
+	if len(parts) != 2 || parts[0] != bucket.Name {
+		s3error.Abort(c, http.StatusNotFound, s3error.NoSuchBucket, "source bucket not found")
+
+		return
+	}
+
+	if !s3Require(c, policy.Download) {
+		return
+	}
+
+	src, ok := s3FindObject(c, bucket, parts[1])
+	if !ok {
+		return
+	}
+	if src.Path == path && src.Name == name {
+		s3error.Abort(c, http.StatusBadRequest, s3error.InvalidArgument, "cannot copy an object onto itself")
+
+		return
+	}
+
+	var attrs *arango.FileAttributes
+	var err error
+	if c.GetHeader("x-amz-metadata-directive") == "REPLACE" {
+		attrs, err = s3Metadata(c)
+	} else {
+		attrs, err = arango.FindAttributesById(src.Id)
+	}
+	if err != nil {
+		s3error.Respond(c, err)
+		return
+	}
+
+	var fm *arangodb.FileMetadata
+	err = arango.GetFileByFidIgnoreQueryMetadata(src.FileId, func(reader io.Reader) error {
+		var err error
+		fm, err = s3StoreObject(bucket, path, name, reader, src.Size, src.ContentType, attrs)
+		return err
+	})
+	if err != nil {
+		s3error.Respond(c, err)
+
+		//_ = nats.SendErrorEvent("copy failed at s3 CopyObject: "+err.Error(),
+		//	"File Error")
+		return
+	}
+
+	c.XML(http.StatusOK, s3CopyObjectResult{
+		Xmlns:        s3Namespace,
+		LastModified: fm.UploadedDate,
+		ETag:         s3ETag(fm),
+	})
+}
+
+// S3DeleteObject soft deletes an object. Like S3 it succeeds for keys that
+// do not exist.
+func S3DeleteObject(c *gin.Context) {
+	bucket, ok := s3ResolveBucket(c)
+	if !ok {
+		return
+	}
+
+	if !s3Require(c, policy.DeleteFile) {
+		return
+	}
+
+	key := strings.TrimPrefix(c.Param("key"), "/")
+	path, name, ok := s3ObjectPath(bucket, key)
+	if !ok {
+		c.Status(http.StatusNoContent)
+		return
+	}
+
+	p := policy.FromContext(c)
+	fm, err := arango.FindMetadataByFilename(path, name, *bucket.Id)
+	if e, ok := err.(*utils.ModelError); err != nil && (!ok || e.ErrType != utils.NotFound) {
+		s3error.Respond(c, err)
+		return
+	}
+	if err != nil || (fm.IsHidden && !p.Has(policy.GetFileListHidden)) || !p.AllowsFile(fm) {
+		c.Status(http.StatusNoContent)
+		return
+	}
+
+	if _, err := arango.DeleteFile(fm.Id); err != nil {
+		s3error.Respond(c, err)
+
+		//_ = nats.SendErrorEvent("db error at s3 DeleteObject: "+err.Error(),
+		//	"File Error")
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
@@ -0,0 +1,258 @@
+package aggregate
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/xml"
+	"github.com/Nubes3/common/models/arangodb"
+	"github.com/Nubes3/file-service/internal/api/s3error"
+	"github.com/Nubes3/file-service/internal/policy"
+	"github.com/Nubes3/file-service/internal/principal"
+	arango "github.com/Nubes3/file-service/internal/repo/arangodb"
+	"github.com/gin-gonic/gin"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	s3Namespace    = "http://s3.amazonaws.com/doc/2006-03-01/"
+	s3MetaPrefix   = "x-amz-meta-"
+	s3MaxKeys      = 1000
+	s3StorageClass = "STANDARD"
+)
+
+type s3Owner struct {
+	ID          string `xml:"ID"`
+	DisplayName string `xml:"DisplayName"`
+}
+
+type s3Bucket struct {
+	Name         string    `xml:"Name"`
+	CreationDate time.Time `xml:"CreationDate"`
+}
+
+type s3ListBucketsResult struct {
+	XMLName xml.Name   `xml:"ListAllMyBucketsResult"`
+	Xmlns   string     `xml:"xmlns,attr"`
+	Owner   s3Owner    `xml:"Owner"`
+	Buckets []s3Bucket `xml:"Buckets>Bucket"`
+}
+
+type s3Object struct {
+	Key          string    `xml:"Key"`
+	LastModified time.Time `xml:"LastModified"`
+	ETag         string    `xml:"ETag"`
+	Size         int64     `xml:"Size"`
+	StorageClass string    `xml:"StorageClass"`
+}
+
+type s3CommonPrefix struct {
+	Prefix string `xml:"Prefix"`
+}
+
+type s3ListObjectsResult struct {
+	XMLName               xml.Name         `xml:"ListBucketResult"`
+	Xmlns                 string           `xml:"xmlns,attr"`
+	Name                  string           `xml:"Name"`
+	Prefix                string           `xml:"Prefix"`
+	Delimiter             string           `xml:"Delimiter,omitempty"`
+	MaxKeys               int              `xml:"MaxKeys"`
+	KeyCount              int              `xml:"KeyCount"`
+	IsTruncated           bool             `xml:"IsTruncated"`
+	EncodingType          string           `xml:"EncodingType,omitempty"`
+	ContinuationToken     string           `xml:"ContinuationToken,omitempty"`
+	NextContinuationToken string           `xml:"NextContinuationToken,omitempty"`
+	StartAfter            string           `xml:"StartAfter,omitempty"`
+	Contents              []s3Object       `xml:"Contents"`
+	CommonPrefixes        []s3CommonPrefix `xml:"CommonPrefixes"`
+}
+
+type s3CopyObjectResult struct {
+	XMLName      xml.Name  `xml:"CopyObjectResult"`
+	Xmlns        string    `xml:"xmlns,attr"`
+	LastModified time.Time `xml:"LastModified"`
+	ETag         string    `xml:"ETag"`
+}
+
+type s3LocationConstraint struct {
+	XMLName xml.Name `xml:"LocationConstraint"`
+	Xmlns   string   `xml:"xmlns,attr"`
+	Region  string   `xml:",chardata"`
+}
+
+// s3ResolveBucket loads the bucket named in the path. A key pair reaches
+// only its own bucket, so any other name is reported as missing.
+func s3ResolveBucket(c *gin.Context) (*arangodb.Bucket, bool) {
+	p, _ := principal.From(c)
+	bucket, err := p.Bucket("")
+	if err != nil {
+		s3error.Respond(c, err)
+		return nil, false
+	}
+
+	if bucket.Name != c.Param("bucket") {
+		s3error.Abort(c, http.StatusNotFound, s3error.NoSuchBucket, "bucket not found")
+
+		return nil, false
+	}
+
+	return bucket, true
+}
+
+// s3Require aborts with AccessDenied unless the caller holds perm.
+func s3Require(c *gin.Context, perm policy.Permission) bool {
+	if !policy.FromContext(c).Has(perm) {
+		s3error.Abort(c, http.StatusForbidden, s3error.AccessDenied, "missing permission "+perm.String())
+
+		return false
+	}
+
+	return true
+}
+
+// s3ObjectPath maps an object key to the folder path and file name it is
+// stored under. Keys are kept verbatim, so empty, "." and ".." segments are
+// rejected rather than normalised away.
+func s3ObjectPath(bucket *arangodb.Bucket, key string) (string, string, bool) {
+	segments := strings.Split(key, "/")
+	for _, segment := range segments {
+		if segment == "" || segment == "." || segment == ".." {
+			return "", "", false
+		}
+	}
+
+	path := "/" + bucket.Name
+	if len(segments) > 1 {
+		path += "/" + strings.Join(segments[:len(segments)-1], "/")
+	}
+
+	return path, segments[len(segments)-1], true
+}
+
+// s3ObjectKey is the key of a stored file, its full path without the
+// bucket folder.
+func s3ObjectKey(bucket *arangodb.Bucket, fm *arangodb.FileMetadata) string {
+	return strings.TrimPrefix(policy.FullPath(fm.Path, fm.Name), "/"+bucket.Name+"/")
+}
+
+// s3ETag derives a stable entity tag from the blob id. It is not an MD5 of
+// the content, which the service does not keep.
+func s3ETag(fm *arangodb.FileMetadata) string {
+	sum := md5.Sum([]byte(fm.FileId))
+	return `"` + hex.EncodeToString(sum[:]) + `"`
+}
+
+// s3FindObject loads the object at key, treating files the caller may not
+// see as missing.
+func s3FindObject(c *gin.Context, bucket *arangodb.Bucket, key string) (*arangodb.FileMetadata, bool) {
+	path, name, ok := s3ObjectPath(bucket, key)
+	if !ok {
+		s3error.Abort(c, http.StatusNotFound, s3error.NoSuchKey, "object not found")
+
+		return nil, false
+	}
+
+	p := policy.FromContext(c)
+	fm, err := arango.FindMetadataByFilename(path, name, *bucket.Id)
+	if err != nil || (fm.IsHidden && !p.Has(policy.DownloadHidden)) || !p.AllowsFile(fm) {
+		s3error.Abort(c, http.StatusNotFound, s3error.NoSuchKey, "object not found")
+
+		return nil, false
+	}
+
+	return fm, true
+}
+
+// s3ObjectHeaders sets the headers GetObject and HeadObject share.
+func s3ObjectHeaders(c *gin.Context, fm *arangodb.FileMetadata) error {
+	attrs, err := arango.FindAttributesById(fm.Id)
+	if err != nil {
+		return err
+	}
+
+	for k, v := range attrs.Metadata {
+		c.Header(s3MetaPrefix+k, v)
+	}
+	c.Header("ETag", s3ETag(fm))
+	c.Header("Last-Modified", fm.UploadedDate.UTC().Format(http.TimeFormat))
+	c.Header("Accept-Ranges", "bytes")
+
+	return nil
+}
+
+// s3Metadata collects the x-amz-meta-* headers as file metadata.
+func s3Metadata(c *gin.Context) (*arango.FileAttributes, error) {
+	attrs := &arango.FileAttributes{
+		Metadata: map[string]string{},
+		Tags:     []string{},
+	}
+	for k, v := range c.Request.Header {
+		if strings.HasPrefix(strings.ToLower(k), s3MetaPrefix) && len(v) > 0 {
+			attrs.Metadata[strings.ToLower(k[len(s3MetaPrefix):])] = v[0]
+		}
+	}
+
+	if err := validateMetadata(attrs.Metadata); err != nil {
+		return nil, err
+	}
+
+	return attrs, nil
+}
+
+// parseByteRange parses a single HTTP range "bytes=a-b", "bytes=a-" or
+// "bytes=-n" against size. ok is false when the range cannot be satisfied;
+// an absent or multi range header returns the whole object.
+func parseByteRange(header string, size int64) (start int64, length int64, partial bool, ok bool) {
+	if !strings.HasPrefix(header, "bytes=") || strings.Contains(header, ",") {
+		return 0, size, false, true
+	}
+
+	bounds := strings.SplitN(strings.TrimPrefix(header, "bytes="), "-", 2)
+	if len(bounds) != 2 {
+		return 0, size, false, true
+	}
+
+	if bounds[0] == "" {
+		n, err := strconv.ParseInt(bounds[1], 10, 64)
+		if err != nil || n <= 0 || size == 0 {
+			return 0, 0, false, false
+		}
+		if n > size {
+			n = size
+		}
+		return size - n, n, true, true
+	}
+
+	start, err := strconv.ParseInt(bounds[0], 10, 64)
+	if err != nil || start < 0 || start >= size {
+		return 0, 0, false, false
+	}
+
+	end := size - 1
+	if bounds[1] != "" {
+		end, err = strconv.ParseInt(bounds[1], 10, 64)
+		if err != nil || end < start {
+			return 0, 0, false, false
+		}
+		if end > size-1 {
+			end = size - 1
+		}
+	}
+
+	return start, end - start + 1, true, true
+}
+
+// s3StoreObject saves reader as path/name, in an existing folder, replacing
+// an existing object as S3 does.
+func s3StoreObject(bucket *arangodb.Bucket, path string, name string, reader io.Reader, size int64,
+	contentType string, attrs *arango.FileAttributes) (*arangodb.FileMetadata, error) {
+	if err := checkFolders("/"+bucket.Name, strings.TrimPrefix(path, "/"+bucket.Name),
+		map[string]bool{"/" + bucket.Name: true}); err != nil {
+		return nil, err
+	}
+
+	return arango.ReplaceFile(reader, *bucket.Id, path, name, false, contentType, size, time.Duration(0), attrs)
+}
@@ -0,0 +1,285 @@
+package aggregate
+
+import (
+	"github.com/Nubes3/common/utils"
+	"github.com/Nubes3/file-service/internal/api/apierror"
+	"github.com/Nubes3/file-service/internal/config"
+	"github.com/Nubes3/file-service/internal/principal"
+	arango "github.com/Nubes3/file-service/internal/repo/arangodb"
+	"github.com/Nubes3/file-service/internal/repo/nats"
+	"github.com/gin-gonic/gin"
+	"golang.org/x/crypto/bcrypt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const sharePasswordHeader = "X-Share-Password"
+
+type createShareReq struct {
+	FileId       string `json:"file_id"`
+	Path         string `json:"path"`
+	Password     string `json:"password"`
+	MaxDownloads int    `json:"max_downloads"`
+	Ttl          int64  `json:"ttl"`
+}
+
+// CreateShareAuth creates a share link for a file, by file_id, or for a
+// folder, by path. Without a ttl the link never expires; without
+// max_downloads it may be downloaded any number of times.
+func CreateShareAuth(c *gin.Context) {
+	var req createShareReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		apierror.Abort(c, http.StatusBadRequest, err.Error())
+
+		return
+	}
+
+	if (req.FileId == "") == (req.Path == "") {
+		apierror.Abort(c, http.StatusBadRequest, "either file_id or path is required")
+
+		return
+	}
+	if req.MaxDownloads < 0 || req.Ttl < 0 {
+		apierror.Abort(c, http.StatusBadRequest, "max_downloads and ttl must not be negative")
+
+		return
+	}
+
+	bucket, ok := findBucket(c, c.DefaultQuery("bucketId", ""))
+	if !ok {
+		return
+	}
+	p, _ := principal.From(c)
+
+	shareType := arango.ShareFile
+	path := ""
+	if req.FileId != "" {
+		fm, err := arango.FindMetadataById(req.FileId)
+		if err != nil || fm.BucketId != *bucket.Id {
+			apierror.Abort(c, http.StatusNotFound, "file not found")
+
+			return
+		}
+	} else {
+		shareType = arango.ShareFolder
+		path = utils.StandardizedPath("/"+bucket.Name+"/"+req.Path, true)
+		if path != "/"+bucket.Name {
+			if _, err := nats.FindFolderByFullpath(path); err != nil {
+				apierror.Abort(c, http.StatusNotFound, "folder not found")
+
+				return
+			}
+		}
+	}
+
+	passwordHash := ""
+	if req.Password != "" {
+		hash, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
+		if err != nil {
+			apierror.Abort(c, http.StatusBadRequest, err.Error())
+
+			return
+		}
+		passwordHash = string(hash)
+	}
+
+	expiredDate := time.Now().Add(time.Hour * 24 * 365 * 10)
+	if req.Ttl > 0 {
+		expiredDate = time.Now().Add(time.Duration(req.Ttl) * time.Second)
+	}
+
+	share, err := arango.CreateShare(*bucket.Id, p.Id, shareType, req.FileId, path,
+		passwordHash, req.MaxDownloads, expiredDate)
+	if err != nil {
+		apierror.Respond(c, err)
+
+		//_ = nats.SendErrorEvent(err.Error()+" at auth/files/shares:",
+		//	"Db Error")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"share": share,
+		"url":   config.Conf.PublicUrl + "/public/files/shares/" + share.Id,
+	})
+}
+
+func GetSharesAuth(c *gin.Context) {
+	bucket, ok := findBucket(c, c.DefaultQuery("bucketId", ""))
+	if !ok {
+		return
+	}
+
+	shares, err := arango.FindSharesByBid(*bucket.Id)
+	if err != nil {
+		apierror.Respond(c, err)
+
+		//_ = nats.SendErrorEvent(err.Error()+" at auth/files/shares:",
+		//	"Db Error")
+		return
+	}
+
+	c.JSON(http.StatusOK, shares)
+}
+
+func RevokeShareAuth(c *gin.Context) {
+	bucket, ok := findBucket(c, c.DefaultQuery("bucketId", ""))
+	if !ok {
+		return
+	}
+
+	share, err := arango.FindShareById(c.Param("id"))
+	if err != nil || share.BucketId != *bucket.Id {
+		apierror.Abort(c, http.StatusNotFound, "share not found")
+
+		return
+	}
+
+	share, err = arango.RevokeShare(share.Id)
+	if err != nil {
+		apierror.Respond(c, err)
+
+		//_ = nats.SendErrorEvent(err.Error()+" at auth/files/shares:",
+		//	"Db Error")
+		return
+	}
+
+	c.JSON(http.StatusOK, share)
+}
+
+// GetShare describes a share link to its visitors, listing the visible
+// files of a shared folder.
+func GetShare(c *gin.Context) {
+	share, ok := openShare(c)
+	if !ok {
+		return
+	}
+
+	res := gin.H{
+		"id":            share.Id,
+		"type":          share.Type,
+		"max_downloads": share.MaxDownloads,
+		"downloads":     share.Downloads,
+		"expired_date":  share.ExpiredDate,
+	}
+
+	if share.Type == arango.ShareFile {
+		fm, err := arango.FindMetadataById(share.FileId)
+		if err != nil {
+			apierror.Respond(c, err)
+			return
+		}
+		res["file"] = fm
+	} else {
+		files, err := arango.FindMetadataByPathPrefix(share.BucketId, share.Path, false)
+		if err != nil {
+			apierror.Respond(c, err)
+			return
+		}
+		res["path"] = share.Path
+		res["files"] = files
+	}
+
+	c.JSON(http.StatusOK, res)
+}
+
+// DownloadShare streams the shared file, or the file of a shared folder
+// named by the fileId query, counting the download against the share.
+func DownloadShare(c *gin.Context) {
+	share, ok := openShare(c)
+	if !ok {
+		return
+	}
+
+	fileId := share.FileId
+	if share.Type == arango.ShareFolder {
+		fileId = c.DefaultQuery("fileId", "")
+	}
+
+	fileMeta, err := arango.FindMetadataById(fileId)
+	if err != nil {
+		apierror.Respond(c, err)
+		return
+	}
+
+	if share.Type == arango.ShareFolder && (fileMeta.BucketId != share.BucketId || fileMeta.IsHidden ||
+		(fileMeta.Path != share.Path && !strings.HasPrefix(fileMeta.Path, share.Path+"/"))) {
+		apierror.Abort(c, http.StatusNotFound, "file not found")
+
+		return
+	}
+
+	if _, err := arango.ConsumeShareDownload(share.Id); err != nil {
+		apierror.Respond(c, err)
+		return
+	}
+
+	sent := false
+	err = arango.DownloadFile(fileMeta, func(reader io.Reader) error {
+		extraHeaders := map[string]string{
+			"Content-Disposition": `attachment; filename=` + fileMeta.Name,
+		}
+
+		attrs, err := arango.FindAttributesById(fileMeta.Id)
+		if err != nil {
+			return err
+		}
+		withAttributeHeaders(extraHeaders, attrs)
+
+		sent = true
+		c.DataFromReader(http.StatusOK, fileMeta.Size, fileMeta.ContentType, reader, extraHeaders)
+
+		_ = nats.SendDownloadFileEvent(fileMeta)
+
+		return nil
+	})
+
+	if err != nil {
+		if !sent {
+			_ = arango.RefundShareDownload(share.Id)
+			apierror.Respond(c, err)
+		}
+
+		//_ = nats.SendErrorEvent("download failed: "+err.Error()+" at /public/files/shares:",
+		//	"File Error")
+		return
+	}
+}
+
+// openShare loads the share of the request and checks it can still be used
+// and, when it has one, its password.
+func openShare(c *gin.Context) (*arango.Share, bool) {
+	share, err := arango.FindShareById(c.Param("id"))
+	if err != nil {
+		apierror.Respond(c, err)
+		return nil, false
+	}
+
+	if share.Revoked {
+		apierror.Abort(c, http.StatusNotFound, "share not found")
+
+		return nil, false
+	}
+	if !share.ExpiredDate.After(time.Now()) {
+		apierror.Respond(c, &utils.ModelError{
+			Msg:     "share link expired",
+			ErrType: utils.Expired,
+		})
+		return nil, false
+	}
+
+	if share.HasPassword {
+		// Only the header is read: a query parameter would leave the
+		// password in access logs and browser history.
+		password := c.GetHeader(sharePasswordHeader)
+		if bcrypt.CompareHashAndPassword([]byte(share.PasswordHash), []byte(password)) != nil {
+			apierror.Abort(c, http.StatusUnauthorized, "invalid share password")
+
+			return nil, false
+		}
+	}
+
+	return share, true
+}
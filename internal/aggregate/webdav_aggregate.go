@@ -0,0 +1,31 @@
+package aggregate
+
+import (
+	"github.com/Nubes3/file-service/internal/davfs"
+	"github.com/gin-gonic/gin"
+	"golang.org/x/net/webdav"
+	"strings"
+	"sync"
+)
+
+// davLocks keeps one lock system per bucket, as WebDAV names are relative
+// to the bucket and would otherwise collide.
+var davLocks sync.Map
+
+// WebDav serves the bucket named by the bucketId path parameter over
+// WebDAV, so it can be mounted by file managers.
+func WebDav(c *gin.Context) {
+	bucket, ok := findBucket(c, c.Param("bucketId"))
+	if !ok {
+		return
+	}
+
+	locks, _ := davLocks.LoadOrStore(*bucket.Id, webdav.NewMemLS())
+	handler := &webdav.Handler{
+		Prefix:     strings.TrimSuffix(c.Request.URL.Path, c.Param("path")),
+		FileSystem: davfs.New(bucket),
+		LockSystem: locks.(webdav.LockSystem),
+	}
+
+	handler.ServeHTTP(c.Writer, c.Request)
+}
@@ -0,0 +1,145 @@
+package aggregate
+
+import (
+	"github.com/Nubes3/file-service/internal/api/apierror"
+	arango "github.com/Nubes3/file-service/internal/repo/arangodb"
+	"github.com/Nubes3/file-service/internal/webhook"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"strconv"
+)
+
+type createWebhookReq struct {
+	Url    string   `json:"url" binding:"required"`
+	Events []string `json:"events" binding:"required"`
+	Secret string   `json:"secret"`
+}
+
+func CreateWebhookAuth(c *gin.Context) {
+	var req createWebhookReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		apierror.Abort(c, http.StatusBadRequest, err.Error())
+
+		return
+	}
+
+	if err := webhook.CheckUrl(req.Url); err != nil {
+		apierror.Respond(c, err)
+
+		return
+	}
+
+	for _, event := range req.Events {
+		if event != "upload" && event != "delete" && event != "hidden" {
+			apierror.Abort(c, http.StatusBadRequest, "invalid event: "+event)
+
+			return
+		}
+	}
+
+	bucket, ok := findBucket(c, c.DefaultQuery("bucketId", ""))
+	if !ok {
+		return
+	}
+
+	secret := req.Secret
+	if secret == "" {
+		secret = webhook.NewSecret()
+	}
+
+	res, err := arango.CreateWebhook(*bucket.Id, req.Url, normalizeTags(req.Events), secret)
+	if err != nil {
+		apierror.Respond(c, err)
+
+		//_ = nats.SendErrorEvent(err.Error()+" at auth/files/webhooks:",
+		//	"Db Error")
+		return
+	}
+
+	c.JSON(http.StatusOK, res)
+}
+
+func GetWebhooksAuth(c *gin.Context) {
+	bucket, ok := findBucket(c, c.DefaultQuery("bucketId", ""))
+	if !ok {
+		return
+	}
+
+	res, err := arango.FindWebhooksByBid(*bucket.Id)
+	if err != nil {
+		apierror.Respond(c, err)
+
+		//_ = nats.SendErrorEvent(err.Error()+" at auth/files/webhooks:",
+		//	"Db Error")
+		return
+	}
+
+	c.JSON(http.StatusOK, res)
+}
+
+func DeleteWebhookAuth(c *gin.Context) {
+	bucket, ok := findBucket(c, c.DefaultQuery("bucketId", ""))
+	if !ok {
+		return
+	}
+
+	w, err := arango.FindWebhookById(c.Param("id"))
+	if err != nil || w.BucketId != *bucket.Id {
+		apierror.Abort(c, http.StatusNotFound, "webhook not found")
+
+		return
+	}
+
+	err = arango.DeleteWebhook(w.Id)
+	if err != nil {
+		apierror.Respond(c, err)
+
+		//_ = nats.SendErrorEvent(err.Error()+" at auth/files/webhooks:",
+		//	"Db Error")
+		return
+	}
+
+	c.JSON(http.StatusOK, w)
+}
+
+// GetWebhookDeliveriesAuth lists recent deliveries of a webhook; pass
+// status=dead to read its dead letter list.
+func GetWebhookDeliveriesAuth(c *gin.Context) {
+	limit, err := strconv.ParseInt(c.DefaultQuery("limit", "50"), 10, 64)
+	if err != nil {
+		apierror.Abort(c, http.StatusBadRequest, "invalid limit format")
+
+		return
+	}
+
+	status := c.DefaultQuery("status", "")
+	if status != "" && status != arango.DeliveryPending &&
+		status != arango.DeliveryDelivered && status != arango.DeliveryDead {
+		apierror.Abort(c, http.StatusBadRequest, "invalid status")
+
+		return
+	}
+
+	bucket, ok := findBucket(c, c.DefaultQuery("bucketId", ""))
+	if !ok {
+		return
+	}
+
+	w, err := arango.FindWebhookById(c.Param("id"))
+	if err != nil || w.BucketId != *bucket.Id {
+		apierror.Abort(c, http.StatusNotFound, "webhook not found")
+
+		return
+	}
+
+	res, err := arango.FindWebhookDeliveries(w.Id, status, limit)
+	if err != nil {
+		apierror.Respond(c, err)
+
+		//_ = nats.SendErrorEvent(err.Error()+" at auth/files/webhooks/deliveries:",
+		//	"Db Error")
+		return
+	}
+
+	c.JSON(http.StatusOK, res)
+}
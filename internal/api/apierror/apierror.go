@@ -0,0 +1,122 @@
+// Package apierror turns errors into the JSON error body every endpoint
+// responds with, so clients get the same status and code for the same
+// failure regardless of the route.
+package apierror
+
+import (
+	"github.com/Nubes3/common/utils"
+	"github.com/gin-gonic/gin"
+	"log"
+	"net/http"
+)
+
+// RequestIdKey is the context key the request id middleware stores the id
+// under.
+const RequestIdKey = "requestId"
+
+// Stable error codes returned in the code field.
+const (
+	CodeInvalid       = "invalid_request"
+	CodeUnauthorized  = "unauthorized"
+	CodeForbidden     = "forbidden"
+	CodeNotFound      = "not_found"
+	CodeDuplicated    = "duplicated"
+	CodeConflict      = "conflict"
+	CodeExpired       = "expired"
+	CodeTooLarge      = "too_large"
+	CodeUnprocessable = "unprocessable"
+	CodeDatabase      = "database_error"
+	CodeStorage       = "storage_error"
+	CodeTimeout       = "timeout"
+	CodeInternal      = "internal_error"
+)
+
+type Body struct {
+	Code      string `json:"code"`
+	Message   string `json:"message"`
+	RequestId string `json:"request_id"`
+}
+
+// Status maps err to its HTTP status, code and the message safe to show a
+// client. Messages of server side failures are never passed through.
+func Status(err error) (int, string, string) {
+	e, ok := err.(*utils.ModelError)
+	if !ok {
+		return http.StatusInternalServerError, CodeInternal, "internal error"
+	}
+
+	switch e.ErrType {
+	case utils.NotFound:
+		return http.StatusNotFound, CodeNotFound, e.Msg
+	case utils.Duplicated:
+		return http.StatusConflict, CodeDuplicated, e.Msg
+	case utils.Invalid:
+		return http.StatusBadRequest, CodeInvalid, e.Msg
+	case utils.Expired:
+		return http.StatusGone, CodeExpired, e.Msg
+	case utils.DbError:
+		return http.StatusInternalServerError, CodeDatabase, "database error"
+	case utils.FsError:
+		return http.StatusBadGateway, CodeStorage, "storage error"
+	case utils.Timeout:
+		return http.StatusGatewayTimeout, CodeTimeout, "upstream service timed out"
+	}
+
+	return http.StatusInternalServerError, CodeInternal, "internal error"
+}
+
+// Respond aborts the request with the error body for err. Server side
+// failures are logged with the request id since their message is hidden.
+func Respond(c *gin.Context, err error) {
+	status, code, msg := Status(err)
+	if status >= http.StatusInternalServerError {
+		log.Println("request " + c.GetString(RequestIdKey) + " " + c.FullPath() + " failed: " + err.Error())
+	}
+
+	c.AbortWithStatusJSON(status, Body{
+		Code:      code,
+		Message:   msg,
+		RequestId: c.GetString(RequestIdKey),
+	})
+}
+
+// Abort aborts the request with status and a message written by the caller,
+// for failures that do not come from a ModelError.
+func Abort(c *gin.Context, status int, msg string) {
+	c.AbortWithStatusJSON(status, Body{
+		Code:      CodeOf(status),
+		Message:   msg,
+		RequestId: c.GetString(RequestIdKey),
+	})
+}
+
+// CodeOf is the code used for a status when there is no ModelError.
+func CodeOf(status int) string {
+	switch status {
+	case http.StatusBadRequest:
+		return CodeInvalid
+	case http.StatusUnauthorized:
+		return CodeUnauthorized
+	case http.StatusForbidden:
+		return CodeForbidden
+	case http.StatusNotFound:
+		return CodeNotFound
+	case http.StatusConflict:
+		return CodeConflict
+	case http.StatusGone:
+		return CodeExpired
+	case http.StatusRequestEntityTooLarge:
+		return CodeTooLarge
+	case http.StatusUnprocessableEntity:
+		return CodeUnprocessable
+	case http.StatusBadGateway:
+		return CodeStorage
+	case http.StatusGatewayTimeout:
+		return CodeTimeout
+	}
+
+	if status >= http.StatusInternalServerError {
+		return CodeInternal
+	}
+	return CodeInvalid
+}
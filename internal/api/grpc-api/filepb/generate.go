@@ -0,0 +1,5 @@
+// Package filepb holds the protobuf messages and gRPC stubs of the file
+// service, generated from file.proto.
+package filepb
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative file.proto
@@ -0,0 +1,33 @@
+package grpc_api
+
+import (
+	"github.com/Nubes3/file-service/internal/aggregate"
+	"github.com/Nubes3/file-service/internal/api/grpc-api/filepb"
+	"github.com/Nubes3/file-service/internal/api/middlewares"
+	"github.com/Nubes3/file-service/internal/policy"
+	"google.golang.org/grpc"
+)
+
+// permissions lists what each method requires, as the REST routes do with
+// policy.RequirePermission.
+var permissions = map[string][]policy.Permission{
+	"/nubes3.file.FileService/Upload":    {policy.Upload},
+	"/nubes3.file.FileService/Download":  {policy.Download},
+	"/nubes3.file.FileService/Stat":      {policy.GetFileList},
+	"/nubes3.file.FileService/List":      {policy.GetFileList},
+	"/nubes3.file.FileService/Delete":    {policy.DeleteFile},
+	"/nubes3.file.FileService/SetHidden": {policy.MarkHidden},
+}
+
+// FileServer returns a gRPC server serving the file service behind the
+// authentication interceptors.
+func FileServer(opts ...grpc.ServerOption) *grpc.Server {
+	opts = append(opts,
+		grpc.UnaryInterceptor(middlewares.GrpcUnary(permissions)),
+		grpc.StreamInterceptor(middlewares.GrpcStream(permissions)))
+
+	s := grpc.NewServer(opts...)
+	filepb.RegisterFileServiceServer(s, &aggregate.FileServer{})
+
+	return s
+}
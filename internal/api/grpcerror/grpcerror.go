@@ -0,0 +1,56 @@
+// Package grpcerror turns errors into gRPC statuses, mapping them the same
+// way apierror does for the JSON API.
+package grpcerror
+
+import (
+	"github.com/Nubes3/file-service/internal/api/apierror"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"log"
+	"net/http"
+)
+
+// Status returns the gRPC status error for err. Errors that already carry a
+// status are returned as they are; server side failures are logged with the
+// method since their message is hidden.
+func Status(method string, err error) error {
+	if err == nil {
+		return nil
+	}
+	if _, ok := status.FromError(err); ok {
+		return err
+	}
+
+	httpCode, _, msg := apierror.Status(err)
+	if httpCode >= http.StatusInternalServerError {
+		log.Println("call " + method + " failed: " + err.Error())
+	}
+
+	return status.Error(CodeOf(httpCode), msg)
+}
+
+// CodeOf is the gRPC code matching an HTTP status.
+func CodeOf(httpCode int) codes.Code {
+	switch httpCode {
+	case http.StatusBadRequest:
+		return codes.InvalidArgument
+	case http.StatusUnauthorized:
+		return codes.Unauthenticated
+	case http.StatusForbidden:
+		return codes.PermissionDenied
+	case http.StatusNotFound:
+		return codes.NotFound
+	case http.StatusConflict:
+		return codes.AlreadyExists
+	case http.StatusGone:
+		return codes.FailedPrecondition
+	case http.StatusRequestEntityTooLarge:
+		return codes.ResourceExhausted
+	case http.StatusBadGateway, http.StatusServiceUnavailable:
+		return codes.Unavailable
+	case http.StatusGatewayTimeout:
+		return codes.DeadlineExceeded
+	}
+
+	return codes.Internal
+}
@@ -0,0 +1,195 @@
+package middlewares
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"github.com/Nubes3/common/models/arangodb"
+	"github.com/Nubes3/common/utils"
+	"github.com/Nubes3/file-service/internal/api/grpcerror"
+	"github.com/Nubes3/file-service/internal/policy"
+	"github.com/Nubes3/file-service/internal/principal"
+	arango "github.com/Nubes3/file-service/internal/repo/arangodb"
+	"github.com/Nubes3/file-service/internal/repo/nats"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Metadata keys of the gRPC credentials, one set per REST route group.
+const (
+	grpcAuthorization      = "authorization"
+	grpcAccessKey          = "x-access-key"
+	grpcKeyPair            = "x-key-pair"
+	grpcKeyPairTimestamp   = "x-key-pair-timestamp"
+	grpcKeyPairSignature   = "x-key-pair-signature"
+	grpcKeyPairNonce       = "x-key-pair-nonce"
+	grpcKeyPairMaxTimeSkew = 15 * time.Minute
+)
+
+var errUnauthenticated = status.Error(codes.Unauthenticated, "unauthorized")
+
+// GrpcUnary authenticates unary calls like the REST middlewares do and
+// checks the permissions perms lists for the method.
+func GrpcUnary(perms map[string][]policy.Permission) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo,
+		handler grpc.UnaryHandler) (interface{}, error) {
+		ctx, err := grpcAuthorize(ctx, info.FullMethod, perms[info.FullMethod])
+		if err != nil {
+			return nil, err
+		}
+
+		res, err := handler(ctx, req)
+		return res, grpcerror.Status(info.FullMethod, err)
+	}
+}
+
+// GrpcStream is GrpcUnary for streaming calls.
+func GrpcStream(perms map[string][]policy.Permission) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo,
+		handler grpc.StreamHandler) error {
+		ctx, err := grpcAuthorize(ss.Context(), info.FullMethod, perms[info.FullMethod])
+		if err != nil {
+			return err
+		}
+
+		err = handler(srv, &authorizedStream{ServerStream: ss, ctx: ctx})
+		return grpcerror.Status(info.FullMethod, err)
+	}
+}
+
+type authorizedStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *authorizedStream) Context() context.Context {
+	return s.ctx
+}
+
+func grpcAuthorize(ctx context.Context, method string, perms []policy.Permission) (context.Context, error) {
+	p, err := grpcAuthenticate(ctx, method)
+	if err != nil {
+		return nil, grpcerror.Status(method, err)
+	}
+
+	for _, perm := range perms {
+		if !p.Policy.Has(perm) {
+			return nil, status.Error(codes.PermissionDenied, "missing permission "+perm.String())
+		}
+	}
+
+	return principal.NewContext(ctx, p), nil
+}
+
+// grpcAuthenticate picks the credentials of the call: a user token, an
+// access key or a signed key pair, as the /auth, /accessKey and /signed
+// route groups do.
+func grpcAuthenticate(ctx context.Context, method string) (*principal.Principal, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+
+	switch {
+	case len(md.Get(grpcAuthorization)) > 0:
+		return grpcUserAuthenticate(md.Get(grpcAuthorization)[0])
+	case len(md.Get(grpcAccessKey)) > 0:
+		return grpcApiKeyAuthenticate(md.Get(grpcAccessKey)[0])
+	case len(md.Get(grpcKeyPair)) > 0:
+		return grpcCheckSigned(md, method)
+	}
+
+	return nil, errUnauthenticated
+}
+
+func grpcUserAuthenticate(authorization string) (*principal.Principal, error) {
+	token := strings.TrimPrefix(authorization, "Bearer ")
+
+	var claims utils.UserClaims
+	if _, err := utils.ParseToken(token, &claims); err != nil || claims.Id == "" {
+		return nil, errUnauthenticated
+	}
+
+	return principal.ForUser(claims.Id), nil
+}
+
+func grpcApiKeyAuthenticate(raw string) (*principal.Principal, error) {
+	key, err := nats.FindAccessKeyByKey(raw)
+	if err != nil {
+		return nil, grpcKeyError(err)
+	}
+	if expired(key.ExpiredDate) {
+		return nil, status.Error(codes.Unauthenticated, "access key expired")
+	}
+
+	return principal.ForAccessKey(key)
+}
+
+// grpcCheckSigned checks the key pair signature: the hex HMAC-SHA256, keyed
+// by the private key, of the method, the unix timestamp and a nonce of 16 to
+// 128 characters, separated by newlines. The timestamp must be within
+// grpcKeyPairMaxTimeSkew of now and the nonce is accepted once, so a signature
+// seen on the wire cannot be reused for another call.
+func grpcCheckSigned(md metadata.MD, method string) (*principal.Principal, error) {
+	timestamps, nonces, signatures := md.Get(grpcKeyPairTimestamp), md.Get(grpcKeyPairNonce), md.Get(grpcKeyPairSignature)
+	if len(timestamps) == 0 || len(nonces) == 0 || len(signatures) == 0 {
+		return nil, errUnauthenticated
+	}
+	if len(nonces[0]) < 16 || len(nonces[0]) > 128 {
+		return nil, status.Error(codes.Unauthenticated, "invalid nonce")
+	}
+
+	sec, err := strconv.ParseInt(timestamps[0], 10, 64)
+	if err != nil {
+		return nil, status.Error(codes.Unauthenticated, "invalid timestamp")
+	}
+	if skew := time.Since(time.Unix(sec, 0)); skew > grpcKeyPairMaxTimeSkew || skew < -grpcKeyPairMaxTimeSkew {
+		return nil, status.Error(codes.Unauthenticated, "timestamp too skewed")
+	}
+
+	key, err := nats.FindKeyPairByPublic(md.Get(grpcKeyPair)[0])
+	if err != nil {
+		return nil, grpcKeyError(err)
+	}
+	if expired(key.ExpiredDate) {
+		return nil, status.Error(codes.Unauthenticated, "key pair expired")
+	}
+
+	if !hmac.Equal([]byte(signKeyPairCall(key, method, timestamps[0], nonces[0])), []byte(signatures[0])) {
+		return nil, status.Error(codes.Unauthenticated, "signature does not match")
+	}
+
+	// The timestamp check rejects the call once the nonce record may be gone.
+	err = arango.ConsumeNonce(key.Public, nonces[0], time.Unix(sec, 0).Add(grpcKeyPairMaxTimeSkew))
+	if e, ok := err.(*utils.ModelError); ok && e.ErrType == utils.Duplicated {
+		return nil, status.Error(codes.Unauthenticated, "nonce already used")
+	} else if err != nil {
+		return nil, err
+	}
+
+	return principal.ForKeyPair(key)
+}
+
+func signKeyPairCall(key *arangodb.KeyPair, method string, timestamp string, nonce string) string {
+	mac := hmac.New(sha256.New, []byte(key.Private))
+	mac.Write([]byte(method + "\n" + timestamp + "\n" + nonce))
+
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// grpcKeyError hides why a key lookup failed unless the key service could
+// not be reached.
+func grpcKeyError(err error) error {
+	if e, ok := err.(*utils.ModelError); ok && e.ErrType == utils.Timeout {
+		return err
+	}
+
+	return errUnauthenticated
+}
+
+func expired(date time.Time) bool {
+	return !date.IsZero() && date.Before(time.Now())
+}
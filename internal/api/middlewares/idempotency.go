@@ -0,0 +1,44 @@
+package middlewares
+
+import (
+	"github.com/Nubes3/file-service/internal/idempotency"
+	"github.com/Nubes3/file-service/internal/principal"
+	arango "github.com/Nubes3/file-service/internal/repo/arangodb"
+	"github.com/gin-gonic/gin"
+	"time"
+)
+
+const IdempotencyKeyHeader = idempotency.KeyHeader
+
+// Idempotent replays the stored first response when a request repeats an
+// Idempotency-Key of the same caller and route, see idempotency.Middleware.
+var Idempotent = idempotency.Middleware(idempotencyKeys{}, idempotencyScope)
+
+// idempotencyKeys stores the keys in Arango.
+type idempotencyKeys struct{}
+
+func (idempotencyKeys) Reserve(key string, window time.Duration, lease time.Duration) (*idempotency.Response, error) {
+	res, err := arango.ReserveIdempotencyKey(key, window, lease)
+	if res == nil {
+		return nil, err
+	}
+
+	return (*idempotency.Response)(res), err
+}
+
+func (idempotencyKeys) Complete(key string, res *idempotency.Response) error {
+	return arango.CompleteIdempotencyKey(key, (*arango.IdempotentResponse)(res))
+}
+
+func (idempotencyKeys) Release(key string) error {
+	return arango.ReleaseIdempotencyKey(key)
+}
+
+// idempotencyScope identifies the caller, so two callers never share a key.
+func idempotencyScope(c *gin.Context) string {
+	if p, ok := principal.From(c); ok {
+		return p.String()
+	}
+
+	return "anonymous"
+}
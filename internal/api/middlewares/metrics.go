@@ -0,0 +1,54 @@
+package middlewares
+
+import (
+	"crypto/subtle"
+	"github.com/Nubes3/file-service/internal/api/apierror"
+	"github.com/Nubes3/file-service/internal/config"
+	"github.com/Nubes3/file-service/internal/metrics"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Metrics counts and times the requests of an engine by route group and
+// status. With an empty group the group is the first two segments of the
+// matched route, e.g. /auth/files, so path parameters never become labels.
+func Metrics(group string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+
+		g := group
+		if g == "" {
+			g = routeGroup(c.FullPath())
+		}
+		metrics.ObserveRequest(g, c.Request.Method, strconv.Itoa(c.Writer.Status()), start)
+	}
+}
+
+func routeGroup(route string) string {
+	if route == "" {
+		return "unmatched"
+	}
+
+	segments := strings.SplitN(strings.TrimPrefix(route, "/"), "/", 3)
+	if len(segments) > 2 {
+		segments = segments[:2]
+	}
+	return "/" + strings.Join(segments, "/")
+}
+
+// MetricsAuthenticate guards /metrics with the configured bearer token. Like
+// the admin routes, the endpoint stays closed while no token is configured,
+// as the metrics name every bucket that transferred data.
+func MetricsAuthenticate(c *gin.Context) {
+	token := strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer ")
+	if config.Conf.MetricsToken == "" ||
+		subtle.ConstantTimeCompare([]byte(token), []byte(config.Conf.MetricsToken)) != 1 {
+		apierror.Abort(c, http.StatusUnauthorized, "unauthorized")
+
+		return
+	}
+}
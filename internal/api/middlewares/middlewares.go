@@ -1,15 +1,82 @@
 package middlewares
 
-import "github.com/gin-gonic/gin"
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"github.com/Nubes3/common/models/arangodb"
+	"github.com/Nubes3/file-service/internal/api/apierror"
+	"github.com/Nubes3/file-service/internal/config"
+	"github.com/Nubes3/file-service/internal/principal"
+	"github.com/gin-gonic/gin"
+	"net/http"
+)
 
 func UserAuthenticate(c *gin.Context) {
+	uid, ok := c.Get("uid")
+	if !ok {
+		apierror.Abort(c, http.StatusUnauthorized, "unauthorized")
+		return
+	}
 
+	principal.Set(c, principal.ForUser(uid.(string)))
 }
 
 func CheckSigned(c *gin.Context) {
+	key, ok := c.Get("keyPair")
+	if !ok {
+		apierror.Abort(c, http.StatusUnauthorized, "unauthorized")
+		return
+	}
 
+	p, err := principal.ForKeyPair(key.(*arangodb.KeyPair))
+	if err != nil {
+		apierror.Respond(c, err)
+		return
+	}
+	principal.Set(c, p)
 }
 
 func ApiKeyAuthenticate(c *gin.Context) {
+	key, ok := c.Get("accessKey")
+	if !ok {
+		apierror.Abort(c, http.StatusUnauthorized, "unauthorized")
+		return
+	}
 
+	p, err := principal.ForAccessKey(key.(*arangodb.AccessKey))
+	if err != nil {
+		apierror.Respond(c, err)
+		return
+	}
+	principal.Set(c, p)
+}
+
+// AdminAuthenticate lets a request through only when its X-Admin-Token header
+// matches the configured admin token. Admin routes stay closed while no token
+// is configured.
+func AdminAuthenticate(c *gin.Context) {
+	token := c.GetHeader("X-Admin-Token")
+	if config.Conf.AdminToken == "" ||
+		subtle.ConstantTimeCompare([]byte(token), []byte(config.Conf.AdminToken)) != 1 {
+		apierror.Abort(c, http.StatusUnauthorized, "unauthorized")
+
+		return
+	}
+}
+
+const requestIdHeader = "X-Request-Id"
+
+// RequestId tags the request with the caller supplied X-Request-Id, or a new
+// one, and echoes it back so error bodies and logs can be matched up.
+func RequestId(c *gin.Context) {
+	id := c.GetHeader(requestIdHeader)
+	if id == "" || len(id) > 128 {
+		b := make([]byte, 16)
+		_, _ = rand.Read(b)
+		id = hex.EncodeToString(b)
+	}
+
+	c.Set(apierror.RequestIdKey, id)
+	c.Header(requestIdHeader, id)
 }
@@ -0,0 +1,76 @@
+package middlewares
+
+import (
+	"github.com/Nubes3/common/utils"
+	"github.com/Nubes3/file-service/internal/api/s3error"
+	"github.com/Nubes3/file-service/internal/principal"
+	"github.com/Nubes3/file-service/internal/repo/nats"
+	"github.com/Nubes3/file-service/internal/sigv4"
+	"github.com/gin-gonic/gin"
+	"io"
+	"net/http"
+	"time"
+)
+
+const s3PayloadKey = "s3Payload"
+
+type s3Payload struct {
+	signature *sigv4.Signature
+	secret    string
+}
+
+// S3Authenticate checks the SigV4 signature of an S3 request. The access
+// key id is the public key of a key pair and the secret its private key, so
+// the request runs as that key pair.
+func S3Authenticate(c *gin.Context) {
+	sig, err := sigv4.Parse(c.Request)
+	if err != nil {
+		s3error.Abort(c, http.StatusForbidden, s3error.AccessDenied, err.Error())
+
+		return
+	}
+
+	key, err := nats.FindKeyPairByPublic(sig.AccessKey)
+	if err != nil {
+		if e, ok := err.(*utils.ModelError); ok && e.ErrType == utils.Timeout {
+			s3error.Respond(c, err)
+			return
+		}
+		s3error.Abort(c, http.StatusForbidden, s3error.InvalidAccessKeyId, "invalid access key id")
+
+		return
+	}
+
+	if !key.ExpiredDate.IsZero() && key.ExpiredDate.Before(time.Now()) {
+		s3error.Abort(c, http.StatusForbidden, s3error.InvalidAccessKeyId, "access key expired")
+
+		return
+	}
+
+	if err := sig.Verify(c.Request, key.Private); err != nil {
+		if e, ok := err.(*utils.ModelError); ok && e.ErrType == utils.Expired {
+			s3error.Abort(c, http.StatusForbidden, s3error.RequestTimeTooSkewed, err.Error())
+
+			return
+		}
+		s3error.Abort(c, http.StatusForbidden, s3error.SignatureDoesNotMatch, err.Error())
+
+		return
+	}
+
+	p, err := principal.ForKeyPair(key)
+	if err != nil {
+		s3error.Respond(c, err)
+		return
+	}
+	principal.Set(c, p)
+	c.Set(s3PayloadKey, &s3Payload{signature: sig, secret: key.Private})
+}
+
+// S3Body returns the decoded payload of a request S3Authenticate let
+// through and its length, -1 when the client did not declare one.
+func S3Body(c *gin.Context) (io.Reader, int64) {
+	payload := c.MustGet(s3PayloadKey).(*s3Payload)
+
+	return payload.signature.Body(c.Request, payload.secret), payload.signature.ContentLength(c.Request)
+}
@@ -0,0 +1,64 @@
+package nats_api
+
+import (
+	"github.com/Nubes3/common/models/nats"
+	"github.com/Nubes3/file-service/internal/aggregate"
+)
+
+const (
+	// FileSubj is the subject other services query file metadata on, next
+	// to the common bucket and folder subjects.
+	FileSubj = "nubes3_file"
+	// FileListSubj lists the files of a bucket. The common request types
+	// have no listing, so it gets a subject of its own.
+	FileListSubj = "nubes3_file_list"
+
+	// DeletedBucketSubj and DeletedFolderSubj carry the bucket and the
+	// folder, with a bucket_id field, the bucket and folder services deleted.
+	DeletedBucketSubj = "nubes3_deleted_bucket"
+	DeletedFolderSubj = "nubes3_deleted_folder"
+
+	// queueGroup spreads the requests over the file service instances.
+	queueGroup = "file-service"
+)
+
+// FileSubscriptions subscribes the file request handlers. Call the returned
+// function to unsubscribe.
+func FileSubscriptions() (func(), error) {
+	sub, err := nats.Nc.QueueSubscribe(FileSubj, queueGroup, aggregate.HandleFileRequest)
+	if err != nil {
+		return nil, err
+	}
+
+	listSub, err := nats.Nc.QueueSubscribe(FileListSubj, queueGroup, aggregate.HandleFileListRequest)
+	if err != nil {
+		_ = sub.Unsubscribe()
+		return nil, err
+	}
+
+	return func() {
+		_ = sub.Unsubscribe()
+		_ = listSub.Unsubscribe()
+	}, nil
+}
+
+// DeletionSubscriptions subscribes to the bucket and folder deletion events,
+// which start the cascades deleting their files. Call the returned function
+// to unsubscribe.
+func DeletionSubscriptions() (func(), error) {
+	bucketSub, err := nats.Nc.QueueSubscribe(DeletedBucketSubj, queueGroup, aggregate.HandleBucketDeleted)
+	if err != nil {
+		return nil, err
+	}
+
+	folderSub, err := nats.Nc.QueueSubscribe(DeletedFolderSubj, queueGroup, aggregate.HandleFolderDeleted)
+	if err != nil {
+		_ = bucketSub.Unsubscribe()
+		return nil, err
+	}
+
+	return func() {
+		_ = bucketSub.Unsubscribe()
+		_ = folderSub.Unsubscribe()
+	}, nil
+}
@@ -3,51 +3,111 @@ package rest_api
 import (
 	"github.com/Nubes3/file-service/internal/aggregate"
 	"github.com/Nubes3/file-service/internal/api/middlewares"
+	"github.com/Nubes3/file-service/internal/policy"
 	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"net/http"
 )
 
+var davMethods = []string{
+	http.MethodOptions, http.MethodGet, http.MethodHead, http.MethodPut, http.MethodDelete,
+	"PROPFIND", "PROPPATCH", "MKCOL", "COPY", "MOVE", "LOCK", "UNLOCK",
+}
+
 func FileRoutes(r *gin.Engine) {
+	r.Use(middlewares.RequestId, middlewares.Metrics(""))
+
+	r.GET("/metrics", middlewares.MetricsAuthenticate, gin.WrapH(promhttp.Handler()))
 
 	acr := r.Group("/accessKey/files", middlewares.ApiKeyAuthenticate)
+	fileRoutes(acr)
+
+	ar := r.Group("/auth/files", middlewares.UserAuthenticate)
+	fileRoutes(ar)
 	{
-		acr.GET("/all", aggregate.GetAllFileWithAccessKey)
+		ar.POST("/webhooks", aggregate.CreateWebhookAuth)
+
+		ar.GET("/webhooks", aggregate.GetWebhooksAuth)
+
+		ar.DELETE("/webhooks/:id", aggregate.DeleteWebhookAuth)
+
+		ar.GET("/webhooks/:id/deliveries", aggregate.GetWebhookDeliveriesAuth)
 
-		acr.GET("/hidden/all", aggregate.GetAllFileIncludeHiddenAccessKey)
+		ar.GET("/scopes", aggregate.GetKeyScopesAuth)
 
-		acr.POST("/upload", aggregate.UploadFileWithAccessKey)
+		ar.PUT("/scopes", aggregate.SetKeyScopesAuth)
 
-		acr.GET("/download", aggregate.DownloadFileByIdWithAccessKey)
+		ar.POST("/shares", aggregate.CreateShareAuth)
 
-		acr.GET("/download/*fullpath", aggregate.DownloadFileByPathWithAccessKey)
+		ar.GET("/shares", aggregate.GetSharesAuth)
 
-		acr.POST("/hidden", aggregate.ToggleHiddenByAccessKey)
+		ar.DELETE("/shares/:id", aggregate.RevokeShareAuth)
 	}
 
-	ar := r.Group("/auth/files", middlewares.UserAuthenticate)
+	kpr := r.Group("/signed/files", middlewares.CheckSigned)
+	fileRoutes(kpr)
+
+	adr := r.Group("/admin/files", middlewares.AdminAuthenticate)
 	{
-		ar.GET("/all", aggregate.GetAllFileAuth)
+		adr.POST("/reconcile", aggregate.ReconcileAdmin)
 
-		ar.POST("/upload", aggregate.UploadFileAuth)
+		adr.GET("/reconcile/:id", aggregate.GetReconcileRunAdmin)
 
-		ar.GET("/download", aggregate.DownloadFileByIdAuth)
+		adr.GET("/cascades", aggregate.GetCascadesAdmin)
+	}
 
-		ar.GET("/download/*fullpath", aggregate.DownloadFileByPathAuth)
+	dr := r.Group("/auth/dav", middlewares.UserAuthenticate)
+	for _, method := range davMethods {
+		dr.Handle(method, "/:bucketId", aggregate.WebDav)
 
-		ar.POST("/hidden", aggregate.ToggleHiddenAuth)
+		dr.Handle(method, "/:bucketId/*path", aggregate.WebDav)
 	}
 
-	kpr := r.Group("/signed/files", middlewares.CheckSigned)
+	pr := r.Group("/public/files")
 	{
-		kpr.GET("/all", aggregate.GetAllFileExclueHiddenSigned)
+		pr.GET("/download", aggregate.DownloadPresigned)
+
+		pr.POST("/upload", aggregate.UploadPresigned)
+
+		pr.GET("/shares/:id", aggregate.GetShare)
 
-		kpr.GET("/hidden/all", aggregate.GetAllFileSigned)
+		pr.GET("/shares/:id/download", aggregate.DownloadShare)
+	}
+}
 
-		kpr.POST("/upload", aggregate.UploadFileSigned)
+// fileRoutes registers the file API on a route group. The handlers only see
+// the principal the group's middleware produced, so every group serves the
+// same endpoints with the same semantics.
+func fileRoutes(g *gin.RouterGroup) {
+	g.GET("/all", policy.RequirePermission(policy.GetFileList), aggregate.GetAllFile)
 
-		kpr.GET("/download", aggregate.DownloadFileByIdSigned)
+	g.GET("/hidden/all", policy.RequirePermission(policy.GetFileListHidden), aggregate.GetAllFileIncludeHidden)
 
-		kpr.GET("/download/*fullpath", aggregate.DownloadFileByPathSigned)
+	g.GET("/search", policy.RequirePermission(policy.GetFileList), aggregate.SearchFile)
 
-		kpr.POST("/hidden", aggregate.ToggleHiddenSigned)
-	}
+	g.GET("/hidden/search", policy.RequirePermission(policy.GetFileListHidden), aggregate.SearchFileIncludeHidden)
+
+	g.POST("/upload", policy.RequirePermission(policy.Upload), middlewares.Idempotent, aggregate.UploadFile)
+
+	g.GET("/download", policy.RequirePermission(policy.Download), aggregate.DownloadFileById)
+
+	g.GET("/download/*fullpath", policy.RequirePermission(policy.Download), aggregate.DownloadFileByPath)
+
+	g.POST("/presign/download", policy.RequirePermission(policy.Download), aggregate.PresignDownload)
+
+	g.POST("/presign/upload", policy.RequirePermission(policy.Upload), aggregate.PresignUpload)
+
+	g.GET("/archive", policy.RequirePermission(policy.Download), aggregate.DownloadArchive)
+
+	g.POST("/hidden", policy.RequirePermission(policy.MarkHidden), middlewares.Idempotent, aggregate.ToggleHidden)
+
+	g.POST("/batch", middlewares.Idempotent, aggregate.BatchFile)
+
+	g.GET("/events", policy.RequirePermission(policy.GetFileList), aggregate.StreamEvents)
+
+	g.GET("/metadata", policy.RequirePermission(policy.GetFileList), aggregate.GetFileMetadata)
+
+	g.HEAD("/metadata", policy.RequirePermission(policy.GetFileList), aggregate.GetFileMetadata)
+
+	g.PATCH("/metadata", policy.RequirePermission(policy.Upload), aggregate.UpdateFileMetadata)
 }
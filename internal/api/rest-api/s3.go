@@ -0,0 +1,27 @@
+package rest_api
+
+import (
+	"github.com/Nubes3/file-service/internal/aggregate"
+	"github.com/Nubes3/file-service/internal/api/middlewares"
+	"github.com/gin-gonic/gin"
+)
+
+// S3Routes serves the S3 compatible gateway with path style addressing, so
+// it needs an engine of its own: bucket names take the first path segment.
+func S3Routes(r *gin.Engine) {
+	r.Use(middlewares.RequestId, middlewares.Metrics("s3"), middlewares.S3Authenticate)
+
+	r.GET("/", aggregate.S3ListBuckets)
+
+	r.GET("/:bucket", aggregate.S3GetBucket)
+
+	r.HEAD("/:bucket", aggregate.S3HeadBucket)
+
+	r.GET("/:bucket/*key", aggregate.S3GetObject)
+
+	r.HEAD("/:bucket/*key", aggregate.S3HeadObject)
+
+	r.PUT("/:bucket/*key", aggregate.S3PutObject)
+
+	r.DELETE("/:bucket/*key", aggregate.S3DeleteObject)
+}
@@ -0,0 +1,84 @@
+// Package s3error writes errors in the XML body S3 clients expect, mapping
+// them the same way apierror does for the JSON API.
+package s3error
+
+import (
+	"encoding/xml"
+	"github.com/Nubes3/file-service/internal/api/apierror"
+	"github.com/gin-gonic/gin"
+	"log"
+	"net/http"
+)
+
+// S3 error codes used by the gateway.
+const (
+	AccessDenied          = "AccessDenied"
+	EntityTooLarge        = "EntityTooLarge"
+	InternalError         = "InternalError"
+	InvalidAccessKeyId    = "InvalidAccessKeyId"
+	InvalidArgument       = "InvalidArgument"
+	InvalidRange          = "InvalidRange"
+	MissingContentLength  = "MissingContentLength"
+	NoSuchBucket          = "NoSuchBucket"
+	NoSuchKey             = "NoSuchKey"
+	NotImplemented        = "NotImplemented"
+	RequestTimeTooSkewed  = "RequestTimeTooSkewed"
+	ServiceUnavailable    = "ServiceUnavailable"
+	SignatureDoesNotMatch = "SignatureDoesNotMatch"
+)
+
+type Body struct {
+	XMLName   xml.Name `xml:"Error"`
+	Code      string   `xml:"Code"`
+	Message   string   `xml:"Message"`
+	Resource  string   `xml:"Resource"`
+	RequestId string   `xml:"RequestId"`
+}
+
+// Respond aborts the request with the S3 error for err.
+func Respond(c *gin.Context, err error) {
+	status, _, msg := apierror.Status(err)
+	if status >= http.StatusInternalServerError {
+		log.Println("request " + c.GetString(apierror.RequestIdKey) + " " + c.Request.URL.Path + " failed: " + err.Error())
+	}
+
+	Abort(c, status, CodeOf(status), msg)
+}
+
+// Abort aborts the request with status and an S3 code chosen by the caller.
+func Abort(c *gin.Context, status int, code string, msg string) {
+	body := Body{
+		Code:      code,
+		Message:   msg,
+		Resource:  c.Request.URL.Path,
+		RequestId: c.GetString(apierror.RequestIdKey),
+	}
+
+	c.Header("x-amz-request-id", body.RequestId)
+	if c.Request.Method == http.MethodHead {
+		c.AbortWithStatus(status)
+		return
+	}
+	c.XML(status, body)
+	c.Abort()
+}
+
+// CodeOf is the S3 code used for a status when the caller has no better one.
+func CodeOf(status int) string {
+	switch status {
+	case http.StatusBadRequest, http.StatusConflict:
+		return InvalidArgument
+	case http.StatusUnauthorized, http.StatusForbidden, http.StatusGone:
+		return AccessDenied
+	case http.StatusNotFound:
+		return NoSuchKey
+	case http.StatusRequestEntityTooLarge:
+		return EntityTooLarge
+	case http.StatusNotImplemented:
+		return NotImplemented
+	case http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return ServiceUnavailable
+	}
+
+	return InternalError
+}
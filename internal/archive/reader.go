@@ -0,0 +1,136 @@
+package archive
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"github.com/Nubes3/common/utils"
+	"io"
+	"path"
+	"strings"
+)
+
+type Entry struct {
+	Name string
+	Size int64
+}
+
+// DetectFormat guesses the archive format from an uploaded file name.
+func DetectFormat(filename string) (Format, error) {
+	lower := strings.ToLower(filename)
+	switch {
+	case strings.HasSuffix(lower, ".zip"):
+		return Zip, nil
+	case strings.HasSuffix(lower, ".tar.gz"), strings.HasSuffix(lower, ".tgz"):
+		return TarGz, nil
+	case strings.HasSuffix(lower, ".tar"):
+		return Tar, nil
+	default:
+		return -1, &utils.ModelError{
+			Msg:     "unsupported archive: " + filename,
+			ErrType: utils.Invalid,
+		}
+	}
+}
+
+// CleanEntryName turns an archive entry name into a slash separated relative
+// path, rejecting absolute names and names escaping the extraction root.
+func CleanEntryName(name string) (string, error) {
+	name = strings.ReplaceAll(name, "\\", "/")
+	if strings.HasPrefix(name, "/") || strings.Contains(name, ":") {
+		return "", &utils.ModelError{
+			Msg:     "absolute entry path: " + name,
+			ErrType: utils.Invalid,
+		}
+	}
+
+	cleaned := path.Clean(name)
+	if cleaned == "." || cleaned == ".." || strings.HasPrefix(cleaned, "../") {
+		return "", &utils.ModelError{
+			Msg:     "invalid entry path: " + name,
+			ErrType: utils.Invalid,
+		}
+	}
+
+	return cleaned, nil
+}
+
+// Walk calls fn for every regular file in the archive. Entry names are
+// passed as stored; callers must run them through CleanEntryName. A non-nil
+// error from fn stops the walk and is returned.
+func Walk(format Format, r io.ReaderAt, size int64, fn func(entry Entry, reader io.Reader) error) error {
+	if format == Zip {
+		return walkZip(r, size, fn)
+	}
+
+	var reader io.Reader = io.NewSectionReader(r, 0, size)
+	if format == TarGz {
+		gr, err := gzip.NewReader(reader)
+		if err != nil {
+			return &utils.ModelError{
+				Msg:     err.Error(),
+				ErrType: utils.Invalid,
+			}
+		}
+		defer gr.Close()
+		reader = gr
+	}
+
+	return walkTar(reader, fn)
+}
+
+func walkZip(r io.ReaderAt, size int64, fn func(entry Entry, reader io.Reader) error) error {
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return &utils.ModelError{
+			Msg:     err.Error(),
+			ErrType: utils.Invalid,
+		}
+	}
+
+	for _, f := range zr.File {
+		if f.FileInfo().IsDir() {
+			continue
+		}
+
+		rc, err := f.Open()
+		if err != nil {
+			return &utils.ModelError{
+				Msg:     err.Error(),
+				ErrType: utils.Invalid,
+			}
+		}
+
+		err = fn(Entry{Name: f.Name, Size: int64(f.UncompressedSize64)}, rc)
+		_ = rc.Close()
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func walkTar(r io.Reader, fn func(entry Entry, reader io.Reader) error) error {
+	tr := tar.NewReader(r)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return &utils.ModelError{
+				Msg:     err.Error(),
+				ErrType: utils.Invalid,
+			}
+		}
+
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+
+		err = fn(Entry{Name: header.Name, Size: header.Size}, tr)
+		if err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,125 @@
+package archive
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"github.com/Nubes3/common/utils"
+	"io"
+	"strings"
+	"time"
+)
+
+type Format int
+
+const (
+	Zip Format = iota
+	TarGz
+	Tar
+)
+
+func ParseFormat(format string) (Format, error) {
+	switch strings.ToLower(format) {
+	case "zip":
+		return Zip, nil
+	case "tar.gz", "tgz":
+		return TarGz, nil
+	case "tar":
+		return Tar, nil
+	default:
+		return -1, &utils.ModelError{
+			Msg:     "invalid archive format: " + format,
+			ErrType: utils.Invalid,
+		}
+	}
+}
+
+func (f Format) Extension() string {
+	return [...]string{
+		".zip",
+		".tar.gz",
+		".tar",
+	}[f]
+}
+
+func (f Format) ContentType() string {
+	return [...]string{
+		"application/zip",
+		"application/gzip",
+		"application/x-tar",
+	}[f]
+}
+
+// Writer streams entries into an archive without buffering them.
+type Writer interface {
+	Add(name string, size int64, modTime time.Time, reader io.Reader) error
+	Close() error
+}
+
+func NewWriter(format Format, w io.Writer) Writer {
+	switch format {
+	case TarGz:
+		gw := gzip.NewWriter(w)
+		return &tarWriter{gw: gw, tw: tar.NewWriter(gw)}
+	case Tar:
+		return &tarWriter{tw: tar.NewWriter(w)}
+	default:
+		return &zipWriter{zw: zip.NewWriter(w)}
+	}
+}
+
+type zipWriter struct {
+	zw *zip.Writer
+}
+
+func (z *zipWriter) Add(name string, size int64, modTime time.Time, reader io.Reader) error {
+	header := &zip.FileHeader{
+		Name:     name,
+		Method:   zip.Deflate,
+		Modified: modTime,
+	}
+
+	w, err := z.zw.CreateHeader(header)
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(w, reader)
+	return err
+}
+
+func (z *zipWriter) Close() error {
+	return z.zw.Close()
+}
+
+type tarWriter struct {
+	gw *gzip.Writer
+	tw *tar.Writer
+}
+
+func (t *tarWriter) Add(name string, size int64, modTime time.Time, reader io.Reader) error {
+	err := t.tw.WriteHeader(&tar.Header{
+		Name:     name,
+		Mode:     0644,
+		Size:     size,
+		ModTime:  modTime,
+		Typeflag: tar.TypeReg,
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = io.CopyN(t.tw, reader, size)
+	return err
+}
+
+func (t *tarWriter) Close() error {
+	if err := t.tw.Close(); err != nil {
+		return err
+	}
+	if t.gw == nil {
+		return nil
+	}
+
+	return t.gw.Close()
+}
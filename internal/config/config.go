@@ -0,0 +1,62 @@
+package config
+
+import (
+	"github.com/spf13/viper"
+)
+
+// Config holds the file service specific settings, read from the same
+// config.json as the common config.
+type Config struct {
+	ArchiveMaxSize    int64  `mapstructure:"archive_max_size"`
+	ExtractMaxSize    int64  `mapstructure:"extract_max_size"`
+	ExtractMaxEntries int    `mapstructure:"extract_max_entries"`
+	BatchMaxSize      int    `mapstructure:"batch_max_size"`
+	BatchConcurrency  int    `mapstructure:"batch_concurrency"`
+	EventBufferSize   int    `mapstructure:"event_buffer_size"`
+	OutboxRetention   int    `mapstructure:"outbox_retention_hours"`
+	WebhookAttempts   int    `mapstructure:"webhook_max_attempts"`
+	WebhookTimeout    int    `mapstructure:"webhook_timeout_seconds"`
+	AdminToken        string `mapstructure:"admin_token"`
+	IdempotencyWindow int    `mapstructure:"idempotency_window_hours"`
+	PresignSecret     string `mapstructure:"presign_secret"`
+	PresignDefaultTtl int64  `mapstructure:"presign_default_ttl_seconds"`
+	PresignMaxTtl     int64  `mapstructure:"presign_max_ttl_seconds"`
+	PresignUploadSize int64  `mapstructure:"presign_upload_max_size"`
+	PublicUrl         string `mapstructure:"public_url"`
+	CascadeMode       string `mapstructure:"cascade_mode"`
+	MetricsToken      string `mapstructure:"metrics_token"`
+
+	// WebhookAllowedNets are CIDRs webhooks may be sent to even though they
+	// are private, e.g. receivers inside the cluster.
+	WebhookAllowedNets []string `mapstructure:"webhook_allowed_nets"`
+}
+
+var Conf Config
+
+func init() {
+	viper.SetConfigName("config")
+	viper.SetConfigType("json")
+	viper.AddConfigPath(".")
+
+	viper.SetDefault("archive_max_size", 1<<30)
+	viper.SetDefault("extract_max_size", 1<<30)
+	viper.SetDefault("extract_max_entries", 10000)
+	viper.SetDefault("batch_max_size", 1000)
+	viper.SetDefault("batch_concurrency", 8)
+	viper.SetDefault("event_buffer_size", 1024)
+	viper.SetDefault("outbox_retention_hours", 168)
+	viper.SetDefault("webhook_max_attempts", 8)
+	viper.SetDefault("webhook_timeout_seconds", 10)
+	viper.SetDefault("idempotency_window_hours", 24)
+	viper.SetDefault("presign_default_ttl_seconds", 3600)
+	viper.SetDefault("presign_max_ttl_seconds", 7*24*3600)
+	viper.SetDefault("presign_upload_max_size", 1<<30)
+	viper.SetDefault("cascade_mode", "soft_delete")
+
+	viper.ReadInConfig()
+
+	err := viper.Unmarshal(&Conf)
+	if err != nil {
+		panic(err)
+	}
+}
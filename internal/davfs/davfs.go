@@ -0,0 +1,182 @@
+// Package davfs exposes a bucket as a webdav.FileSystem. Files live in the
+// metadata repository and SeaweedFS, folders in the folder service.
+//
+// Folders can be created and copied but not removed or renamed: the folder
+// service owns them and the file service only follows its events.
+package davfs
+
+import (
+	"context"
+	"github.com/Nubes3/common/models/arangodb"
+	"github.com/Nubes3/common/utils"
+	arango "github.com/Nubes3/file-service/internal/repo/arangodb"
+	"github.com/Nubes3/file-service/internal/repo/nats"
+	"golang.org/x/net/webdav"
+	"os"
+	"strings"
+)
+
+type fileSystem struct {
+	bucket *arangodb.Bucket
+}
+
+// New returns the file system of bucket. Paths are relative to the bucket
+// folder.
+func New(bucket *arangodb.Bucket) webdav.FileSystem {
+	return &fileSystem{bucket: bucket}
+}
+
+func (fs *fileSystem) root() string {
+	return "/" + fs.bucket.Name
+}
+
+// fullpath maps a WebDAV name to the full path of the file or folder.
+func (fs *fileSystem) fullpath(name string) string {
+	name = strings.Trim(name, "/")
+	if name == "" {
+		return fs.root()
+	}
+
+	return fs.root() + "/" + name
+}
+
+// find resolves name to a file or a folder, os.ErrNotExist when it is
+// neither.
+func (fs *fileSystem) find(name string) (*arangodb.FileMetadata, *arangodb.Folder, error) {
+	full := fs.fullpath(name)
+	if full != fs.root() {
+		fm, err := arango.FindMetadataByFilename(utils.GetParentPath(full), utils.GetFileName(full), *fs.bucket.Id)
+		if err == nil {
+			return fm, nil, nil
+		}
+		if e, ok := err.(*utils.ModelError); !ok || e.ErrType != utils.NotFound {
+			return nil, nil, err
+		}
+	}
+
+	folder, err := fs.findFolder(full)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return nil, folder, nil
+}
+
+func (fs *fileSystem) findFolder(full string) (*arangodb.Folder, error) {
+	folder, err := nats.FindFolderByFullpath(full)
+	if err != nil {
+		if e, ok := err.(*utils.ModelError); ok && e.ErrType == utils.Timeout {
+			return nil, err
+		}
+		if full == fs.root() {
+			return &arangodb.Folder{Name: fs.bucket.Name, Fullpath: full}, nil
+		}
+		return nil, os.ErrNotExist
+	}
+
+	return folder, nil
+}
+
+func (fs *fileSystem) Mkdir(ctx context.Context, name string, perm os.FileMode) error {
+	full := fs.fullpath(name)
+	if full == fs.root() {
+		return os.ErrExist
+	}
+
+	if _, _, err := fs.find(name); err == nil {
+		return os.ErrExist
+	} else if err != os.ErrNotExist {
+		return err
+	}
+
+	if _, err := fs.findFolder(utils.GetParentPath(full)); err != nil {
+		return err
+	}
+
+	// Folders are created through the folder service, which offers no
+	// request to create them here.
+	return os.ErrPermission
+}
+
+func (fs *fileSystem) OpenFile(ctx context.Context, name string, flag int, perm os.FileMode) (webdav.File, error) {
+	if flag&(os.O_WRONLY|os.O_RDWR|os.O_CREATE|os.O_TRUNC) != 0 {
+		full := fs.fullpath(name)
+		if full == fs.root() {
+			return nil, os.ErrExist
+		}
+
+		_, folder, err := fs.find(name)
+		if err == nil && folder != nil {
+			return nil, os.ErrExist
+		} else if err != nil && err != os.ErrNotExist {
+			return nil, err
+		}
+
+		parent := utils.GetParentPath(full)
+		if _, err := fs.findFolder(parent); err != nil {
+			return nil, err
+		}
+
+		return newWriteFile(fs.bucket, parent, utils.GetFileName(full))
+	}
+
+	fm, folder, err := fs.find(name)
+	if err != nil {
+		return nil, err
+	}
+
+	if fm != nil {
+		return &readFile{fm: fm}, nil
+	}
+	return &dirFile{fs: fs, folder: folder}, nil
+}
+
+// RemoveAll deletes a file. Folders are refused, see the package comment.
+func (fs *fileSystem) RemoveAll(ctx context.Context, name string) error {
+	fm, _, err := fs.find(name)
+	if err != nil {
+		return err
+	}
+	if fm == nil {
+		return os.ErrPermission
+	}
+
+	_, err = arango.DeleteFile(fm.Id)
+	return err
+}
+
+// Rename moves a file. Folders are refused, see the package comment.
+func (fs *fileSystem) Rename(ctx context.Context, oldName, newName string) error {
+	fm, _, err := fs.find(oldName)
+	if err != nil {
+		return err
+	}
+	if fm == nil {
+		return os.ErrPermission
+	}
+
+	full := fs.fullpath(newName)
+	if full == fs.root() {
+		return os.ErrExist
+	}
+
+	parent := utils.GetParentPath(full)
+	if _, err := fs.findFolder(parent); err != nil {
+		return err
+	}
+
+	_, err = arango.MoveFile(fm.Id, parent, utils.GetFileName(full))
+	return err
+}
+
+func (fs *fileSystem) Stat(ctx context.Context, name string) (os.FileInfo, error) {
+	fm, folder, err := fs.find(name)
+	if err != nil {
+		return nil, err
+	}
+
+	if fm != nil {
+		return fileInfoOf(fm), nil
+	}
+	return folderInfoOf(folder), nil
+}
@@ -0,0 +1,255 @@
+package davfs
+
+import (
+	"context"
+	"github.com/Nubes3/common/models/arangodb"
+	"github.com/Nubes3/file-service/internal/metrics"
+	arango "github.com/Nubes3/file-service/internal/repo/arangodb"
+	seaweed "github.com/Nubes3/file-service/internal/repo/seaweedfs"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"time"
+)
+
+type fileInfo struct {
+	name    string
+	size    int64
+	modTime time.Time
+	isDir   bool
+}
+
+func fileInfoOf(fm *arangodb.FileMetadata) *fileInfo {
+	return &fileInfo{name: fm.Name, size: fm.Size, modTime: fm.UploadedDate}
+}
+
+func folderInfoOf(folder *arangodb.Folder) *fileInfo {
+	return &fileInfo{name: folder.Name, isDir: true}
+}
+
+func (fi *fileInfo) Name() string       { return fi.name }
+func (fi *fileInfo) Size() int64        { return fi.size }
+func (fi *fileInfo) ModTime() time.Time { return fi.modTime }
+func (fi *fileInfo) IsDir() bool        { return fi.isDir }
+func (fi *fileInfo) Sys() interface{}   { return nil }
+
+func (fi *fileInfo) Mode() os.FileMode {
+	if fi.isDir {
+		return os.ModeDir | 0755
+	}
+	return 0644
+}
+
+// readFile reads a stored file. The blob is streamed from the current
+// offset and only reopened when a seek moved away from it.
+type readFile struct {
+	fm         *arangodb.FileMetadata
+	offset     int64
+	body       io.ReadCloser
+	bodyOffset int64
+}
+
+func (f *readFile) Read(p []byte) (int, error) {
+	if f.offset >= f.fm.Size {
+		return 0, io.EOF
+	}
+
+	if f.body == nil || f.bodyOffset != f.offset {
+		if f.body != nil {
+			_ = f.body.Close()
+		}
+
+		body, err := seaweed.OpenBlob(f.fm.FileId, f.offset)
+		if err != nil {
+			f.body = nil
+			return 0, err
+		}
+		f.body = metrics.Track(metrics.Download, f.fm.BucketId, body)
+		f.bodyOffset = f.offset
+	}
+
+	n, err := f.body.Read(p)
+	f.offset += int64(n)
+	f.bodyOffset += int64(n)
+	return n, err
+}
+
+func (f *readFile) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekCurrent:
+		offset += f.offset
+	case io.SeekEnd:
+		offset += f.fm.Size
+	}
+	if offset < 0 {
+		return 0, os.ErrInvalid
+	}
+
+	f.offset = offset
+	return offset, nil
+}
+
+func (f *readFile) Close() error {
+	if f.body != nil {
+		return f.body.Close()
+	}
+	return nil
+}
+
+func (f *readFile) Readdir(count int) ([]os.FileInfo, error) {
+	return nil, os.ErrInvalid
+}
+
+func (f *readFile) Stat() (os.FileInfo, error) {
+	return fileInfoOf(f.fm), nil
+}
+
+func (f *readFile) Write(p []byte) (int, error) {
+	return 0, os.ErrPermission
+}
+
+// ContentType lets PROPFIND report the stored type without reading the
+// blob to sniff it.
+func (f *readFile) ContentType(ctx context.Context) (string, error) {
+	return f.fm.ContentType, nil
+}
+
+// dirFile lists a folder: its sub folders from the folder service and its
+// visible files from the metadata repository.
+type dirFile struct {
+	fs      *fileSystem
+	folder  *arangodb.Folder
+	entries []os.FileInfo
+	listed  bool
+}
+
+func (f *dirFile) Readdir(count int) ([]os.FileInfo, error) {
+	if !f.listed {
+		if err := f.list(); err != nil {
+			return nil, err
+		}
+	}
+
+	if count <= 0 {
+		entries := f.entries
+		f.entries = nil
+		return entries, nil
+	}
+
+	if len(f.entries) == 0 {
+		return nil, io.EOF
+	}
+	if count > len(f.entries) {
+		count = len(f.entries)
+	}
+	entries := f.entries[:count]
+	f.entries = f.entries[count:]
+	return entries, nil
+}
+
+func (f *dirFile) list() error {
+	f.listed = true
+
+	for _, child := range f.folder.Children {
+		if child.Type != "file" {
+			f.entries = append(f.entries, &fileInfo{name: child.Name, isDir: true})
+		}
+	}
+
+	files, err := arango.FindMetadataByPath(*f.fs.bucket.Id, f.folder.Fullpath, false)
+	if err != nil {
+		return err
+	}
+	for i := range files {
+		f.entries = append(f.entries, fileInfoOf(&files[i]))
+	}
+
+	return nil
+}
+
+func (f *dirFile) Read(p []byte) (int, error) {
+	return 0, os.ErrInvalid
+}
+
+func (f *dirFile) Seek(offset int64, whence int) (int64, error) {
+	return 0, os.ErrInvalid
+}
+
+func (f *dirFile) Write(p []byte) (int, error) {
+	return 0, os.ErrInvalid
+}
+
+func (f *dirFile) Close() error {
+	return nil
+}
+
+func (f *dirFile) Stat() (os.FileInfo, error) {
+	return folderInfoOf(f.folder), nil
+}
+
+// writeFile spools an upload to a temporary file, as SaveFile needs the
+// size up front, and stores it when closed.
+type writeFile struct {
+	bucket *arangodb.Bucket
+	path   string
+	name   string
+	tmp    *os.File
+}
+
+func newWriteFile(bucket *arangodb.Bucket, path string, name string) (*writeFile, error) {
+	tmp, err := ioutil.TempFile("", "davfs-")
+	if err != nil {
+		return nil, err
+	}
+
+	return &writeFile{bucket: bucket, path: path, name: name, tmp: tmp}, nil
+}
+
+func (f *writeFile) Write(p []byte) (int, error) {
+	return f.tmp.Write(p)
+}
+
+func (f *writeFile) Read(p []byte) (int, error) {
+	return f.tmp.Read(p)
+}
+
+func (f *writeFile) Seek(offset int64, whence int) (int64, error) {
+	return f.tmp.Seek(offset, whence)
+}
+
+func (f *writeFile) Readdir(count int) ([]os.FileInfo, error) {
+	return nil, os.ErrInvalid
+}
+
+func (f *writeFile) Stat() (os.FileInfo, error) {
+	info, err := f.tmp.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	return &fileInfo{name: f.name, size: info.Size(), modTime: info.ModTime()}, nil
+}
+
+// Close stores the spooled content, replacing the file at the same path.
+func (f *writeFile) Close() error {
+	defer func() {
+		_ = f.tmp.Close()
+		_ = os.Remove(f.tmp.Name())
+	}()
+
+	size, err := f.tmp.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+
+	head := make([]byte, 512)
+	n, _ := f.tmp.ReadAt(head, 0)
+	if _, err := f.tmp.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	_, err = arango.ReplaceFile(f.tmp, *f.bucket.Id, f.path, f.name, false,
+		http.DetectContentType(head[:n]), size, time.Duration(0), nil)
+	return err
+}
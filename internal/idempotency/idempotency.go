@@ -0,0 +1,228 @@
+// Package idempotency replays the stored first response of requests that
+// repeat an Idempotency-Key.
+package idempotency
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"github.com/Nubes3/file-service/internal/api/apierror"
+	"github.com/Nubes3/file-service/internal/config"
+	"github.com/gin-gonic/gin"
+	"io"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	KeyHeader = "Idempotency-Key"
+	keyMaxLen = 255
+	// Responses larger than this are not kept; the key is released instead.
+	maxBody = 1 << 20
+	// A key still processing after this is considered abandoned.
+	lease = time.Hour
+)
+
+// Response is the first response stored for a key. Completed is false while
+// the first request is still being processed.
+type Response struct {
+	Completed   bool
+	Fingerprint string
+	Status      int
+	ContentType string
+	Body        []byte
+}
+
+// Store keeps the keys. Reserve claims key for window and returns nil when
+// the caller got it, otherwise the state the earlier request left; a key
+// still processing after lease is taken over. Complete stores the response
+// of the request holding key and Release frees it for a retry.
+type Store interface {
+	Reserve(key string, window time.Duration, lease time.Duration) (*Response, error)
+	Complete(key string, res *Response) error
+	Release(key string) error
+}
+
+type recordingWriter struct {
+	gin.ResponseWriter
+	body     bytes.Buffer
+	overflow bool
+}
+
+func (w *recordingWriter) Write(b []byte) (int, error) {
+	w.record(b)
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *recordingWriter) WriteString(s string) (int, error) {
+	w.record([]byte(s))
+	return w.ResponseWriter.WriteString(s)
+}
+
+func (w *recordingWriter) record(b []byte) {
+	if w.overflow || w.body.Len()+len(b) > maxBody {
+		w.overflow = true
+		return
+	}
+	w.body.Write(b)
+}
+
+// Middleware replays the stored first response when a request repeats an
+// Idempotency-Key of the same caller, as told by scope, and route. The
+// repeat must carry the same method, query and body; a key reused for a
+// different request is rejected. Requests without the header pass through
+// untouched.
+func Middleware(store Store, scope func(c *gin.Context) string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		handle(c, store, scope(c))
+	}
+}
+
+func handle(c *gin.Context, store Store, scope string) {
+	key := c.GetHeader(KeyHeader)
+	if key == "" {
+		return
+	}
+	if len(key) > keyMaxLen {
+		apierror.Abort(c, http.StatusBadRequest, "invalid "+KeyHeader)
+
+		return
+	}
+
+	scoped := sha256.Sum256([]byte(scope + "\n" + c.FullPath() + "\n" + key))
+	id := hex.EncodeToString(scoped[:])
+
+	fingerprint := newBodyFingerprint(c.GetHeader("Content-Type"))
+	requestFingerprint := func() string {
+		sum := sha256.Sum256([]byte(c.Request.Method + "\n" + c.FullPath() + "\n" +
+			c.Request.URL.RawQuery + "\n" + fingerprint.Sum()))
+		return hex.EncodeToString(sum[:])
+	}
+
+	window := time.Hour * time.Duration(config.Conf.IdempotencyWindow)
+	existing, err := store.Reserve(id, window, lease)
+	if err != nil {
+		apierror.Respond(c, err)
+
+		return
+	}
+
+	if existing != nil {
+		_, _ = io.Copy(fingerprint, c.Request.Body)
+		switch {
+		case !existing.Completed:
+			fingerprint.Sum()
+			apierror.Abort(c, http.StatusConflict, "a request with this "+KeyHeader+" is still in progress")
+		case existing.Fingerprint != requestFingerprint():
+			apierror.Abort(c, http.StatusUnprocessableEntity, KeyHeader+" was already used for a different request")
+		default:
+			c.Header("Idempotent-Replayed", "true")
+			c.Data(existing.Status, existing.ContentType, existing.Body)
+			c.Abort()
+		}
+
+		return
+	}
+
+	// Release the key unless a response got stored, also when the handler
+	// panics, so the request can be retried.
+	completed := false
+	defer func() {
+		fingerprint.Sum()
+		if !completed {
+			_ = store.Release(id)
+		}
+	}()
+
+	body := c.Request.Body
+	c.Request.Body = ioutil.NopCloser(io.TeeReader(body, fingerprint))
+	w := &recordingWriter{ResponseWriter: c.Writer}
+	c.Writer = w
+
+	c.Next()
+
+	// Hash whatever the handler left unread so the fingerprint covers the
+	// whole body.
+	_, _ = io.Copy(ioutil.Discard, c.Request.Body)
+	_ = body.Close()
+
+	if w.Status() >= http.StatusInternalServerError || w.overflow {
+		return
+	}
+
+	completed = store.Complete(id, &Response{
+		Fingerprint: requestFingerprint(),
+		Status:      w.Status(),
+		ContentType: w.Header().Get("Content-Type"),
+		Body:        w.body.Bytes(),
+	}) == nil
+}
+
+// bodyFingerprint hashes a request body as it streams through.
+type bodyFingerprint struct {
+	io.Writer
+	sum    func() string
+	once   sync.Once
+	result string
+}
+
+// newBodyFingerprint hashes multipart bodies by their parts rather than
+// their bytes: clients pick a new random boundary for every attempt, so the
+// raw bytes of a retried upload never match.
+func newBodyFingerprint(contentType string) *bodyFingerprint {
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil || !strings.HasPrefix(mediaType, "multipart/") || params["boundary"] == "" {
+		h := sha256.New()
+		return &bodyFingerprint{
+			Writer: h,
+			sum: func() string {
+				return hex.EncodeToString(h.Sum(nil))
+			},
+		}
+	}
+
+	pr, pw := io.Pipe()
+	result := make(chan string, 1)
+	go func() {
+		h := sha256.New()
+		mr := multipart.NewReader(pr, params["boundary"])
+		for {
+			part, err := mr.NextPart()
+			if err == io.EOF {
+				break
+			} else if err != nil {
+				_, _ = io.WriteString(h, "malformed\n")
+				break
+			}
+
+			content := sha256.New()
+			_, _ = io.Copy(content, part)
+			_, _ = io.WriteString(h, part.FormName()+"\n"+part.FileName()+"\n"+hex.EncodeToString(content.Sum(nil))+"\n")
+		}
+
+		_, _ = io.Copy(ioutil.Discard, pr)
+		result <- hex.EncodeToString(h.Sum(nil))
+	}()
+
+	return &bodyFingerprint{
+		Writer: pw,
+		sum: func() string {
+			_ = pw.Close()
+			return <-result
+		},
+	}
+}
+
+// Sum ends the body and returns its hash; later writes are lost.
+func (f *bodyFingerprint) Sum() string {
+	f.once.Do(func() {
+		f.result = f.sum()
+	})
+
+	return f.result
+}
@@ -0,0 +1,284 @@
+package idempotency
+
+import (
+	"bytes"
+	"github.com/gin-gonic/gin"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// memStore keeps keys in memory the way the Arango store does, without
+// windows and leases.
+type memStore struct {
+	mu       sync.Mutex
+	keys     map[string]*Response
+	released int
+}
+
+func newMemStore() *memStore {
+	return &memStore{keys: map[string]*Response{}}
+}
+
+func (s *memStore) Reserve(key string, window time.Duration, lease time.Duration) (*Response, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if res, ok := s.keys[key]; ok {
+		copied := *res
+		return &copied, nil
+	}
+	s.keys[key] = &Response{}
+
+	return nil, nil
+}
+
+func (s *memStore) Complete(key string, res *Response) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored := *res
+	stored.Completed = true
+	s.keys[key] = &stored
+
+	return nil
+}
+
+func (s *memStore) Release(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.keys, key)
+	s.released++
+
+	return nil
+}
+
+// server routes POST /upload through the middleware to handler and counts
+// the calls that reach it.
+func server(store Store, handler gin.HandlerFunc) (*gin.Engine, *int32) {
+	gin.SetMode(gin.TestMode)
+
+	var calls int32
+	r := gin.New()
+	r.POST("/upload", Middleware(store, func(c *gin.Context) string { return "user" }), func(c *gin.Context) {
+		atomic.AddInt32(&calls, 1)
+		handler(c)
+	})
+
+	return r, &calls
+}
+
+func post(r http.Handler, key string, contentType string, body []byte) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/upload?bucketId=b1", bytes.NewReader(body))
+	req.Header.Set("Content-Type", contentType)
+	if key != "" {
+		req.Header.Set(KeyHeader, key)
+	}
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	return w
+}
+
+// echo answers 201 with the request body.
+func echo(c *gin.Context) {
+	body, _ := ioutil.ReadAll(c.Request.Body)
+	c.Data(http.StatusCreated, "text/plain", body)
+}
+
+func TestReplay(t *testing.T) {
+	r, calls := server(newMemStore(), echo)
+
+	first := post(r, "k1", "text/plain", []byte("hello"))
+	second := post(r, "k1", "text/plain", []byte("hello"))
+
+	if *calls != 1 {
+		t.Fatalf("handler ran %d times", *calls)
+	}
+	if second.Code != http.StatusCreated || second.Body.String() != "hello" ||
+		second.Header().Get("Content-Type") != first.Header().Get("Content-Type") {
+		t.Errorf("replay = %d %q %q", second.Code, second.Body.String(), second.Header().Get("Content-Type"))
+	}
+	if second.Header().Get("Idempotent-Replayed") != "true" {
+		t.Error("replay not marked as replayed")
+	}
+	if first.Header().Get("Idempotent-Replayed") != "" {
+		t.Error("first response marked as replayed")
+	}
+}
+
+func TestWithoutKey(t *testing.T) {
+	r, calls := server(newMemStore(), echo)
+
+	post(r, "", "text/plain", []byte("hello"))
+	post(r, "", "text/plain", []byte("hello"))
+
+	if *calls != 2 {
+		t.Errorf("handler ran %d times", *calls)
+	}
+}
+
+func TestMismatchedBody(t *testing.T) {
+	r, calls := server(newMemStore(), echo)
+
+	post(r, "k1", "text/plain", []byte("hello"))
+	w := post(r, "k1", "text/plain", []byte("hello, world"))
+
+	if w.Code != http.StatusUnprocessableEntity {
+		t.Errorf("status = %d", w.Code)
+	}
+	if *calls != 1 {
+		t.Errorf("handler ran %d times", *calls)
+	}
+}
+
+func multipartBody(t *testing.T, content string) (string, []byte) {
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	fw, err := mw.CreateFormFile("file", "a.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _ = fw.Write([]byte(content))
+	_ = mw.WriteField("path", "/bucket")
+	_ = mw.Close()
+
+	return mw.FormDataContentType(), body.Bytes()
+}
+
+func TestMultipartFingerprint(t *testing.T) {
+	r, calls := server(newMemStore(), func(c *gin.Context) {
+		// Leave the body unread, the fingerprint must still cover it.
+		c.Status(http.StatusCreated)
+	})
+
+	// Every body gets a new random boundary.
+	contentType, body := multipartBody(t, "content")
+	post(r, "k1", contentType, body)
+	contentType, body = multipartBody(t, "content")
+	replay := post(r, "k1", contentType, body)
+	contentType, body = multipartBody(t, "changed")
+	changed := post(r, "k1", contentType, body)
+
+	if replay.Code != http.StatusCreated || replay.Header().Get("Idempotent-Replayed") != "true" {
+		t.Errorf("same parts: status %d, replayed %q", replay.Code, replay.Header().Get("Idempotent-Replayed"))
+	}
+	if changed.Code != http.StatusUnprocessableEntity {
+		t.Errorf("changed part: status %d", changed.Code)
+	}
+	if *calls != 1 {
+		t.Errorf("handler ran %d times", *calls)
+	}
+}
+
+func TestConcurrentReservation(t *testing.T) {
+	const requests = 8
+
+	var once sync.Once
+	started := make(chan struct{})
+	finish := make(chan struct{})
+	r, calls := server(newMemStore(), func(c *gin.Context) {
+		once.Do(func() { close(started) })
+		<-finish
+		echo(c)
+	})
+
+	first := make(chan *httptest.ResponseRecorder)
+	go func() {
+		first <- post(r, "k1", "text/plain", []byte("hello"))
+	}()
+	<-started
+
+	var wg sync.WaitGroup
+	codes := make([]int, requests)
+	for i := 0; i < requests; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			codes[i] = post(r, "k1", "text/plain", []byte("hello")).Code
+		}(i)
+	}
+	wg.Wait()
+	close(finish)
+
+	if w := <-first; w.Code != http.StatusCreated {
+		t.Errorf("first request: status %d", w.Code)
+	}
+	for i, code := range codes {
+		if code != http.StatusConflict {
+			t.Errorf("request %d during the first: status %d", i, code)
+		}
+	}
+	if *calls != 1 {
+		t.Errorf("handler ran %d times", *calls)
+	}
+
+	if w := post(r, "k1", "text/plain", []byte("hello")); w.Header().Get("Idempotent-Replayed") != "true" {
+		t.Errorf("request after the first: status %d, not replayed", w.Code)
+	}
+}
+
+func TestReleaseAfterServerError(t *testing.T) {
+	store := newMemStore()
+	var fail int32 = 1
+	r, calls := server(store, func(c *gin.Context) {
+		if atomic.CompareAndSwapInt32(&fail, 1, 0) {
+			c.String(http.StatusInternalServerError, "try again")
+			return
+		}
+		echo(c)
+	})
+
+	failed := post(r, "k1", "text/plain", []byte("hello"))
+	retried := post(r, "k1", "text/plain", []byte("hello"))
+
+	if failed.Code != http.StatusInternalServerError || retried.Code != http.StatusCreated {
+		t.Errorf("statuses %d, %d", failed.Code, retried.Code)
+	}
+	if retried.Header().Get("Idempotent-Replayed") != "" {
+		t.Error("retry got the failed response replayed")
+	}
+	if *calls != 2 || store.released != 1 {
+		t.Errorf("handler ran %d times, %d releases", *calls, store.released)
+	}
+}
+
+func TestReleaseAfterPanic(t *testing.T) {
+	store := newMemStore()
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(gin.CustomRecovery(func(c *gin.Context, _ interface{}) {
+		c.AbortWithStatus(http.StatusInternalServerError)
+	}))
+	r.POST("/upload", Middleware(store, func(c *gin.Context) string { return "user" }), func(c *gin.Context) {
+		panic("handler failed")
+	})
+
+	post(r, "k1", "text/plain", []byte("hello"))
+
+	if store.released != 1 || len(store.keys) != 0 {
+		t.Errorf("%d releases, %d keys left", store.released, len(store.keys))
+	}
+}
+
+func TestReleaseOnOverflow(t *testing.T) {
+	store := newMemStore()
+	r, _ := server(store, func(c *gin.Context) {
+		c.Data(http.StatusOK, "application/octet-stream", make([]byte, maxBody+1))
+	})
+
+	w := post(r, "k1", "text/plain", nil)
+
+	if w.Code != http.StatusOK || w.Body.Len() != maxBody+1 {
+		t.Errorf("status %d, %d bytes", w.Code, w.Body.Len())
+	}
+	if store.released != 1 || len(store.keys) != 0 {
+		t.Errorf("%d releases, %d keys left", store.released, len(store.keys))
+	}
+}
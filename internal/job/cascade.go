@@ -0,0 +1,80 @@
+package job
+
+import (
+	arango "github.com/Nubes3/file-service/internal/repo/arangodb"
+	"log"
+	"strconv"
+	"time"
+)
+
+const (
+	cascadeBatchSize = 500
+	// cascadeLease is how long a claimed cascade is left to its instance
+	// between two batches before another one takes it over.
+	cascadeLease = time.Minute * 5
+)
+
+// StartCascade periodically works through the cascades recorded for deleted
+// buckets and folders, one batch of files at a time. Progress is stored
+// with every batch, so a restart resumes where it stopped. Call the
+// returned function to stop it.
+func StartCascade(interval time.Duration) func() {
+	stop := make(chan struct{})
+	ticker := time.NewTicker(interval)
+
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				runCascades(stop)
+			}
+		}
+	}()
+
+	return func() {
+		close(stop)
+	}
+}
+
+// runCascades claims and runs one cascade at a time, so only the running
+// cascade holds a lease and the others stay free for other instances.
+func runCascades(stop chan struct{}) {
+	for {
+		select {
+		case <-stop:
+			return
+		default:
+		}
+
+		cascades, err := arango.ClaimCascades(1, cascadeLease)
+		if err != nil {
+			log.Println("claim cascades failed: " + err.Error())
+			return
+		}
+		if len(cascades) == 0 {
+			return
+		}
+
+		runCascade(&cascades[0], stop)
+	}
+}
+
+func runCascade(c *arango.Cascade, stop chan struct{}) {
+	for !c.Done {
+		select {
+		case <-stop:
+			return
+		default:
+		}
+
+		if _, err := arango.RunCascadeBatch(c, cascadeBatchSize, cascadeLease); err != nil {
+			log.Println("cascade " + c.Id + " failed: " + err.Error())
+			return
+		}
+	}
+
+	log.Println("cascade " + c.Id + " done: " + strconv.Itoa(c.Processed) + " files, " + c.Mode)
+}
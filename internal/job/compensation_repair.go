@@ -0,0 +1,42 @@
+package job
+
+import (
+	arango "github.com/Nubes3/file-service/internal/repo/arangodb"
+	"log"
+	"time"
+)
+
+const compensationBatchSize = 100
+
+// StartCompensationRepair periodically retries upload compensations that
+// failed when they first ran. Call the returned function to stop it.
+func StartCompensationRepair(interval time.Duration) func() {
+	stop := make(chan struct{})
+	ticker := time.NewTicker(interval)
+
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				compensations, err := arango.FindFailedCompensations(compensationBatchSize)
+				if err != nil {
+					log.Println("find failed compensations failed: " + err.Error())
+					continue
+				}
+				for i := range compensations {
+					err = arango.RetryCompensation(&compensations[i])
+					if err != nil {
+						log.Println("retry compensation " + compensations[i].Id + " failed: " + err.Error())
+					}
+				}
+			}
+		}
+	}()
+
+	return func() {
+		close(stop)
+	}
+}
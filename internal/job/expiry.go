@@ -0,0 +1,41 @@
+package job
+
+import (
+	arango "github.com/Nubes3/file-service/internal/repo/arangodb"
+	"log"
+	"time"
+)
+
+const expiryBatchSize = 100
+
+// StartExpiry periodically soft deletes expired files, which publishes their
+// expired events. Call the returned function to stop it.
+func StartExpiry(interval time.Duration) func() {
+	stop := make(chan struct{})
+	ticker := time.NewTicker(interval)
+
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				for {
+					files, err := arango.ExpireFiles(expiryBatchSize)
+					if err != nil {
+						log.Println("expire files failed: " + err.Error())
+						break
+					}
+					if len(files) < expiryBatchSize {
+						break
+					}
+				}
+			}
+		}
+	}()
+
+	return func() {
+		close(stop)
+	}
+}
@@ -0,0 +1,90 @@
+package job
+
+import (
+	"github.com/Nubes3/file-service/internal/config"
+	arango "github.com/Nubes3/file-service/internal/repo/arangodb"
+	"github.com/Nubes3/file-service/internal/repo/nats"
+	"log"
+	"time"
+)
+
+const (
+	outboxBatchSize  = 100
+	outboxMaxBackoff = time.Minute * 5
+)
+
+// StartOutboxRelay periodically publishes pending outbox events to NATS and
+// queues their webhook deliveries, retrying failures with exponential
+// backoff. Events are marked delivered only after a successful publish, so
+// delivery is at-least-once. Call the returned function to stop it.
+func StartOutboxRelay(interval time.Duration) func() {
+	stop := make(chan struct{})
+	ticker := time.NewTicker(interval)
+
+	go func() {
+		defer ticker.Stop()
+		lastPurge := time.Time{}
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				relayOutbox()
+
+				if time.Since(lastPurge) > time.Hour {
+					retention := time.Duration(config.Conf.OutboxRetention) * time.Hour
+					if err := arango.PurgeDeliveredOutbox(time.Now().Add(-retention)); err != nil {
+						log.Println("purge outbox failed: " + err.Error())
+					}
+					lastPurge = time.Now()
+				}
+			}
+		}
+	}()
+
+	return func() {
+		close(stop)
+	}
+}
+
+func relayOutbox() {
+	for {
+		events, err := arango.FindPendingOutboxEvents(outboxBatchSize)
+		if err != nil {
+			log.Println("read outbox failed: " + err.Error())
+			return
+		}
+
+		for _, e := range events {
+			err = arango.EnqueueWebhookDeliveries(e.Event)
+			if err == nil {
+				err = nats.PublishFileEvent(e.Event)
+			}
+			if err != nil {
+				attempts := e.Attempts + 1
+				_ = arango.MarkOutboxFailed(e.Id, attempts, time.Now().Add(backoff(attempts)), err.Error())
+				continue
+			}
+
+			if err = arango.MarkOutboxDelivered(e.Id); err != nil {
+				log.Println("mark outbox delivered failed: " + err.Error())
+			}
+		}
+
+		if len(events) < outboxBatchSize {
+			return
+		}
+	}
+}
+
+func backoff(attempts int) time.Duration {
+	d := time.Second
+	for i := 1; i < attempts && d < outboxMaxBackoff; i++ {
+		d *= 2
+	}
+	if d > outboxMaxBackoff {
+		d = outboxMaxBackoff
+	}
+
+	return d
+}
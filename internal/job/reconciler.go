@@ -0,0 +1,319 @@
+package job
+
+import (
+	"github.com/Nubes3/common/models/arangodb"
+	"github.com/Nubes3/common/utils"
+	arango "github.com/Nubes3/file-service/internal/repo/arangodb"
+	"github.com/Nubes3/file-service/internal/repo/nats"
+	seaweed "github.com/Nubes3/file-service/internal/repo/seaweedfs"
+	"log"
+	"sync"
+	"time"
+)
+
+// Kinds of drift found by the reconciler.
+const (
+	// OrphanBlob is a blob no metadata refers to.
+	OrphanBlob = "orphan_blob"
+	// DanglingMetadata is live metadata whose blob is gone.
+	DanglingMetadata = "dangling_metadata"
+	// MissingFolderEntry is live metadata its folder does not list.
+	MissingFolderEntry = "missing_folder_entry"
+	// StaleFolderEntry is a file listed by a folder without live metadata.
+	StaleFolderEntry = "stale_folder_entry"
+)
+
+const (
+	reconcileBatchSize = 1000
+	// reconcileGrace is how long a file is left alone after its upload,
+	// which may still be adding it to its folder.
+	reconcileGrace = 10 * time.Minute
+)
+
+type ReconcileIssue struct {
+	Kind       string `json:"kind"`
+	MetadataId string `json:"metadata_id,omitempty"`
+	FileId     string `json:"file_id,omitempty"`
+	BucketId   string `json:"bucket_id,omitempty"`
+	Path       string `json:"path,omitempty"`
+	Name       string `json:"name,omitempty"`
+	Repaired   bool   `json:"repaired"`
+	Error      string `json:"error,omitempty"`
+}
+
+type ReconcileReport struct {
+	DryRun     bool             `json:"dry_run"`
+	BucketId   string           `json:"bucket_id,omitempty"`
+	Scanned    int              `json:"scanned"`
+	Issues     []ReconcileIssue `json:"issues"`
+	StartedAt  time.Time        `json:"started_at"`
+	FinishedAt time.Time        `json:"finished_at"`
+}
+
+// reconcileLock keeps a scheduled run and an admin run from repairing the
+// same entries at once.
+var reconcileLock sync.Mutex
+
+// Reconcile compares fileMetadata against SeaweedFS and the folder service,
+// for one bucket or all of them when bid is empty. Issues are only reported
+// unless repair is set.
+//
+// SeaweedFS cannot list the blobs of a volume over HTTP, so orphan blobs are
+// limited to those this service knows it wrote: uploads whose rollback could
+// not delete the blob.
+func Reconcile(bid string, repair bool) (*ReconcileReport, error) {
+	reconcileLock.Lock()
+	defer reconcileLock.Unlock()
+
+	report := &ReconcileReport{
+		DryRun:    !repair,
+		BucketId:  bid,
+		Issues:    []ReconcileIssue{},
+		StartedAt: time.Now(),
+	}
+
+	var group []arangodb.FileMetadata
+	for offset := 0; ; offset += reconcileBatchSize {
+		files, err := arango.FindMetadataPage(bid, offset, reconcileBatchSize)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, fm := range files {
+			if len(group) > 0 && (group[0].Path != fm.Path || group[0].BucketId != fm.BucketId) {
+				reconcileFolder(report, group, repair)
+				group = nil
+			}
+			group = append(group, fm)
+		}
+		report.Scanned += len(files)
+
+		if len(files) < reconcileBatchSize {
+			break
+		}
+	}
+	if len(group) > 0 {
+		reconcileFolder(report, group, repair)
+	}
+
+	compensations, err := arango.FindUnresolvedBlobCompensations(bid)
+	if err != nil {
+		return nil, err
+	}
+	for i := range compensations {
+		c := &compensations[i]
+		exist, err := seaweed.BlobExists(c.FileId)
+		if err != nil {
+			log.Println("check blob " + c.FileId + " failed: " + err.Error())
+			continue
+		}
+		if !exist {
+			continue
+		}
+
+		issue := ReconcileIssue{
+			Kind:     OrphanBlob,
+			FileId:   c.FileId,
+			BucketId: c.BucketId,
+			Path:     c.Path,
+			Name:     c.Name,
+		}
+		if repair {
+			applyRepair(&issue, arango.RetryCompensation(c))
+		}
+		report.Issues = append(report.Issues, issue)
+	}
+
+	report.FinishedAt = time.Now()
+	return report, nil
+}
+
+// reconcileFolder checks the files of one folder against their blobs and
+// against the children the folder service lists for it. The scan and the
+// listing are read at different times, so folder drift is only reported for
+// files past reconcileGrace and once both sides, read again, still disagree.
+func reconcileFolder(report *ReconcileReport, files []arangodb.FileMetadata, repair bool) {
+	folder, err := nats.FindFolderByFullpath(files[0].Path)
+	if err != nil {
+		log.Println("find folder " + files[0].Path + " failed: " + err.Error())
+		folder = nil
+	}
+
+	live := map[string]bool{}
+	dangling := map[string]bool{}
+	listed := map[string]bool{}
+	if folder != nil {
+		for _, child := range folder.Children {
+			if child.Type == "file" {
+				listed[child.Id] = true
+			}
+		}
+	}
+
+	for i := range files {
+		fm := &files[i]
+		if fm.IsDeleted {
+			continue
+		}
+
+		exist, err := seaweed.BlobExists(fm.FileId)
+		if err != nil {
+			log.Println("check blob " + fm.FileId + " failed: " + err.Error())
+		} else if !exist {
+			dangling[fm.Id] = true
+			issue := issueOf(DanglingMetadata, fm)
+			if repair {
+				_, err = arango.DeleteFile(fm.Id)
+				applyRepair(&issue, err)
+			}
+			report.Issues = append(report.Issues, issue)
+			continue
+		}
+
+		live[fm.Id] = true
+		if folder != nil && !listed[fm.Id] && time.Since(fm.UploadedDate) > reconcileGrace && stillUnlisted(fm) {
+			issue := issueOf(MissingFolderEntry, fm)
+			if repair {
+				_, err = nats.InsertFile(fm.Id, fm.Name, folder.Id, fm.IsHidden)
+				applyRepair(&issue, err)
+			}
+			report.Issues = append(report.Issues, issue)
+		}
+	}
+
+	if folder == nil {
+		return
+	}
+	for _, child := range folder.Children {
+		// Dangling files were reported, and removed from the folder by their
+		// repair, already.
+		if child.Type != "file" || live[child.Id] || dangling[child.Id] || !stillStale(folder.Fullpath, child.Id) {
+			continue
+		}
+
+		issue := ReconcileIssue{
+			Kind:       StaleFolderEntry,
+			MetadataId: child.Id,
+			BucketId:   files[0].BucketId,
+			Path:       folder.Fullpath,
+			Name:       child.Name,
+		}
+		if repair {
+			_, err = nats.RemoveFile(folder.Fullpath, child.Id)
+			applyRepair(&issue, err)
+		}
+		report.Issues = append(report.Issues, issue)
+	}
+}
+
+// stillUnlisted reads fm and its folder again and reports whether the folder
+// still lacks the live file, as both may have changed since the scan.
+func stillUnlisted(fm *arangodb.FileMetadata) bool {
+	current, err := arango.FindMetadataById(fm.Id)
+	if err != nil || current.Path != fm.Path {
+		return false
+	}
+
+	folder, err := nats.FindFolderByFullpath(fm.Path)
+	if err != nil {
+		return false
+	}
+	for _, child := range folder.Children {
+		if child.Id == fm.Id {
+			return false
+		}
+	}
+
+	return true
+}
+
+// stillStale reads the file id and the folder at path again and reports
+// whether the folder still lists it without live metadata there, as an
+// upload may have created both since the scan.
+func stillStale(path string, id string) bool {
+	current, err := arango.FindMetadataById(id)
+	if err == nil {
+		if current.Path == path {
+			return false
+		}
+	} else if e, ok := err.(*utils.ModelError); !ok || e.ErrType != utils.NotFound {
+		return false
+	}
+
+	folder, err := nats.FindFolderByFullpath(path)
+	if err != nil {
+		return false
+	}
+	for _, child := range folder.Children {
+		if child.Id == id {
+			return true
+		}
+	}
+
+	return false
+}
+
+func issueOf(kind string, fm *arangodb.FileMetadata) ReconcileIssue {
+	return ReconcileIssue{
+		Kind:       kind,
+		MetadataId: fm.Id,
+		FileId:     fm.FileId,
+		BucketId:   fm.BucketId,
+		Path:       fm.Path,
+		Name:       fm.Name,
+	}
+}
+
+func applyRepair(issue *ReconcileIssue, err error) {
+	if err != nil {
+		issue.Error = err.Error()
+		return
+	}
+	issue.Repaired = true
+}
+
+// StartReconcileRun runs Reconcile in the background and records its
+// report in a reconcile run, whose id it returns right away.
+func StartReconcileRun(bid string, repair bool) (*arango.ReconcileRun, error) {
+	run, err := arango.CreateReconcileRun(bid, repair)
+	if err != nil {
+		return nil, err
+	}
+
+	go func() {
+		report, err := Reconcile(bid, repair)
+		if err := arango.FinishReconcileRun(run.Id, report, err); err != nil {
+			log.Println("store reconcile run " + run.Id + " failed: " + err.Error())
+		}
+	}()
+
+	return run, nil
+}
+
+// StartReconciler periodically reconciles every bucket, repairing drift only
+// when repair is set. Call the returned function to stop it.
+func StartReconciler(interval time.Duration, repair bool) func() {
+	stop := make(chan struct{})
+	ticker := time.NewTicker(interval)
+
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				report, err := Reconcile("", repair)
+				if err != nil {
+					log.Println("reconcile failed: " + err.Error())
+					continue
+				}
+				log.Printf("reconciled %d files, %d issues\n", report.Scanned, len(report.Issues))
+			}
+		}
+	}()
+
+	return func() {
+		close(stop)
+	}
+}
@@ -0,0 +1,64 @@
+package job
+
+import (
+	"encoding/json"
+	"github.com/Nubes3/file-service/internal/config"
+	arango "github.com/Nubes3/file-service/internal/repo/arangodb"
+	"github.com/Nubes3/file-service/internal/webhook"
+	"log"
+	"net/http"
+	"time"
+)
+
+const webhookBatchSize = 100
+
+// StartWebhookDispatcher periodically sends pending webhook deliveries.
+// Failed deliveries are retried with exponential backoff and moved to the
+// dead letter status once they ran out of attempts. Call the returned
+// function to stop it.
+func StartWebhookDispatcher(interval time.Duration) func() {
+	stop := make(chan struct{})
+	ticker := time.NewTicker(interval)
+	client := webhook.NewClient(time.Duration(config.Conf.WebhookTimeout) * time.Second)
+
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				dispatchWebhooks(client)
+			}
+		}
+	}()
+
+	return func() {
+		close(stop)
+	}
+}
+
+func dispatchWebhooks(client *http.Client) {
+	deliveries, err := arango.FindPendingWebhookDeliveries(webhookBatchSize)
+	if err != nil {
+		log.Println("read webhook deliveries failed: " + err.Error())
+		return
+	}
+
+	for _, d := range deliveries {
+		attempts := d.Attempts + 1
+		if d.Url == "" {
+			_ = arango.UpdateWebhookDelivery(d.Id, arango.DeliveryDead, attempts, time.Now(), 0,
+				"webhook removed")
+			continue
+		}
+
+		body, _ := json.Marshal(d.Event)
+		o := webhook.Attempt(client, d.Url, d.Secret, d.Id, string(d.Event.Type), body,
+			attempts, config.Conf.WebhookAttempts, backoff)
+		err := arango.UpdateWebhookDelivery(d.Id, o.Status, attempts, o.NextAttempt, o.Code, o.Reason)
+		if err != nil {
+			log.Println("update webhook delivery failed: " + err.Error())
+		}
+	}
+}
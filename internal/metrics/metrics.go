@@ -0,0 +1,137 @@
+// Package metrics holds the Prometheus collectors of the file service:
+// requests, transfers, and the latency of the stores it calls.
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"io"
+	"sync"
+	"time"
+)
+
+// Directions of a transfer.
+const (
+	Upload   = "upload"
+	Download = "download"
+)
+
+const namespace = "nubes3_file"
+
+var (
+	httpRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "http_requests_total",
+		Help:      "HTTP requests by route group, method and status.",
+	}, []string{"group", "method", "status"})
+
+	httpDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "HTTP request latency by route group and status.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"group", "status"})
+
+	transferBytes = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "transfer_bytes_total",
+		Help:      "File content bytes uploaded and downloaded per bucket.",
+	}, []string{"direction", "bucket_id"})
+
+	transfersInFlight = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "transfers_in_flight",
+		Help:      "Uploads and downloads currently streaming.",
+	}, []string{"direction"})
+
+	storageDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "seaweedfs_call_duration_seconds",
+		Help:      "SeaweedFS call latency by operation.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"operation", "error"})
+
+	dbDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "arangodb_call_duration_seconds",
+		Help:      "ArangoDB call latency by operation.",
+		Buckets:   []float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5},
+	}, []string{"operation", "error"})
+
+	natsTimeouts = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "nats_request_timeouts_total",
+		Help:      "NATS requests to other services that got no reply, by subject.",
+	}, []string{"subject"})
+)
+
+// ObserveRequest records a finished HTTP request.
+func ObserveRequest(group string, method string, status string, start time.Time) {
+	httpRequests.WithLabelValues(group, method, status).Inc()
+	httpDuration.WithLabelValues(group, status).Observe(time.Since(start).Seconds())
+}
+
+// ObserveStorage records a SeaweedFS call started at start; use it as
+// defer metrics.ObserveStorage("op", time.Now(), &err).
+func ObserveStorage(operation string, start time.Time, err *error) {
+	storageDuration.WithLabelValues(operation, failed(err)).Observe(time.Since(start).Seconds())
+}
+
+// ObserveDb is ObserveStorage for ArangoDB calls.
+func ObserveDb(operation string, start time.Time, err *error) {
+	dbDuration.WithLabelValues(operation, failed(err)).Observe(time.Since(start).Seconds())
+}
+
+func failed(err *error) string {
+	if err != nil && *err != nil {
+		return "true"
+	}
+	return "false"
+}
+
+// NatsTimeout counts a request on subject that got no reply.
+func NatsTimeout(subject string) {
+	natsTimeouts.WithLabelValues(subject).Inc()
+}
+
+// Transfer counts the bytes read through it as transferred in direction for
+// a bucket, and holds the in-flight gauge until Done or Close.
+type Transfer struct {
+	reader    io.Reader
+	direction string
+	bytes     prometheus.Counter
+	done      sync.Once
+}
+
+// Track starts a transfer of the content read from reader.
+func Track(direction string, bid string, reader io.Reader) *Transfer {
+	transfersInFlight.WithLabelValues(direction).Inc()
+
+	return &Transfer{
+		reader:    reader,
+		direction: direction,
+		bytes:     transferBytes.WithLabelValues(direction, bid),
+	}
+}
+
+func (t *Transfer) Read(p []byte) (int, error) {
+	n, err := t.reader.Read(p)
+	t.bytes.Add(float64(n))
+	return n, err
+}
+
+// Done ends the transfer; calling it again does nothing.
+func (t *Transfer) Done() {
+	t.done.Do(func() {
+		transfersInFlight.WithLabelValues(t.direction).Dec()
+	})
+}
+
+// Close ends the transfer and closes the reader when it is a Closer.
+func (t *Transfer) Close() error {
+	t.Done()
+	if c, ok := t.reader.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
@@ -0,0 +1,38 @@
+package policy
+
+import (
+	"github.com/Nubes3/file-service/internal/api/apierror"
+	"github.com/gin-gonic/gin"
+	"net/http"
+)
+
+const contextKey = "policy"
+
+// Set stores the caller's policy on the context; the authentication
+// middlewares call it through the principal they produce.
+func Set(c *gin.Context, p *Policy) {
+	c.Set(contextKey, p)
+}
+
+// FromContext returns the policy stored by Set, or one allowing nothing.
+func FromContext(c *gin.Context) *Policy {
+	if p, ok := c.Get(contextKey); ok {
+		return p.(*Policy)
+	}
+
+	return &Policy{}
+}
+
+// RequirePermission rejects the request unless the caller holds every one
+// of perms.
+func RequirePermission(perms ...Permission) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		p := FromContext(c)
+		for _, perm := range perms {
+			if !p.Has(perm) {
+				apierror.Abort(c, http.StatusForbidden, "missing permission "+perm.String())
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package policy
+
+import (
+	"github.com/Nubes3/common/models/arangodb"
+	"github.com/Nubes3/common/utils"
+)
+
+// Permission is the typed form of the permission strings stored on access
+// keys and key pairs.
+type Permission = arangodb.Permission
+
+const (
+	GetFileList       = arangodb.GetFileList
+	GetFileListHidden = arangodb.GetFileListHidden
+	Download          = arangodb.Download
+	DownloadHidden    = arangodb.DownloadHidden
+	Upload            = arangodb.Upload
+	MarkHidden        = arangodb.MarkHidden
+	DeleteFile        = arangodb.DeleteFile
+	RecoverFile       = arangodb.RecoverFile
+)
+
+var all = []Permission{
+	GetFileList,
+	GetFileListHidden,
+	Download,
+	DownloadHidden,
+	Upload,
+	MarkHidden,
+	DeleteFile,
+	RecoverFile,
+}
+
+func Parse(s string) (Permission, error) {
+	for _, perm := range all {
+		if perm.String() == s {
+			return perm, nil
+		}
+	}
+
+	return -1, &utils.ModelError{
+		Msg:     "invalid permission: " + s,
+		ErrType: utils.Invalid,
+	}
+}
+
+// set is a bit set of permissions.
+type set uint32
+
+func (s set) has(perm Permission) bool {
+	return s&(1<<uint(perm)) != 0
+}
+
+func setOf(perms []string) set {
+	var s set
+	for _, raw := range perms {
+		perm, err := Parse(raw)
+		if err != nil {
+			// Unknown permissions come from newer services; ignore them
+			// rather than reject the key.
+			continue
+		}
+		s |= 1 << uint(perm)
+	}
+
+	return s
+}
@@ -0,0 +1,101 @@
+// Package policy decides what the caller of a request may do: which
+// permissions it holds and which paths of the bucket it may touch.
+package policy
+
+import (
+	"github.com/Nubes3/common/models/arangodb"
+	"strings"
+)
+
+type Policy struct {
+	perms  set
+	scopes []Scope
+}
+
+// Owner is the policy of a bucket owner: every permission, every path.
+func Owner() *Policy {
+	var perms set
+	for _, perm := range all {
+		perms |= 1 << uint(perm)
+	}
+
+	return &Policy{perms: perms}
+}
+
+// ForKey builds the policy of an access key or key pair from its permission
+// strings and path scope patterns.
+func ForKey(perms []string, scopes []string) (*Policy, error) {
+	p := &Policy{perms: setOf(perms)}
+	for _, pattern := range scopes {
+		scope, err := ParseScope(pattern)
+		if err != nil {
+			return nil, err
+		}
+		p.scopes = append(p.scopes, scope)
+	}
+
+	return p, nil
+}
+
+func (p *Policy) Has(perm Permission) bool {
+	return p.perms.has(perm)
+}
+
+// Scoped reports whether the policy is limited to some paths.
+func (p *Policy) Scoped() bool {
+	return len(p.scopes) > 0
+}
+
+func (p *Policy) AllowsPath(fullpath string) bool {
+	if !p.Scoped() {
+		return true
+	}
+
+	for _, scope := range p.scopes {
+		if scope.Match(fullpath) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// PathPattern is a regular expression matching the full paths inside the
+// policy's scopes, for filtering in the database before paging. It is empty
+// when the policy is not scoped.
+func (p *Policy) PathPattern() string {
+	if !p.Scoped() {
+		return ""
+	}
+
+	patterns := make([]string, 0, len(p.scopes))
+	for _, scope := range p.scopes {
+		patterns = append(patterns, scope.regex())
+	}
+
+	return "^(" + strings.Join(patterns, "|") + ")$"
+}
+
+func (p *Policy) AllowsFile(fm *arangodb.FileMetadata) bool {
+	return p.AllowsPath(FullPath(fm.Path, fm.Name))
+}
+
+// Filter keeps the files inside the policy's scopes.
+func (p *Policy) Filter(files []arangodb.FileMetadata) []arangodb.FileMetadata {
+	if !p.Scoped() {
+		return files
+	}
+
+	res := []arangodb.FileMetadata{}
+	for i := range files {
+		if p.AllowsFile(&files[i]) {
+			res = append(res, files[i])
+		}
+	}
+
+	return res
+}
+
+func FullPath(path string, name string) string {
+	return strings.TrimSuffix(path, "/") + "/" + name
+}
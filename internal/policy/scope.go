@@ -0,0 +1,91 @@
+package policy
+
+import (
+	"github.com/Nubes3/common/utils"
+	"regexp"
+	"strings"
+)
+
+// Scope limits a key to the files whose full path matches a pattern. A
+// pattern is an absolute path whose segments are matched literally, except
+// "*" which matches one segment and a trailing "**" which matches any number
+// of them, e.g. /bucket/public/** or /bucket/*/reports/**.
+type Scope struct {
+	pattern  string
+	segments []string
+}
+
+func ParseScope(pattern string) (Scope, error) {
+	if !strings.HasPrefix(pattern, "/") {
+		return Scope{}, &utils.ModelError{
+			Msg:     "scope must be an absolute path: " + pattern,
+			ErrType: utils.Invalid,
+		}
+	}
+
+	segments := splitPath(pattern)
+	for i, segment := range segments {
+		if segment == "**" && i != len(segments)-1 {
+			return Scope{}, &utils.ModelError{
+				Msg:     "** is only allowed at the end of a scope: " + pattern,
+				ErrType: utils.Invalid,
+			}
+		}
+	}
+
+	return Scope{
+		pattern:  pattern,
+		segments: segments,
+	}, nil
+}
+
+func (s Scope) String() string {
+	return s.pattern
+}
+
+// Match reports whether the file at fullpath is inside the scope.
+func (s Scope) Match(fullpath string) bool {
+	return matchSegments(s.segments, splitPath(fullpath))
+}
+
+// regex is the regular expression matching the same full paths as Match, in
+// the syntax both Go and ArangoDB's REGEX_TEST accept.
+func (s Scope) regex() string {
+	var sb strings.Builder
+	for _, segment := range s.segments {
+		switch segment {
+		case "**":
+			sb.WriteString("(/.*)?")
+		case "*":
+			sb.WriteString("/[^/]+")
+		default:
+			sb.WriteString("/" + regexp.QuoteMeta(segment))
+		}
+	}
+
+	return sb.String()
+}
+
+func matchSegments(pattern []string, path []string) bool {
+	for i, segment := range pattern {
+		if segment == "**" {
+			return true
+		}
+		if i == len(path) || (segment != "*" && segment != path[i]) {
+			return false
+		}
+	}
+
+	return len(pattern) == len(path)
+}
+
+func splitPath(path string) []string {
+	var segments []string
+	for _, segment := range strings.Split(path, "/") {
+		if segment != "" {
+			segments = append(segments, segment)
+		}
+	}
+
+	return segments
+}
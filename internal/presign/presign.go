@@ -0,0 +1,174 @@
+// Package presign mints and checks the signatures of presigned URLs. A URL
+// carries everything needed to serve it, so it works without a session and
+// stays valid until it expires or the signing secret is rotated.
+package presign
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"github.com/Nubes3/common/utils"
+	"github.com/Nubes3/file-service/internal/config"
+	"mime"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	downloadPurpose = "download"
+	uploadPurpose   = "upload"
+)
+
+// UploadPolicy fixes what a presigned upload may store. An empty Name lets
+// the uploader choose the file name; an empty ContentTypes accepts any type.
+type UploadPolicy struct {
+	BucketId     string   `json:"bucket_id"`
+	Path         string   `json:"path"`
+	Name         string   `json:"name,omitempty"`
+	MaxSize      int64    `json:"max_size"`
+	ContentTypes []string `json:"content_types,omitempty"`
+	Expires      int64    `json:"expires"`
+}
+
+// Enabled reports whether a signing secret is configured.
+func Enabled() bool {
+	return config.Conf.PresignSecret != ""
+}
+
+// Expiry turns a requested ttl in seconds into an expiry time, using the
+// default for zero and capping it to the configured maximum.
+func Expiry(ttl int64) (time.Time, error) {
+	if ttl < 0 {
+		return time.Time{}, &utils.ModelError{
+			Msg:     "invalid ttl",
+			ErrType: utils.Invalid,
+		}
+	}
+	if ttl == 0 {
+		ttl = config.Conf.PresignDefaultTtl
+	}
+	if ttl > config.Conf.PresignMaxTtl {
+		ttl = config.Conf.PresignMaxTtl
+	}
+
+	return time.Now().Add(time.Duration(ttl) * time.Second), nil
+}
+
+// SignDownload returns the signature of a download URL for the file with
+// metadata id fileId, valid until expires.
+func SignDownload(fileId string, expires int64) string {
+	return sign(downloadPurpose, fileId, strconv.FormatInt(expires, 10))
+}
+
+// VerifyDownload checks the signature and expiry of a download URL.
+func VerifyDownload(fileId string, expires int64, signature string) error {
+	if err := checkSignature(SignDownload(fileId, expires), signature); err != nil {
+		return err
+	}
+
+	return checkExpiry(expires)
+}
+
+// SignUpload encodes policy and returns it with its signature, the two
+// values a presigned upload URL carries.
+func SignUpload(policy *UploadPolicy) (string, string, error) {
+	data, err := json.Marshal(policy)
+	if err != nil {
+		return "", "", &utils.ModelError{
+			Msg:     err.Error(),
+			ErrType: utils.Other,
+		}
+	}
+
+	encoded := base64.RawURLEncoding.EncodeToString(data)
+	return encoded, sign(uploadPurpose, encoded), nil
+}
+
+// VerifyUpload checks the signature of an encoded policy and returns the
+// policy if it has not expired.
+func VerifyUpload(encoded string, signature string) (*UploadPolicy, error) {
+	if err := checkSignature(sign(uploadPurpose, encoded), signature); err != nil {
+		return nil, err
+	}
+
+	var policy UploadPolicy
+	data, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err == nil {
+		err = json.Unmarshal(data, &policy)
+	}
+	if err != nil {
+		return nil, &utils.ModelError{
+			Msg:     "invalid policy",
+			ErrType: utils.Invalid,
+		}
+	}
+
+	if err := checkExpiry(policy.Expires); err != nil {
+		return nil, err
+	}
+
+	return &policy, nil
+}
+
+// AllowsContentType reports whether a file of contentType may be uploaded.
+// Allowed types are media types, optionally with a "*" subtype such as
+// "image/*"; parameters like the charset are ignored.
+func (p *UploadPolicy) AllowsContentType(contentType string) bool {
+	if len(p.ContentTypes) == 0 {
+		return true
+	}
+
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+
+	for _, allowed := range p.ContentTypes {
+		allowed = strings.ToLower(strings.TrimSpace(allowed))
+		if allowed == mediaType ||
+			(strings.HasSuffix(allowed, "/*") && strings.HasPrefix(mediaType, strings.TrimSuffix(allowed, "*"))) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// sign is the hex HMAC-SHA256 of the newline joined fields, keyed with the
+// signing secret. The purpose comes first so a signature for one kind of URL
+// is never valid for another.
+func sign(purpose string, fields ...string) string {
+	mac := hmac.New(sha256.New, []byte(config.Conf.PresignSecret))
+	mac.Write([]byte(purpose))
+	for _, field := range fields {
+		mac.Write([]byte("\n"))
+		mac.Write([]byte(field))
+	}
+
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func checkSignature(expected string, signature string) error {
+	if !Enabled() || !hmac.Equal([]byte(expected), []byte(signature)) {
+		return &utils.ModelError{
+			Msg:     "invalid signature",
+			ErrType: utils.Invalid,
+		}
+	}
+
+	return nil
+}
+
+func checkExpiry(expires int64) error {
+	if time.Now().Unix() > expires {
+		return &utils.ModelError{
+			Msg:     "url expired",
+			ErrType: utils.Expired,
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,139 @@
+// Package principal describes who is calling: a user, an access key or a key
+// pair. The authentication middleware of each route group produces one, so
+// the file handlers never look at how the caller authenticated.
+package principal
+
+import (
+	"context"
+	"github.com/Nubes3/common/models/arangodb"
+	"github.com/Nubes3/common/utils"
+	"github.com/Nubes3/file-service/internal/policy"
+	arango "github.com/Nubes3/file-service/internal/repo/arangodb"
+	"github.com/Nubes3/file-service/internal/repo/nats"
+	"github.com/gin-gonic/gin"
+)
+
+type Kind string
+
+const (
+	User      Kind = "user"
+	AccessKey Kind = "accessKey"
+	KeyPair   Kind = "keyPair"
+)
+
+const contextKey = "principal"
+
+type ctxKey struct{}
+
+type Principal struct {
+	Kind Kind
+	// Id is the user id, the access key or the public key of the key pair.
+	Id string
+	// BucketId is the only bucket a key can reach, empty for users.
+	BucketId string
+	Policy   *policy.Policy
+}
+
+func ForUser(uid string) *Principal {
+	return &Principal{
+		Kind:   User,
+		Id:     uid,
+		Policy: policy.Owner(),
+	}
+}
+
+func ForAccessKey(key *arangodb.AccessKey) (*Principal, error) {
+	return forKey(AccessKey, key.Key, key.BucketId, key.Permissions, arango.AccessKeyScope)
+}
+
+func ForKeyPair(key *arangodb.KeyPair) (*Principal, error) {
+	return forKey(KeyPair, key.Public, key.BucketId, key.Permissions, arango.KeyPairScope)
+}
+
+func forKey(kind Kind, id string, bid string, perms []string, scopeKind string) (*Principal, error) {
+	scopes, err := arango.FindKeyScopes(scopeKind, id, bid)
+	if err != nil {
+		return nil, err
+	}
+
+	p, err := policy.ForKey(perms, scopes)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Principal{
+		Kind:     kind,
+		Id:       id,
+		BucketId: bid,
+		Policy:   p,
+	}, nil
+}
+
+// String identifies the principal, e.g. to scope idempotency keys.
+func (p *Principal) String() string {
+	return string(p.Kind) + ":" + p.Id
+}
+
+// Bucket loads the bucket a request is about and checks the principal may
+// use it. Keys are bound to their bucket, so bid may be left empty for them;
+// users must name a bucket they own.
+func (p *Principal) Bucket(bid string) (*arangodb.Bucket, error) {
+	if p.Kind != User {
+		if bid != "" && bid != p.BucketId {
+			return nil, &utils.ModelError{
+				Msg:     "bucket not found",
+				ErrType: utils.NotFound,
+			}
+		}
+		bid = p.BucketId
+	}
+
+	if bid == "" {
+		return nil, &utils.ModelError{
+			Msg:     "missing bucket id",
+			ErrType: utils.Invalid,
+		}
+	}
+
+	bucket, err := nats.FindBucketById(bid)
+	if err != nil {
+		return nil, err
+	}
+
+	if p.Kind == User && bucket.Uid != p.Id {
+		return nil, &utils.ModelError{
+			Msg:     "bucket not found",
+			ErrType: utils.NotFound,
+		}
+	}
+
+	return bucket, nil
+}
+
+// Set stores p, and its policy, on the request context.
+func Set(c *gin.Context, p *Principal) {
+	c.Set(contextKey, p)
+	policy.Set(c, p.Policy)
+}
+
+// From returns the principal stored by the group's authentication
+// middleware.
+func From(c *gin.Context) (*Principal, bool) {
+	if p, ok := c.Get(contextKey); ok {
+		return p.(*Principal), true
+	}
+
+	return nil, false
+}
+
+// NewContext returns a copy of ctx carrying p, for the gRPC interceptors.
+func NewContext(ctx context.Context, p *Principal) context.Context {
+	return context.WithValue(ctx, ctxKey{}, p)
+}
+
+// FromContext returns the principal stored by NewContext.
+func FromContext(ctx context.Context) (*Principal, bool) {
+	p, ok := ctx.Value(ctxKey{}).(*Principal)
+
+	return p, ok
+}
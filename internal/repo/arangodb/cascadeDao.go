@@ -0,0 +1,336 @@
+package arango
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"github.com/Nubes3/common/models/arangodb"
+	"github.com/Nubes3/common/utils"
+	"github.com/Nubes3/file-service/internal/repo/nats"
+	"github.com/arangodb/go-driver"
+	"time"
+)
+
+// Scopes of a cascade: everything a deleted bucket held, or everything
+// under a deleted folder.
+const (
+	CascadeBucket = "bucket"
+	CascadeFolder = "folder"
+)
+
+// Modes of a cascade. Soft deleted files keep their blob and can still be
+// recovered; purged files lose both metadata and blob.
+const (
+	CascadeSoftDelete = "soft_delete"
+	CascadePurge      = "purge"
+)
+
+type Cascade struct {
+	Id         string    `json:"id"`
+	Scope      string    `json:"scope"`
+	BucketId   string    `json:"bucket_id,omitempty"`
+	Path       string    `json:"path,omitempty"`
+	Mode       string    `json:"mode"`
+	Processed  int       `json:"processed"`
+	Done       bool      `json:"done"`
+	CreatedAt  time.Time `json:"created_at"`
+	UpdatedAt  time.Time `json:"updated_at"`
+	FinishedAt time.Time `json:"finished_at"`
+
+	// leaseToken identifies the claim this instance holds the cascade by.
+	leaseToken string
+}
+
+type cascadeDoc struct {
+	Scope      string    `json:"scope"`
+	BucketId   string    `json:"bucket_id"`
+	Path       string    `json:"path"`
+	Mode       string    `json:"mode"`
+	Processed  int       `json:"processed"`
+	Done       bool      `json:"done"`
+	LeaseUntil int64     `json:"lease_until"`
+	LeaseToken string    `json:"lease_token"`
+	CreatedAt  time.Time `json:"created_at"`
+	UpdatedAt  time.Time `json:"updated_at"`
+	FinishedAt time.Time `json:"finished_at"`
+}
+
+func toCascade(key string, doc *cascadeDoc) Cascade {
+	return Cascade{
+		Id:         key,
+		Scope:      doc.Scope,
+		BucketId:   doc.BucketId,
+		Path:       doc.Path,
+		Mode:       doc.Mode,
+		Processed:  doc.Processed,
+		Done:       doc.Done,
+		CreatedAt:  doc.CreatedAt,
+		UpdatedAt:  doc.UpdatedAt,
+		FinishedAt: doc.FinishedAt,
+		leaseToken: doc.LeaseToken,
+	}
+}
+
+// CreateCascade records that the files of a deleted bucket or folder must
+// be deleted. The record is the only state of the cascade, so it resumes
+// from there after a restart. Both scopes need the bucket id, folders their
+// path too.
+func CreateCascade(scope string, bid string, path string, mode string) (*Cascade, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*arangodb.ContextExpiredTime)
+	defer cancel()
+
+	if bid == "" || (scope == CascadeFolder && path == "") ||
+		(mode != CascadeSoftDelete && mode != CascadePurge) {
+		return nil, &utils.ModelError{
+			Msg:     "invalid cascade",
+			ErrType: utils.Invalid,
+		}
+	}
+
+	doc := cascadeDoc{
+		Scope:     scope,
+		BucketId:  bid,
+		Path:      path,
+		Mode:      mode,
+		CreatedAt: time.Now(),
+		UpdatedAt: time.Now(),
+	}
+	meta, err := cascadeCol.CreateDocument(ctx, doc)
+	if err != nil {
+		return nil, &utils.ModelError{
+			Msg:     err.Error(),
+			ErrType: utils.DbError,
+		}
+	}
+
+	c := toCascade(meta.Key, &doc)
+	return &c, nil
+}
+
+// ClaimCascades leases up to limit unfinished cascades to the caller until
+// lease has passed, so instances do not work on the same one and a cascade
+// left by a crashed instance is picked up again. Every claim gets a new
+// lease token, which RunCascadeBatch checks.
+func ClaimCascades(limit int, lease time.Duration) ([]Cascade, error) {
+	query := "FOR c IN cascades FILTER c.done == false AND c.lease_until < @now " +
+		"SORT c.created_at LIMIT @limit " +
+		"UPDATE c WITH { lease_until: @lease, lease_token: @token } IN cascades RETURN NEW"
+
+	token := make([]byte, 16)
+	_, _ = rand.Read(token)
+
+	return findCascades(query, map[string]interface{}{
+		"now":   time.Now().UnixNano() / int64(time.Millisecond),
+		"lease": time.Now().Add(lease).UnixNano() / int64(time.Millisecond),
+		"token": hex.EncodeToString(token),
+		"limit": limit,
+	})
+}
+
+// FindCascades lists the most recent cascades, unfinished ones first.
+func FindCascades(limit int) ([]Cascade, error) {
+	query := "FOR c IN cascades SORT c.done, c.created_at DESC LIMIT @limit RETURN c"
+
+	return findCascades(query, map[string]interface{}{
+		"limit": limit,
+	})
+}
+
+func findCascades(query string, bindVars map[string]interface{}) ([]Cascade, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*arangodb.ContextExpiredTime)
+	defer cancel()
+
+	cursor, err := db.Query(ctx, query, bindVars)
+	if err != nil {
+		return nil, &utils.ModelError{
+			Msg:     err.Error(),
+			ErrType: utils.DbError,
+		}
+	}
+	defer cursor.Close()
+
+	cascades := []Cascade{}
+	for {
+		var doc cascadeDoc
+		meta, err := cursor.ReadDocument(ctx, &doc)
+		if driver.IsNoMoreDocuments(err) {
+			break
+		} else if err != nil {
+			return nil, &utils.ModelError{
+				Msg:     err.Error(),
+				ErrType: utils.DbError,
+			}
+		}
+		cascades = append(cascades, toCascade(meta.Key, &doc))
+	}
+
+	return cascades, nil
+}
+
+// cascadeProgressQuery counts a batch in the progress of a cascade and
+// renews its lease, provided the claim still holds it.
+const cascadeProgressQuery = "FOR c IN cascades FILTER c._key == @id AND c.lease_token == @token " +
+	"UPDATE c WITH { processed: c.processed + @count, updated_at: @now, lease_until: @lease, " +
+	"done: @finished, finished_at: @finished ? @now : c.finished_at } IN cascades RETURN NEW"
+
+// RunCascadeBatch deletes up to limit files of the cascade and counts them
+// in its progress within the same transaction, so a batch is either fully
+// done and counted or not at all. It returns how many files it deleted;
+// fewer than limit means the cascade is finished.
+//
+// Only files uploaded before the cascade was created are deleted, so files
+// of a folder recreated under the same path survive a late cascade.
+//
+// Purged blobs are recorded as delete_blob compensations in the
+// transaction too, then deleted; the compensation repair job retries the
+// ones that fail.
+//
+// The batch is rolled back with an Expired error when the lease of the
+// caller's claim was lost, as another instance then runs the cascade.
+func RunCascadeBatch(c *Cascade, limit int, lease time.Duration) (int, error) {
+	query := "FOR fm IN fileMetadata " +
+		"FILTER fm.bucket_id == @bid " +
+		"AND (@path == '' OR fm.path == @path OR STARTS_WITH(fm.path, CONCAT(@path, '/'))) " +
+		"AND DATE_TIMESTAMP(fm.upload_date) <= @before "
+	if c.Mode == CascadeSoftDelete {
+		query += "AND fm.is_deleted == false LIMIT @limit " +
+			"UPDATE fm WITH { is_deleted: true, deleted_date: @now } IN fileMetadata RETURN MERGE(NEW, { was_deleted: false })"
+	} else {
+		query += "LIMIT @limit REMOVE fm IN fileMetadata RETURN MERGE(OLD, { was_deleted: OLD.is_deleted })"
+	}
+	bindVars := map[string]interface{}{
+		"bid":    c.BucketId,
+		"path":   c.Path,
+		"limit":  limit,
+		"before": c.CreatedAt.UnixNano() / int64(time.Millisecond),
+	}
+	if c.Mode == CascadeSoftDelete {
+		bindVars["now"] = time.Now()
+	}
+
+	var blobs []FailedCompensation
+	var updated cascadeDoc
+	count := 0
+	err := withTransaction([]string{fileMetadataColName, outboxColName, compensationColName, cascadeColName},
+		func(ctx context.Context) error {
+			cursor, err := db.Query(ctx, query, bindVars)
+			if err != nil {
+				return &utils.ModelError{
+					Msg:     err.Error(),
+					ErrType: utils.DbError,
+				}
+			}
+			defer cursor.Close()
+
+			for {
+				var doc struct {
+					arangodb.FileMetadataRes
+					Key        string `json:"_key"`
+					WasDeleted bool   `json:"was_deleted"`
+				}
+				_, err := cursor.ReadDocument(ctx, &doc)
+				if driver.IsNoMoreDocuments(err) {
+					break
+				} else if err != nil {
+					return &utils.ModelError{
+						Msg:     err.Error(),
+						ErrType: utils.DbError,
+					}
+				}
+				count++
+
+				fm := toFileMetadata(doc.Key, &doc.FileMetadataRes)
+				fm.IsDeleted = true
+				if !doc.WasDeleted {
+					err = insertOutboxEvent(ctx, nats.NewFileEvent(nats.DeletedEvent, nats.FileEventDataOf(&fm)))
+					if err != nil {
+						return err
+					}
+				}
+
+				if c.Mode == CascadePurge {
+					blob, err := insertBlobCompensation(ctx, &fm, "cascade "+c.Id)
+					if err != nil {
+						return err
+					}
+					blobs = append(blobs, *blob)
+				}
+			}
+
+			progress, err := db.Query(ctx, cascadeProgressQuery, map[string]interface{}{
+				"id":       c.Id,
+				"token":    c.leaseToken,
+				"count":    count,
+				"finished": count < limit,
+				"now":      time.Now(),
+				"lease":    time.Now().Add(lease).UnixNano() / int64(time.Millisecond),
+			})
+			if err != nil {
+				return &utils.ModelError{
+					Msg:     err.Error(),
+					ErrType: utils.DbError,
+				}
+			}
+			defer progress.Close()
+
+			_, err = progress.ReadDocument(ctx, &updated)
+			if driver.IsNoMoreDocuments(err) {
+				return &utils.ModelError{
+					Msg:     "cascade lease lost",
+					ErrType: utils.Expired,
+				}
+			} else if err != nil {
+				return &utils.ModelError{
+					Msg:     err.Error(),
+					ErrType: utils.DbError,
+				}
+			}
+
+			return nil
+		})
+	if err != nil {
+		return 0, err
+	}
+	c.Processed = updated.Processed
+	c.Done = updated.Done
+
+	for i := range blobs {
+		_ = RetryCompensation(&blobs[i])
+	}
+
+	return count, nil
+}
+
+// insertBlobCompensation records that the blob of fm still has to be
+// deleted.
+func insertBlobCompensation(ctx context.Context, fm *arangodb.FileMetadata, cause string) (*FailedCompensation, error) {
+	doc := compensationDoc{
+		Step:       CompensateDeleteBlob,
+		FileId:     fm.FileId,
+		MetadataId: fm.Id,
+		BucketId:   fm.BucketId,
+		Path:       fm.Path,
+		Name:       fm.Name,
+		Cause:      cause,
+		CreatedAt:  time.Now(),
+	}
+	meta, err := compensationCol.CreateDocument(ctx, doc)
+	if err != nil {
+		return nil, &utils.ModelError{
+			Msg:     err.Error(),
+			ErrType: utils.DbError,
+		}
+	}
+
+	return &FailedCompensation{
+		Id:         meta.Key,
+		Step:       doc.Step,
+		FileId:     doc.FileId,
+		MetadataId: doc.MetadataId,
+		BucketId:   doc.BucketId,
+		Path:       doc.Path,
+		Name:       doc.Name,
+		Cause:      doc.Cause,
+		CreatedAt:  doc.CreatedAt,
+	}, nil
+}
@@ -0,0 +1,238 @@
+package arango
+
+import (
+	"context"
+	"github.com/Nubes3/common/models/arangodb"
+	"github.com/Nubes3/common/utils"
+	"github.com/Nubes3/file-service/internal/repo/nats"
+	seaweed "github.com/Nubes3/file-service/internal/repo/seaweedfs"
+	"github.com/arangodb/go-driver"
+	"time"
+)
+
+// Compensation steps of the upload saga.
+const (
+	CompensateDeleteBlob        = "delete_blob"
+	CompensateDeleteMetadata    = "delete_metadata"
+	CompensateRemoveFolderEntry = "remove_folder_entry"
+)
+
+type compensationDoc struct {
+	Step       string    `json:"step"`
+	FileId     string    `json:"file_id"`
+	MetadataId string    `json:"metadata_id"`
+	BucketId   string    `json:"bucket_id"`
+	Path       string    `json:"path"`
+	Name       string    `json:"name"`
+	Cause      string    `json:"cause"`
+	LastError  string    `json:"last_error"`
+	Attempts   int       `json:"attempts"`
+	Resolved   bool      `json:"resolved"`
+	CreatedAt  time.Time `json:"created_at"`
+	ResolvedAt time.Time `json:"resolved_at"`
+}
+
+type FailedCompensation struct {
+	Id         string    `json:"id"`
+	Step       string    `json:"step"`
+	FileId     string    `json:"file_id"`
+	MetadataId string    `json:"metadata_id"`
+	BucketId   string    `json:"bucket_id"`
+	Path       string    `json:"path"`
+	Name       string    `json:"name"`
+	Cause      string    `json:"cause"`
+	LastError  string    `json:"last_error"`
+	Attempts   int       `json:"attempts"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// uploadSaga undoes the completed steps of an upload in reverse order when a
+// later step fails. Compensations that fail themselves are recorded so they
+// can be retried by the repair job.
+type uploadSaga struct {
+	fid  string
+	bid  string
+	path string
+	name string
+
+	metadataId string
+	// folderEntry is set when inserting the file into its folder timed out,
+	// the folder service may still have applied it.
+	folderEntry bool
+}
+
+func (s *uploadSaga) rollback(cause error) {
+	if s.folderEntry {
+		if err := compensate(CompensateRemoveFolderEntry, s.fid, s.metadataId, s.path); err != nil {
+			s.record(CompensateRemoveFolderEntry, cause, err)
+		}
+	}
+
+	if s.metadataId != "" {
+		if err := compensate(CompensateDeleteMetadata, s.fid, s.metadataId, s.path); err != nil {
+			s.record(CompensateDeleteMetadata, cause, err)
+		}
+	}
+
+	if err := compensate(CompensateDeleteBlob, s.fid, s.metadataId, s.path); err != nil {
+		s.record(CompensateDeleteBlob, cause, err)
+	}
+}
+
+func (s *uploadSaga) record(step string, cause error, err error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*arangodb.ContextExpiredTime)
+	defer cancel()
+
+	_, _ = compensationCol.CreateDocument(ctx, compensationDoc{
+		Step:       step,
+		FileId:     s.fid,
+		MetadataId: s.metadataId,
+		BucketId:   s.bid,
+		Path:       s.path,
+		Name:       s.name,
+		Cause:      cause.Error(),
+		LastError:  err.Error(),
+		Attempts:   1,
+		CreatedAt:  time.Now(),
+	})
+}
+
+func compensate(step string, fid string, metadataId string, path string) error {
+	switch step {
+	case CompensateRemoveFolderEntry:
+		return removeFolderEntry(path, metadataId)
+	case CompensateDeleteMetadata:
+		return removeFileMetadata(metadataId)
+	case CompensateDeleteBlob:
+		return seaweed.DeleteBlob(fid)
+	}
+
+	return &utils.ModelError{
+		Msg:     "unknown compensation step",
+		ErrType: utils.Invalid,
+	}
+}
+
+// removeFolderEntry takes a file out of its folder. Only a timeout counts as
+// a failure, any answer from the folder service means the entry is gone or
+// was never there.
+func removeFolderEntry(path string, metadataId string) error {
+	_, err := nats.RemoveFile(path, metadataId)
+	if e, ok := err.(*utils.ModelError); ok && e.ErrType != utils.Timeout {
+		return nil
+	}
+
+	return err
+}
+
+// removeFileMetadata drops a metadata document created by a failed upload and
+// tells consumers of the already queued upload event that it is gone.
+func removeFileMetadata(id string) error {
+	return withTransaction([]string{fileMetadataColName, outboxColName}, func(ctx context.Context) error {
+		var doc arangodb.FileMetadataRes
+		_, err := fileMetadataCol.RemoveDocument(driver.WithReturnOld(ctx, &doc), id)
+		if driver.IsNotFound(err) {
+			return nil
+		} else if err != nil {
+			return &utils.ModelError{
+				Msg:     err.Error(),
+				ErrType: utils.DbError,
+			}
+		}
+
+		fm := toFileMetadata(id, &doc)
+		fm.IsDeleted = true
+		return insertOutboxEvent(ctx, nats.NewFileEvent(nats.DeletedEvent, nats.FileEventDataOf(&fm)))
+	})
+}
+
+func FindFailedCompensations(limit int) ([]FailedCompensation, error) {
+	query := "FOR c IN uploadCompensations FILTER c.resolved == false SORT c.created_at LIMIT @limit RETURN c"
+
+	return findCompensations(query, map[string]interface{}{
+		"limit": limit,
+	})
+}
+
+func findCompensations(query string, bindVars map[string]interface{}) ([]FailedCompensation, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*arangodb.ContextExpiredTime)
+	defer cancel()
+
+	cursor, err := db.Query(ctx, query, bindVars)
+	if err != nil {
+		return nil, &utils.ModelError{
+			Msg:     err.Error(),
+			ErrType: utils.DbError,
+		}
+	}
+	defer cursor.Close()
+
+	compensations := []FailedCompensation{}
+	for {
+		var doc compensationDoc
+		meta, err := cursor.ReadDocument(ctx, &doc)
+		if driver.IsNoMoreDocuments(err) {
+			break
+		} else if err != nil {
+			return nil, &utils.ModelError{
+				Msg:     err.Error(),
+				ErrType: utils.DbError,
+			}
+		}
+		compensations = append(compensations, FailedCompensation{
+			Id:         meta.Key,
+			Step:       doc.Step,
+			FileId:     doc.FileId,
+			MetadataId: doc.MetadataId,
+			BucketId:   doc.BucketId,
+			Path:       doc.Path,
+			Name:       doc.Name,
+			Cause:      doc.Cause,
+			LastError:  doc.LastError,
+			Attempts:   doc.Attempts,
+			CreatedAt:  doc.CreatedAt,
+		})
+	}
+
+	return compensations, nil
+}
+
+// RetryCompensation runs a recorded compensation again and marks it resolved
+// on success.
+func RetryCompensation(c *FailedCompensation) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*arangodb.ContextExpiredTime)
+	defer cancel()
+
+	patch := map[string]interface{}{
+		"attempts": c.Attempts + 1,
+	}
+	cErr := compensate(c.Step, c.FileId, c.MetadataId, c.Path)
+	if cErr != nil {
+		patch["last_error"] = cErr.Error()
+	} else {
+		patch["resolved"] = true
+		patch["resolved_at"] = time.Now()
+	}
+
+	_, err := compensationCol.UpdateDocument(ctx, c.Id, patch)
+	if err != nil {
+		return &utils.ModelError{
+			Msg:     err.Error(),
+			ErrType: utils.DbError,
+		}
+	}
+
+	return cErr
+}
+
+// FindUnresolvedBlobCompensations returns the unresolved compensations that
+// still have to delete a blob, optionally limited to one bucket.
+func FindUnresolvedBlobCompensations(bid string) ([]FailedCompensation, error) {
+	query := "FOR c IN uploadCompensations FILTER c.resolved == false AND c.step == @step " +
+		"AND (@bid == '' OR c.bucket_id == @bid) SORT c.created_at RETURN c"
+
+	return findCompensations(query, map[string]interface{}{
+		"step": CompensateDeleteBlob,
+		"bid":  bid,
+	})
+}
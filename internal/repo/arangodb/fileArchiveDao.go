@@ -0,0 +1,101 @@
+package arango
+
+import (
+	"context"
+	"github.com/Nubes3/common/models/arangodb"
+	"github.com/Nubes3/common/utils"
+	"github.com/arangodb/go-driver"
+	"time"
+)
+
+// FindMetadataByPathPrefix returns every file stored in the folder at path
+// or in any of its sub folders. Expired files are left out, as
+// FindMetadataById does.
+func FindMetadataByPathPrefix(bid string, path string, showHidden bool) ([]arangodb.FileMetadata, error) {
+	query := "FOR fm IN fileMetadata FILTER fm.bucket_id == @bid AND fm.is_deleted == false " +
+		"AND DATE_TIMESTAMP(fm.expired_date) >= DATE_NOW() " +
+		"AND (fm.path == @path OR STARTS_WITH(fm.path, CONCAT(@path, '/'))) "
+	if !showHidden {
+		query += "AND fm.is_hidden == false "
+	}
+	query += "SORT fm.path, fm.name RETURN fm"
+
+	return queryMetadata(query, map[string]interface{}{
+		"bid":  bid,
+		"path": path,
+	})
+}
+
+// FindMetadataByPath returns the files stored directly in the folder at
+// path, without those of its sub folders.
+func FindMetadataByPath(bid string, path string, showHidden bool) ([]arangodb.FileMetadata, error) {
+	query := "FOR fm IN fileMetadata FILTER fm.bucket_id == @bid AND fm.path == @path " +
+		"AND fm.is_deleted == false "
+	if !showHidden {
+		query += "AND fm.is_hidden == false "
+	}
+	query += "SORT fm.name RETURN fm"
+
+	return queryMetadata(query, map[string]interface{}{
+		"bid":  bid,
+		"path": path,
+	})
+}
+
+// FindMetadataByIds returns the live, unexpired files among ids.
+func FindMetadataByIds(ids []string) ([]arangodb.FileMetadata, error) {
+	query := "FOR fm IN fileMetadata FILTER fm._key IN @ids AND fm.is_deleted == false " +
+		"AND DATE_TIMESTAMP(fm.expired_date) >= DATE_NOW() RETURN fm"
+
+	return queryMetadata(query, map[string]interface{}{
+		"ids": ids,
+	})
+}
+
+func queryMetadata(query string, bindVars map[string]interface{}) ([]arangodb.FileMetadata, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*arangodb.ContextExpiredTime)
+	defer cancel()
+
+	return queryMetadataCtx(ctx, query, bindVars)
+}
+
+func queryMetadataCtx(ctx context.Context, query string, bindVars map[string]interface{}) ([]arangodb.FileMetadata, error) {
+	cursor, err := db.Query(ctx, query, bindVars)
+	if err != nil {
+		return nil, &utils.ModelError{
+			Msg:     err.Error(),
+			ErrType: utils.DbError,
+		}
+	}
+	defer cursor.Close()
+
+	fileMetadatas := []arangodb.FileMetadata{}
+	for {
+		fm := arangodb.FileMetadataRes{}
+		meta, err := cursor.ReadDocument(ctx, &fm)
+		if driver.IsNoMoreDocuments(err) {
+			break
+		} else if err != nil {
+			return nil, &utils.ModelError{
+				Msg:     err.Error(),
+				ErrType: utils.DbError,
+			}
+		}
+		fileMetadatas = append(fileMetadatas, toFileMetadata(meta.Key, &fm))
+	}
+
+	return fileMetadatas, nil
+}
+
+// FindMetadataPage pages through every metadata document, deleted ones
+// included, ordered by folder so callers can group files per folder.
+func FindMetadataPage(bid string, offset int, limit int) ([]arangodb.FileMetadata, error) {
+	query := "FOR fm IN fileMetadata FILTER @bid == '' OR fm.bucket_id == @bid " +
+		"SORT fm.bucket_id, fm.path, fm._key LIMIT @offset, @limit RETURN fm"
+
+	return queryMetadata(query, map[string]interface{}{
+		"bid":    bid,
+		"offset": offset,
+		"limit":  limit,
+	})
+}
@@ -0,0 +1,108 @@
+package arango
+
+import (
+	"context"
+	"github.com/Nubes3/common/models/arangodb"
+	"github.com/Nubes3/common/utils"
+	"github.com/arangodb/go-driver"
+	"time"
+)
+
+// FileAttributes holds the user-defined key/value metadata and tags stored
+// alongside the system fields of a fileMetadata document.
+type FileAttributes struct {
+	Metadata map[string]string `json:"metadata"`
+	Tags     []string          `json:"tags"`
+}
+
+type FileMetadataDetail struct {
+	arangodb.FileMetadata
+	FileAttributes
+}
+
+type fileMetadataDoc struct {
+	arangodb.FileMetadataRes
+	FileAttributes
+}
+
+func FindAttributesById(id string) (*FileAttributes, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*arangodb.ContextExpiredTime)
+	defer cancel()
+
+	var attrs FileAttributes
+	_, err := fileMetadataCol.ReadDocument(ctx, id, &attrs)
+	if err != nil {
+		if driver.IsNotFound(err) {
+			return nil, &utils.ModelError{
+				Msg:     "file not found",
+				ErrType: utils.NotFound,
+			}
+		}
+
+		return nil, &utils.ModelError{
+			Msg:     err.Error(),
+			ErrType: utils.DbError,
+		}
+	}
+
+	if attrs.Metadata == nil {
+		attrs.Metadata = map[string]string{}
+	}
+	if attrs.Tags == nil {
+		attrs.Tags = []string{}
+	}
+
+	return &attrs, nil
+}
+
+func FindMetadataDetailById(id string) (*FileMetadataDetail, error) {
+	fm, err := FindMetadataById(id)
+	if err != nil {
+		return nil, err
+	}
+
+	attrs, err := FindAttributesById(id)
+	if err != nil {
+		return nil, err
+	}
+
+	return &FileMetadataDetail{
+		FileMetadata:   *fm,
+		FileAttributes: *attrs,
+	}, nil
+}
+
+// UpdateAttributes merges metadata into the stored metadata of a file, a nil
+// value removing the key, and replaces the tag set when tags is not nil.
+func UpdateAttributes(id string, metadata map[string]*string, tags []string) (*FileMetadataDetail, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*arangodb.ContextExpiredTime)
+	defer cancel()
+
+	patch := map[string]interface{}{}
+	if len(metadata) > 0 {
+		patch["metadata"] = metadata
+	}
+	if tags != nil {
+		patch["tags"] = tags
+	}
+
+	if len(patch) > 0 {
+		ctx = driver.WithKeepNull(driver.WithMergeObjects(ctx, true), false)
+		_, err := fileMetadataCol.UpdateDocument(ctx, id, patch)
+		if err != nil {
+			if driver.IsNotFound(err) {
+				return nil, &utils.ModelError{
+					Msg:     "file not found",
+					ErrType: utils.NotFound,
+				}
+			}
+
+			return nil, &utils.ModelError{
+				Msg:     err.Error(),
+				ErrType: utils.DbError,
+			}
+		}
+	}
+
+	return FindMetadataDetailById(id)
+}
@@ -5,17 +5,19 @@ import (
 	"github.com/Nubes3/common/models/arangodb"
 	"github.com/Nubes3/common/models/seaweedfs"
 	"github.com/Nubes3/common/utils"
+	"github.com/Nubes3/file-service/internal/metrics"
 	"github.com/Nubes3/file-service/internal/repo/nats"
 	"github.com/arangodb/go-driver"
 	"io"
 	"time"
 )
 
-func saveFileMetadata(fid string, bid string,
-	path string, name string, isHidden bool,
-	contentType string, size int64, expiredDate time.Time) (*arangodb.FileMetadata, error) {
-	uploadedTime := time.Now()
-	f, err := nats.FindFolderByFullpath(path)
+// fullPathExpr is the full path of fm in AQL, as policy.FullPath builds it.
+const fullPathExpr = "CONCAT(RTRIM(fm.path, '/'), '/', fm.name)"
+
+func saveFileMetadata(saga *uploadSaga, isHidden bool,
+	contentType string, size int64, expiredDate time.Time, attrs *FileAttributes) (*arangodb.FileMetadata, error) {
+	f, err := nats.FindFolderByFullpath(saga.path)
 	if err != nil {
 		return nil, &utils.ModelError{
 			Msg:     "folder not found",
@@ -23,70 +25,79 @@ func saveFileMetadata(fid string, bid string,
 		}
 	}
 
-	doc := arangodb.FileMetadataRes{
-		FileId:       fid,
-		BucketId:     bid,
-		Path:         path,
-		Name:         name,
-		ContentType:  contentType,
-		Size:         size,
-		IsHidden:     isHidden,
-		IsDeleted:    false,
-		DeletedDate:  time.Time{},
-		UploadedDate: uploadedTime,
-		ExpiredDate:  expiredDate,
-	}
+	fullDoc := newMetadataDoc(saga, isHidden, contentType, size, expiredDate, attrs)
 
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second*arangodb.ContextExpiredTime)
-	defer cancel()
+	var fm arangodb.FileMetadata
+	err = withTransaction([]string{fileMetadataColName, outboxColName}, func(ctx context.Context) error {
+		meta, err := fileMetadataCol.CreateDocument(ctx, fullDoc)
+		if err != nil {
+			return &utils.ModelError{
+				Msg:     err.Error(),
+				ErrType: utils.DbError,
+			}
+		}
+		fm = toFileMetadata(meta.Key, &fullDoc.FileMetadataRes)
 
-	meta, err := fileMetadataCol.CreateDocument(ctx, doc)
+		//LOG UPLOAD SUCCESS
+		return insertOutboxEvent(ctx, nats.NewFileEvent(nats.UploadedEvent, nats.FileEventDataOf(&fm)))
+	})
 	if err != nil {
-		return nil, &utils.ModelError{
-			Msg:     err.Error(),
-			ErrType: utils.DbError,
-		}
+		return nil, err
 	}
+	saga.metadataId = fm.Id
 
-	_, err = nats.InsertFile(meta.Key, doc.Name, f.Id, isHidden)
+	_, err = nats.InsertFile(fm.Id, fm.Name, f.Id, isHidden)
 	if err != nil {
+		if e, ok := err.(*utils.ModelError); ok && e.ErrType == utils.Timeout {
+			saga.folderEntry = true
+		}
 		return nil, &utils.ModelError{
 			Msg:     "insert file to folder failed",
 			ErrType: utils.DbError,
 		}
 	}
 
-	//LOG UPLOAD SUCCESS
-	//_ = nats.SendUploadSuccessFileEvent(meta.Key, doc.FileId, doc.Name, doc.Size,
-	//	doc.BucketId, doc.ContentType, doc.UploadedDate, doc.Path, doc.IsHidden)
+	return &fm, nil
+}
 
-	return &arangodb.FileMetadata{
-		Id:           meta.Key,
-		FileId:       doc.FileId,
-		BucketId:     doc.BucketId,
-		Path:         doc.Path,
-		Name:         doc.Name,
-		ContentType:  doc.ContentType,
-		Size:         doc.Size,
-		IsHidden:     doc.IsHidden,
-		IsDeleted:    doc.IsDeleted,
-		DeletedDate:  doc.DeletedDate,
-		UploadedDate: doc.UploadedDate,
-		ExpiredDate:  doc.ExpiredDate,
-	}, nil
+// newMetadataDoc builds the metadata document of the blob an upload saga
+// stored.
+func newMetadataDoc(saga *uploadSaga, isHidden bool,
+	contentType string, size int64, expiredDate time.Time, attrs *FileAttributes) fileMetadataDoc {
+	doc := fileMetadataDoc{FileMetadataRes: arangodb.FileMetadataRes{
+		FileId:       saga.fid,
+		BucketId:     saga.bid,
+		Path:         saga.path,
+		Name:         saga.name,
+		ContentType:  contentType,
+		Size:         size,
+		IsHidden:     isHidden,
+		IsDeleted:    false,
+		DeletedDate:  time.Time{},
+		UploadedDate: time.Now(),
+		ExpiredDate:  expiredDate,
+	}}
+	if attrs != nil {
+		doc.FileAttributes = *attrs
+	}
+
+	return doc
 }
 
-func FindMetadataByBid(bid string, limit int64, offset int64, showHidden bool) ([]arangodb.FileMetadata, error) {
+// FindMetadataByBid pages through the files of a bucket. A non empty scope
+// is a regular expression their full path must match, see
+// policy.PathPattern.
+func FindMetadataByBid(bid string, limit int64, offset int64, showHidden bool, tags []string,
+	scope string) ([]arangodb.FileMetadata, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), time.Second*arangodb.ContextExpiredTime)
 	defer cancel()
 
 	var query string
 	if showHidden {
-		query = "FOR fm IN fileMetadata FILTER fm.bucket_id == @bid " +
-			"LIMIT @offset, @limit RETURN fm"
+		query = "FOR fm IN fileMetadata FILTER fm.bucket_id == @bid AND fm.is_deleted == false "
 	} else {
-		query = "FOR fm IN fileMetadata FILTER fm.bucket_id == @bid " +
-			"AND fm.is_hidden == false LIMIT @offset, @limit RETURN fm"
+		query = "FOR fm IN fileMetadata FILTER fm.bucket_id == @bid AND fm.is_deleted == false " +
+			"AND fm.is_hidden == false "
 	}
 
 	bindVars := map[string]interface{}{
@@ -95,10 +106,20 @@ func FindMetadataByBid(bid string, limit int64, offset int64, showHidden bool) (
 		"limit":  limit,
 	}
 
+	if len(tags) > 0 {
+		query += "AND @tags ALL IN fm.tags "
+		bindVars["tags"] = tags
+	}
+	if scope != "" {
+		query += "AND REGEX_TEST(" + fullPathExpr + ", @scope) "
+		bindVars["scope"] = scope
+	}
+	query += "LIMIT @offset, @limit RETURN fm"
+
 	fileMetadatas := []arangodb.FileMetadata{}
 	fileMetadata := arangodb.FileMetadata{}
 
-	cursor, err := arangodb.ArangoDb.Query(ctx, query, bindVars)
+	cursor, err := db.Query(ctx, query, bindVars)
 	if err != nil {
 		return nil, &utils.ModelError{
 			Msg:     err.Error(),
@@ -128,7 +149,8 @@ func FindMetadataByFilename(path string, name string, bid string) (*arangodb.Fil
 	ctx, cancel := context.WithTimeout(context.Background(), time.Second*arangodb.ContextExpiredTime)
 	defer cancel()
 
-	query := "FOR fm IN fileMetadata FILTER fm.bucket_id == @bid AND fm.path == @path AND fm.name == @name LIMIT 1 RETURN fm"
+	query := "FOR fm IN fileMetadata FILTER fm.bucket_id == @bid AND fm.path == @path AND fm.name == @name " +
+		"AND fm.is_deleted == false LIMIT 1 RETURN fm"
 	bindVars := map[string]interface{}{
 		"bid":  bid,
 		"path": path,
@@ -136,7 +158,7 @@ func FindMetadataByFilename(path string, name string, bid string) (*arangodb.Fil
 	}
 
 	fm := arangodb.FileMetadataRes{}
-	cursor, err := arangodb.ArangoDb.Query(ctx, query, bindVars)
+	cursor, err := db.Query(ctx, query, bindVars)
 	if err != nil {
 		return nil, &utils.ModelError{
 			Msg:     err.Error(),
@@ -193,7 +215,7 @@ func FindMetadataByFid(fid string) (*arangodb.FileMetadata, error) {
 	}
 
 	fm := arangodb.FileMetadataRes{}
-	cursor, err := arangodb.ArangoDb.Query(ctx, query, bindVars)
+	cursor, err := db.Query(ctx, query, bindVars)
 	if err != nil {
 		return nil, &utils.ModelError{
 			Msg:     err.Error(),
@@ -240,6 +262,12 @@ func FindMetadataById(fid string) (*arangodb.FileMetadata, error) {
 	var data arangodb.FileMetadataRes
 	meta, err := fileMetadataCol.ReadDocument(ctx, fid, &data)
 	if err != nil {
+		if driver.IsNotFound(err) {
+			return nil, &utils.ModelError{
+				Msg:     "file not found",
+				ErrType: utils.NotFound,
+			}
+		}
 		return nil, &utils.ModelError{
 			Msg:     err.Error(),
 			ErrType: utils.DbError,
@@ -271,7 +299,7 @@ func FindMetadataById(fid string) (*arangodb.FileMetadata, error) {
 
 func SaveFile(reader io.Reader, bid string,
 	path string, name string, isHidden bool,
-	contentType string, size int64, ttl time.Duration) (*arangodb.FileMetadata, error) {
+	contentType string, size int64, ttl time.Duration, attrs *FileAttributes) (*arangodb.FileMetadata, error) {
 	//CHECK BUCKET ID AND NAME
 	_, err := nats.FindBucketById(bid)
 	if err != nil {
@@ -294,31 +322,54 @@ func SaveFile(reader io.Reader, bid string,
 		}
 	}
 
+	saga, err := uploadBlob(reader, bid, path, name, isHidden, contentType, size)
+	if err != nil {
+		return nil, err
+	}
+
+	fm, err := saveFileMetadata(saga, isHidden, contentType, size, time.Now().Add(ttl), attrs)
+	if err != nil {
+		saga.rollback(err)
+		return nil, err
+	}
+
+	return fm, nil
+}
+
+// uploadBlob stores the content of an upload and starts its saga.
+func uploadBlob(reader io.Reader, bid string, path string, name string, isHidden bool,
+	contentType string, size int64) (*uploadSaga, error) {
 	//LOG STAGING
-	//_ = nats.SendStagingFileEvent(name, size, bid, contentType, path, isHidden)
+	_ = nats.SendStagingFileEvent(name, size, bid, contentType, path, isHidden)
 
-	meta, err := seaweedfs.UploadFile(name, size, reader)
+	transfer := metrics.Track(metrics.Upload, bid, reader)
+	start := time.Now()
+	meta, err := seaweedfs.UploadFile(name, size, transfer)
+	metrics.ObserveStorage("upload", start, &err)
+	transfer.Done()
 	if err != nil {
 		return nil, err
 	}
 
-	return saveFileMetadata(meta.FileID, bid, path, name, isHidden, contentType, size, time.Now().Add(ttl))
+	return &uploadSaga{
+		fid:  meta.FileID,
+		bid:  bid,
+		path: path,
+		name: name,
+	}, nil
 }
 
 func GetFile(bid string, path, name string, callback func(reader io.Reader, metadata *arangodb.FileMetadata) error) error {
 	meta, err := FindMetadataByFilename(path, name, bid)
 	if err != nil {
-		return &utils.ModelError{
-			Msg:     err.Error(),
-			ErrType: utils.DbError,
-		}
+		return err
 	}
 
 	//CHECK EXPIRED TIME
 
 	//CHECK FILE DELETE
 
-	err = seaweedfs.DownloadFile(meta.FileId, func(reader io.Reader) error {
+	err = downloadBlob(meta, func(reader io.Reader) error {
 		return callback(reader, meta)
 	})
 
@@ -335,13 +386,10 @@ func GetFile(bid string, path, name string, callback func(reader io.Reader, meta
 func GetFileByFid(fid string, callback func(reader io.Reader, metadata *arangodb.FileMetadata) error) error {
 	fileMeta, err := FindMetadataById(fid)
 	if err != nil {
-		return &utils.ModelError{
-			Msg:     err.Error(),
-			ErrType: utils.DbError,
-		}
+		return err
 	}
 
-	err = seaweedfs.DownloadFile(fileMeta.FileId, func(reader io.Reader) error {
+	err = downloadBlob(fileMeta, func(reader io.Reader) error {
 		return callback(reader, fileMeta)
 	})
 
@@ -356,7 +404,9 @@ func GetFileByFid(fid string, callback func(reader io.Reader, metadata *arangodb
 }
 
 func GetFileByFidIgnoreQueryMetadata(fid string, callback func(reader io.Reader) error) error {
+	start := time.Now()
 	err := seaweedfs.DownloadFile(fid, callback)
+	metrics.ObserveStorage("download", start, &err)
 
 	if err != nil {
 		return &utils.ModelError{
@@ -368,54 +418,47 @@ func GetFileByFidIgnoreQueryMetadata(fid string, callback func(reader io.Reader)
 	return nil
 }
 
-func ToggleHidden(fullpath string, isHidden bool) (*arangodb.FileMetadata, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second*arangodb.ContextExpiredTime)
-	defer cancel()
+// DownloadFile streams the content of fm to callback, counting it as
+// downloaded from its bucket.
+func DownloadFile(fm *arangodb.FileMetadata, callback func(reader io.Reader) error) error {
+	err := downloadBlob(fm, callback)
 
-	query := "FOR fm IN fileMetadata FILTER fm.path == @fullpath UPDATE fm WITH { is_hidden: @isHidden} IN fileMetadata RETURN NEW"
-	bindVars := map[string]interface{}{
-		"fullpath": fullpath,
-		"isHidden": isHidden,
-	}
-
-	cursor, err := arangodb.ArangoDb.Query(ctx, query, bindVars)
 	if err != nil {
-		return nil, &utils.ModelError{
+		return &utils.ModelError{
 			Msg:     err.Error(),
-			ErrType: utils.DbError,
+			ErrType: utils.FsError,
 		}
 	}
-	defer cursor.Close()
 
-	fileMetadata := arangodb.FileMetadata{}
-	for {
-		meta, err := cursor.ReadDocument(ctx, &fileMetadata)
-		if driver.IsNoMoreDocuments(err) {
-			break
-		} else if err != nil {
-			return nil, &utils.ModelError{
-				Msg:     err.Error(),
-				ErrType: utils.DbError,
-			}
-		}
-		fileMetadata.Id = meta.Key
-	}
+	return nil
+}
 
-	if fileMetadata.Id == "" {
-		return nil, &utils.ModelError{
-			Msg:     "folder not found",
-			ErrType: utils.NotFound,
-		}
-	}
+func downloadBlob(fm *arangodb.FileMetadata, callback func(reader io.Reader) error) error {
+	start := time.Now()
+	err := seaweedfs.DownloadFile(fm.FileId, func(reader io.Reader) error {
+		transfer := metrics.Track(metrics.Download, fm.BucketId, reader)
+		defer transfer.Done()
 
-	_, err = nats.UpdateHiddenStatusOfFolderChild(fileMetadata.Path, fileMetadata.Id,
-		fileMetadata.Name, fileMetadata.IsHidden)
-	if err != nil {
-		return nil, &utils.ModelError{
-			Msg:     err.Error(),
-			ErrType: utils.DbError,
-		}
-	}
+		return callback(transfer)
+	})
+	metrics.ObserveStorage("download", start, &err)
 
-	return &fileMetadata, nil
+	return err
+}
+
+func toFileMetadata(key string, fm *arangodb.FileMetadataRes) arangodb.FileMetadata {
+	return arangodb.FileMetadata{
+		Id:           key,
+		FileId:       fm.FileId,
+		BucketId:     fm.BucketId,
+		Path:         fm.Path,
+		Name:         fm.Name,
+		ContentType:  fm.ContentType,
+		Size:         fm.Size,
+		IsHidden:     fm.IsHidden,
+		IsDeleted:    fm.IsDeleted,
+		DeletedDate:  fm.DeletedDate,
+		UploadedDate: fm.UploadedDate,
+		ExpiredDate:  fm.ExpiredDate,
+	}
 }
@@ -0,0 +1,347 @@
+package arango
+
+import (
+	"context"
+	"github.com/Nubes3/common/models/arangodb"
+	"github.com/Nubes3/common/utils"
+	"github.com/Nubes3/file-service/internal/repo/nats"
+	"github.com/arangodb/go-driver"
+	"io"
+	"time"
+)
+
+// updateMetadata applies patch to a file and, when eventType is set, records
+// the matching event in the outbox within the same transaction.
+func updateMetadata(id string, patch map[string]interface{}, eventType nats.EventType) (*arangodb.FileMetadata, error) {
+	var fm arangodb.FileMetadata
+	err := withTransaction([]string{fileMetadataColName, outboxColName}, func(ctx context.Context) error {
+		var data arangodb.FileMetadataRes
+		meta, err := fileMetadataCol.UpdateDocument(driver.WithReturnNew(ctx, &data), id, patch)
+		if err != nil {
+			if driver.IsNotFound(err) {
+				return &utils.ModelError{
+					Msg:     "file not found",
+					ErrType: utils.NotFound,
+				}
+			}
+
+			return &utils.ModelError{
+				Msg:     err.Error(),
+				ErrType: utils.DbError,
+			}
+		}
+		fm = toFileMetadata(meta.Key, &data)
+
+		if eventType == "" {
+			return nil
+		}
+		return insertOutboxEvent(ctx, nats.NewFileEvent(eventType, nats.FileEventDataOf(&fm)))
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &fm, nil
+}
+
+// SetFileHidden changes the hidden status of the single file with the given
+// id and mirrors it in its folder.
+func SetFileHidden(id string, isHidden bool) (*arangodb.FileMetadata, error) {
+	fm, err := updateMetadata(id, map[string]interface{}{
+		"is_hidden": isHidden,
+	}, nats.HiddenToggledEvent)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = nats.UpdateHiddenStatusOfFolderChild(fm.Path, fm.Id, fm.Name, fm.IsHidden)
+	if err != nil {
+		return nil, &utils.ModelError{
+			Msg:     err.Error(),
+			ErrType: utils.DbError,
+		}
+	}
+
+	return fm, nil
+}
+
+// SetFolderHidden changes the hidden status of the live files under the
+// folder at path, sub folders included, that allow accepts, and mirrors it
+// in their folders. Only files whose status changes are updated; they are
+// returned. When mirroring fails the changed files are returned along with
+// the error, as their metadata is already committed.
+func SetFolderHidden(bid string, path string, isHidden bool,
+	allow func(fm *arangodb.FileMetadata) bool) ([]arangodb.FileMetadata, error) {
+	query := "FOR fm IN fileMetadata FILTER fm.bucket_id == @bid AND fm.is_deleted == false " +
+		"AND fm.is_hidden != @isHidden " +
+		"AND (fm.path == @path OR STARTS_WITH(fm.path, CONCAT(@path, '/'))) RETURN fm"
+	bindVars := map[string]interface{}{
+		"bid":      bid,
+		"path":     path,
+		"isHidden": isHidden,
+	}
+
+	files := []arangodb.FileMetadata{}
+	err := withTransaction([]string{fileMetadataColName, outboxColName}, func(ctx context.Context) error {
+		found, err := queryMetadataCtx(ctx, query, bindVars)
+		if err != nil {
+			return err
+		}
+
+		for i := range found {
+			if allow != nil && !allow(&found[i]) {
+				continue
+			}
+
+			var data arangodb.FileMetadataRes
+			meta, err := fileMetadataCol.UpdateDocument(driver.WithReturnNew(ctx, &data), found[i].Id,
+				map[string]interface{}{
+					"is_hidden": isHidden,
+				})
+			if err != nil {
+				return &utils.ModelError{
+					Msg:     err.Error(),
+					ErrType: utils.DbError,
+				}
+			}
+			fm := toFileMetadata(meta.Key, &data)
+
+			err = insertOutboxEvent(ctx, nats.NewFileEvent(nats.HiddenToggledEvent, nats.FileEventDataOf(&fm)))
+			if err != nil {
+				return err
+			}
+			files = append(files, fm)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Every entry is mirrored even when one fails, so a single failure
+	// does not leave the rest of the subtree out of date.
+	var folderErr error
+	for i := range files {
+		_, err = nats.UpdateHiddenStatusOfFolderChild(files[i].Path, files[i].Id, files[i].Name, isHidden)
+		if err != nil && folderErr == nil {
+			folderErr = &utils.ModelError{
+				Msg:     err.Error(),
+				ErrType: utils.DbError,
+			}
+		}
+	}
+	if folderErr != nil {
+		return files, folderErr
+	}
+
+	return files, nil
+}
+
+// DeleteFile soft deletes a file and removes it from its folder. The blob is
+// kept so the file can still be recovered.
+func DeleteFile(id string) (*arangodb.FileMetadata, error) {
+	fm, err := updateMetadata(id, map[string]interface{}{
+		"is_deleted":   true,
+		"deleted_date": time.Now(),
+	}, nats.DeletedEvent)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = nats.RemoveFile(fm.Path, fm.Id)
+	if err != nil {
+		return nil, &utils.ModelError{
+			Msg:     err.Error(),
+			ErrType: utils.DbError,
+		}
+	}
+
+	return fm, nil
+}
+
+// MoveFile moves a file into the folder at path, optionally renaming it.
+func MoveFile(id string, path string, name string) (*arangodb.FileMetadata, error) {
+	old, err := FindMetadataById(id)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := nats.FindFolderByFullpath(path)
+	if err != nil {
+		return nil, &utils.ModelError{
+			Msg:     "folder not found",
+			ErrType: utils.NotFound,
+		}
+	}
+
+	_, err = FindMetadataByFilename(path, name, old.BucketId)
+	if err == nil {
+		return nil, &utils.ModelError{
+			Msg:     "duplicate file",
+			ErrType: utils.Duplicated,
+		}
+	}
+
+	fm, err := updateMetadata(id, map[string]interface{}{
+		"path": path,
+		"name": name,
+	}, "")
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = nats.RemoveFile(old.Path, old.Id)
+	if err != nil {
+		return nil, &utils.ModelError{
+			Msg:     err.Error(),
+			ErrType: utils.DbError,
+		}
+	}
+
+	_, err = nats.InsertFile(fm.Id, fm.Name, f.Id, fm.IsHidden)
+	if err != nil {
+		return nil, &utils.ModelError{
+			Msg:     "insert file to folder failed",
+			ErrType: utils.DbError,
+		}
+	}
+
+	return fm, nil
+}
+
+// ReplaceFile saves reader as path/name like SaveFile, but replaces a file
+// already stored there instead of failing. The old file is deleted and the
+// new one created in the same transaction, so the old file is kept whenever
+// the new one fails.
+func ReplaceFile(reader io.Reader, bid string, path string, name string, isHidden bool,
+	contentType string, size int64, ttl time.Duration, attrs *FileAttributes) (*arangodb.FileMetadata, error) {
+	existing, err := FindMetadataByFilename(path, name, bid)
+	if err != nil {
+		return SaveFile(reader, bid, path, name, isHidden, contentType, size, ttl, attrs)
+	}
+
+	f, err := nats.FindFolderByFullpath(path)
+	if err != nil {
+		return nil, &utils.ModelError{
+			Msg:     "folder not found",
+			ErrType: utils.NotFound,
+		}
+	}
+
+	if ttl == time.Duration(0) {
+		ttl = time.Hour * 24 * 365 * 10
+	}
+
+	saga, err := uploadBlob(reader, bid, path, name, isHidden, contentType, size)
+	if err != nil {
+		return nil, err
+	}
+
+	doc := newMetadataDoc(saga, isHidden, contentType, size, time.Now().Add(ttl), attrs)
+	fm, err := swapFile(existing, doc)
+	if err != nil {
+		saga.rollback(err)
+		return nil, err
+	}
+
+	// The metadata is final from here; folder entries left behind by a
+	// failure below are repaired by the reconciler.
+	_, err = nats.RemoveFile(existing.Path, existing.Id)
+	if err == nil {
+		_, err = nats.InsertFile(fm.Id, fm.Name, f.Id, fm.IsHidden)
+	}
+	if err != nil {
+		return nil, &utils.ModelError{
+			Msg:     err.Error(),
+			ErrType: utils.DbError,
+		}
+	}
+
+	return fm, nil
+}
+
+// swapFile soft deletes old and creates doc in its place in one transaction.
+// It fails when old is no longer live, so of two concurrent replaces of the
+// same file only one succeeds.
+func swapFile(old *arangodb.FileMetadata, doc fileMetadataDoc) (*arangodb.FileMetadata, error) {
+	query := "FOR fm IN fileMetadata FILTER fm._key == @id AND fm.is_deleted == false " +
+		"UPDATE fm WITH { is_deleted: true, deleted_date: @now } IN fileMetadata RETURN NEW"
+	bindVars := map[string]interface{}{
+		"id":  old.Id,
+		"now": time.Now(),
+	}
+
+	var fm arangodb.FileMetadata
+	err := withTransaction([]string{fileMetadataColName, outboxColName}, func(ctx context.Context) error {
+		deleted, err := queryMetadataCtx(ctx, query, bindVars)
+		if err != nil {
+			return err
+		}
+		if len(deleted) == 0 {
+			return &utils.ModelError{
+				Msg:     "file was replaced concurrently",
+				ErrType: utils.Duplicated,
+			}
+		}
+		if err := insertOutboxEvent(ctx, nats.NewFileEvent(nats.DeletedEvent, nats.FileEventDataOf(&deleted[0]))); err != nil {
+			return err
+		}
+
+		meta, err := fileMetadataCol.CreateDocument(ctx, doc)
+		if err != nil {
+			return &utils.ModelError{
+				Msg:     err.Error(),
+				ErrType: utils.DbError,
+			}
+		}
+		fm = toFileMetadata(meta.Key, &doc.FileMetadataRes)
+
+		return insertOutboxEvent(ctx, nats.NewFileEvent(nats.UploadedEvent, nats.FileEventDataOf(&fm)))
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &fm, nil
+}
+
+// ExpireFiles soft deletes up to limit files whose expired date has passed
+// and returns them.
+func ExpireFiles(limit int) ([]arangodb.FileMetadata, error) {
+	query := "FOR fm IN fileMetadata FILTER fm.is_deleted == false " +
+		"AND DATE_TIMESTAMP(fm.expired_date) < DATE_NOW() LIMIT @limit " +
+		"UPDATE fm WITH { is_deleted: true, deleted_date: @now } IN fileMetadata RETURN NEW"
+
+	bindVars := map[string]interface{}{
+		"limit": limit,
+		"now":   time.Now(),
+	}
+
+	var files []arangodb.FileMetadata
+	err := withTransaction([]string{fileMetadataColName, outboxColName}, func(ctx context.Context) error {
+		var err error
+		files, err = queryMetadataCtx(ctx, query, bindVars)
+		if err != nil {
+			return err
+		}
+
+		for i := range files {
+			err = insertOutboxEvent(ctx, nats.NewFileEvent(nats.ExpiredEvent, nats.FileEventDataOf(&files[i])))
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range files {
+		_, _ = nats.RemoveFile(files[i].Path, files[i].Id)
+	}
+
+	return files, nil
+}
@@ -0,0 +1,99 @@
+package arango
+
+import (
+	"github.com/Nubes3/common/models/arangodb"
+	"github.com/Nubes3/common/utils"
+	"strings"
+)
+
+type SearchMode int
+
+const (
+	SearchPrefix SearchMode = iota
+	SearchSubstring
+	SearchGlob
+)
+
+func ParseSearchMode(mode string) (SearchMode, error) {
+	switch strings.ToLower(mode) {
+	case "prefix":
+		return SearchPrefix, nil
+	case "substring", "contains":
+		return SearchSubstring, nil
+	case "glob":
+		return SearchGlob, nil
+	default:
+		return -1, &utils.ModelError{
+			Msg:     "invalid search mode: " + mode,
+			ErrType: utils.Invalid,
+		}
+	}
+}
+
+// likePattern converts a user query into a lower-cased AQL LIKE pattern,
+// escaping LIKE wildcards so only glob's * and ? act as wildcards.
+func likePattern(query string, mode SearchMode) string {
+	var sb strings.Builder
+	if mode == SearchSubstring {
+		sb.WriteString("%")
+	}
+
+	for _, r := range strings.ToLower(query) {
+		switch r {
+		case '%', '_', '\\':
+			sb.WriteRune('\\')
+			sb.WriteRune(r)
+		case '*':
+			if mode == SearchGlob {
+				sb.WriteRune('%')
+			} else {
+				sb.WriteRune(r)
+			}
+		case '?':
+			if mode == SearchGlob {
+				sb.WriteRune('_')
+			} else {
+				sb.WriteRune(r)
+			}
+		default:
+			sb.WriteRune(r)
+		}
+	}
+
+	if mode == SearchPrefix || mode == SearchSubstring {
+		sb.WriteString("%")
+	}
+
+	return sb.String()
+}
+
+func SearchMetadata(bid string, field string, q string, mode SearchMode,
+	limit int64, offset int64, showHidden bool, scope string) ([]arangodb.FileMetadata, error) {
+	if field != "name" && field != "path" {
+		return nil, &utils.ModelError{
+			Msg:     "invalid search field: " + field,
+			ErrType: utils.Invalid,
+		}
+	}
+
+	search := "fm.bucket_id == @bid AND fm.is_deleted == false " +
+		"AND ANALYZER(LIKE(fm." + field + ", @pattern), @analyzer)"
+	if !showHidden {
+		search += " AND fm.is_hidden == false"
+	}
+	query := "FOR fm IN " + fileSearchView + " SEARCH " + search + " "
+	bindVars := map[string]interface{}{
+		"bid":      bid,
+		"pattern":  likePattern(q, mode),
+		"analyzer": fileNameAnalyzer,
+		"offset":   offset,
+		"limit":    limit,
+	}
+	if scope != "" {
+		query += "FILTER REGEX_TEST(" + fullPathExpr + ", @scope) "
+		bindVars["scope"] = scope
+	}
+	query += "SORT fm.path, fm.name LIMIT @offset, @limit RETURN fm"
+
+	return queryMetadata(query, bindVars)
+}
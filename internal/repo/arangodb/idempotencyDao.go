@@ -0,0 +1,138 @@
+package arango
+
+import (
+	"context"
+	"github.com/Nubes3/common/models/arangodb"
+	"github.com/Nubes3/common/utils"
+	"github.com/arangodb/go-driver"
+	"time"
+)
+
+const (
+	idempotencyProcessing = "processing"
+	idempotencyCompleted  = "completed"
+)
+
+type idempotencyDoc struct {
+	Key         string    `json:"_key,omitempty"`
+	State       string    `json:"state"`
+	Fingerprint string    `json:"fingerprint"`
+	Status      int       `json:"status"`
+	ContentType string    `json:"content_type"`
+	Body        []byte    `json:"body"`
+	CreatedAt   time.Time `json:"created_at"`
+	ExpiresAt   int64     `json:"expires_at"`
+	LeaseUntil  int64     `json:"lease_until"`
+}
+
+// IdempotentResponse is the first response stored for an idempotency key.
+// Completed is false while the first request is still being processed.
+type IdempotentResponse struct {
+	Completed   bool
+	Fingerprint string
+	Status      int
+	ContentType string
+	Body        []byte
+}
+
+// ReserveIdempotencyKey claims key for window. It returns nil when the caller
+// got the key and must process the request, otherwise the state left by the
+// earlier request with the same key. A key still processing after lease is
+// taken over, so one left by a crashed instance does not block retries for
+// the whole window.
+func ReserveIdempotencyKey(key string, window time.Duration, lease time.Duration) (*IdempotentResponse, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*arangodb.ContextExpiredTime)
+	defer cancel()
+
+	doc := idempotencyDoc{
+		Key:        key,
+		State:      idempotencyProcessing,
+		CreatedAt:  time.Now(),
+		ExpiresAt:  time.Now().Add(window).Unix(),
+		LeaseUntil: time.Now().Add(lease).Unix(),
+	}
+	_, err := idempotencyCol.CreateDocument(ctx, doc)
+	if err == nil {
+		return nil, nil
+	} else if !driver.IsConflict(err) {
+		return nil, &utils.ModelError{
+			Msg:     err.Error(),
+			ErrType: utils.DbError,
+		}
+	}
+
+	var existing idempotencyDoc
+	meta, err := idempotencyCol.ReadDocument(ctx, key, &existing)
+	if err != nil {
+		return nil, &utils.ModelError{
+			Msg:     err.Error(),
+			ErrType: utils.DbError,
+		}
+	}
+
+	// The TTL index removes expired keys lazily, so take over one that is
+	// past its window or its processing lease, guarded by its revision
+	// against a concurrent take over.
+	if existing.ExpiresAt <= time.Now().Unix() ||
+		(existing.State == idempotencyProcessing && existing.LeaseUntil <= time.Now().Unix()) {
+		_, err = idempotencyCol.ReplaceDocument(driver.WithRevision(ctx, meta.Rev), key, doc)
+		if err == nil {
+			return nil, nil
+		} else if !driver.IsPreconditionFailed(err) {
+			return nil, &utils.ModelError{
+				Msg:     err.Error(),
+				ErrType: utils.DbError,
+			}
+		}
+
+		return &IdempotentResponse{}, nil
+	}
+
+	return &IdempotentResponse{
+		Completed:   existing.State == idempotencyCompleted,
+		Fingerprint: existing.Fingerprint,
+		Status:      existing.Status,
+		ContentType: existing.ContentType,
+		Body:        existing.Body,
+	}, nil
+}
+
+// CompleteIdempotencyKey stores the response of the request holding key so
+// repeats can be answered with it.
+func CompleteIdempotencyKey(key string, res *IdempotentResponse) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*arangodb.ContextExpiredTime)
+	defer cancel()
+
+	_, err := idempotencyCol.UpdateDocument(ctx, key, map[string]interface{}{
+		"state":        idempotencyCompleted,
+		"fingerprint":  res.Fingerprint,
+		"status":       res.Status,
+		"content_type": res.ContentType,
+		"body":         res.Body,
+	})
+	if err != nil {
+		return &utils.ModelError{
+			Msg:     err.Error(),
+			ErrType: utils.DbError,
+		}
+	}
+
+	return nil
+}
+
+// ReleaseIdempotencyKey frees key so the request can be retried, used when
+// the first attempt failed on the server side.
+func ReleaseIdempotencyKey(key string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*arangodb.ContextExpiredTime)
+	defer cancel()
+
+	_, err := idempotencyCol.RemoveDocument(ctx, key)
+	if err != nil && !driver.IsNotFound(err) {
+		return &utils.ModelError{
+			Msg:     err.Error(),
+			ErrType: utils.DbError,
+		}
+	}
+
+	return nil
+}
@@ -6,22 +6,176 @@ import (
 	arangoDriver "github.com/arangodb/go-driver"
 )
 
+// fileMetadataColName is the collection file metadata is written to and read
+// from. Releases before the outbox wrote new metadata to the "users"
+// collection while every query read "fileMetadata", so files uploaded by
+// them are missing. Move them once, keeping their keys as folder entries
+// refer to them, and check the counts before removing the originals:
+//
+//	FOR u IN users FILTER HAS(u, "fid") AND HAS(u, "bucket_id")
+//	  INSERT UNSET(u, "_id", "_rev") INTO fileMetadata
+//	FOR u IN users FILTER HAS(u, "fid") AND HAS(u, "bucket_id")
+//	  REMOVE u IN users
+const (
+	fileMetadataColName = "fileMetadata"
+	outboxColName       = "fileEventOutbox"
+	outboxSeqColName    = "fileEventOutboxSeq"
+	webhookColName      = "webhooks"
+	webhookDeliveryName = "webhookDeliveries"
+	compensationColName = "uploadCompensations"
+	idempotencyColName  = "idempotencyKeys"
+	keyScopeColName     = "keyScopes"
+	nonceColName        = "keyPairNonces"
+	shareColName        = "shares"
+	cascadeColName      = "cascades"
+	reconcileRunColName = "reconcileRuns"
+	fileNameAnalyzer    = "fileNameNorm"
+	fileSearchView      = "fileMetadataView"
+)
+
 var (
 	fileMetadataCol arangoDriver.Collection
+	outboxCol       arangoDriver.Collection
+	outboxSeqCol    arangoDriver.Collection
+
+	webhookCol         arangoDriver.Collection
+	webhookDeliveryCol arangoDriver.Collection
+
+	compensationCol arangoDriver.Collection
+	idempotencyCol  arangoDriver.Collection
+	keyScopeCol     arangoDriver.Collection
+	nonceCol        arangoDriver.Collection
+
+	shareCol        arangoDriver.Collection
+	cascadeCol      arangoDriver.Collection
+	reconcileRunCol arangoDriver.Collection
 )
 
 func init() {
 	ctx, cancel := context.WithTimeout(context.Background(), common.ContextExpiredTime)
 	defer cancel()
 
-	exist, err := common.ArangoDb.CollectionExists(ctx, "users")
+	db = instrumentedDb{Database: common.ArangoDb}
+
+	fileMetadataCol = ensureCollection(ctx, fileMetadataColName)
+	_, _, err := fileMetadataCol.EnsurePersistentIndex(ctx, []string{"bucket_id", "path", "name"}, nil)
+	if err != nil {
+		panic(err)
+	}
+
+	outboxCol = ensureCollection(ctx, outboxColName)
+	_, _, err = outboxCol.EnsurePersistentIndex(ctx, []string{"delivered", "next_attempt"}, nil)
+	if err != nil {
+		panic(err)
+	}
+	_, _, err = outboxCol.EnsurePersistentIndex(ctx, []string{"event.file.bucket_id", "seq"}, nil)
+	if err != nil {
+		panic(err)
+	}
+	outboxSeqCol = ensureCollection(ctx, outboxSeqColName)
+	initOutboxSeq(ctx)
+
+	webhookCol = ensureCollection(ctx, webhookColName)
+	webhookDeliveryCol = ensureCollection(ctx, webhookDeliveryName)
+
+	_, _, err = webhookDeliveryCol.EnsurePersistentIndex(ctx, []string{"status", "next_attempt"}, nil)
+	if err != nil {
+		panic(err)
+	}
+	_, _, err = webhookDeliveryCol.EnsurePersistentIndex(ctx, []string{"webhook_id", "created_at"}, nil)
+	if err != nil {
+		panic(err)
+	}
+
+	compensationCol = ensureCollection(ctx, compensationColName)
+	_, _, err = compensationCol.EnsurePersistentIndex(ctx, []string{"resolved", "created_at"}, nil)
+	if err != nil {
+		panic(err)
+	}
+
+	idempotencyCol = ensureCollection(ctx, idempotencyColName)
+	_, _, err = idempotencyCol.EnsureTTLIndex(ctx, "expires_at", 0, nil)
+	if err != nil {
+		panic(err)
+	}
+
+	keyScopeCol = ensureCollection(ctx, keyScopeColName)
+
+	nonceCol = ensureCollection(ctx, nonceColName)
+	_, _, err = nonceCol.EnsureTTLIndex(ctx, "expires_at", 0, nil)
+	if err != nil {
+		panic(err)
+	}
+
+	shareCol = ensureCollection(ctx, shareColName)
+	_, _, err = shareCol.EnsurePersistentIndex(ctx, []string{"bucket_id", "created_at"}, nil)
+	if err != nil {
+		panic(err)
+	}
+
+	cascadeCol = ensureCollection(ctx, cascadeColName)
+	_, _, err = cascadeCol.EnsurePersistentIndex(ctx, []string{"done", "lease_until"}, nil)
 	if err != nil {
 		panic(err)
 	}
 
+	reconcileRunCol = ensureCollection(ctx, reconcileRunColName)
+
+	initSearchView(ctx)
+}
+
+func ensureCollection(ctx context.Context, name string) arangoDriver.Collection {
+	exist, err := common.ArangoDb.CollectionExists(ctx, name)
+	if err != nil {
+		panic(err)
+	}
+
+	var col arangoDriver.Collection
 	if !exist {
-		fileMetadataCol, _ = common.ArangoDb.CreateCollection(ctx, "users", &arangoDriver.CreateCollectionOptions{})
+		col, _ = common.ArangoDb.CreateCollection(ctx, name, &arangoDriver.CreateCollectionOptions{})
 	} else {
-		fileMetadataCol, _ = common.ArangoDb.Collection(ctx, "users")
+		col, _ = common.ArangoDb.Collection(ctx, name)
+	}
+
+	return instrumentedCol{Collection: col}
+}
+
+func initSearchView(ctx context.Context) {
+	accent := false
+	_, _, err := common.ArangoDb.EnsureAnalyzer(ctx, arangoDriver.ArangoSearchAnalyzerDefinition{
+		Name: fileNameAnalyzer,
+		Type: arangoDriver.ArangoSearchAnalyzerTypeNorm,
+		Properties: arangoDriver.ArangoSearchAnalyzerProperties{
+			Locale: "en.utf-8",
+			Case:   arangoDriver.ArangoSearchCaseLower,
+			Accent: &accent,
+		},
+	})
+	if err != nil {
+		panic(err)
+	}
+
+	exist, err := common.ArangoDb.ViewExists(ctx, fileSearchView)
+	if err != nil {
+		panic(err)
+	}
+
+	if !exist {
+		_, err = common.ArangoDb.CreateArangoSearchView(ctx, fileSearchView, &arangoDriver.ArangoSearchViewProperties{
+			Links: arangoDriver.ArangoSearchLinks{
+				fileMetadataColName: arangoDriver.ArangoSearchElementProperties{
+					Fields: arangoDriver.ArangoSearchFields{
+						"bucket_id":  {Analyzers: []string{"identity"}},
+						"is_hidden":  {},
+						"is_deleted": {},
+						"name":       {Analyzers: []string{fileNameAnalyzer}},
+						"path":       {Analyzers: []string{fileNameAnalyzer}},
+					},
+				},
+			},
+		})
+		if err != nil {
+			panic(err)
+		}
 	}
 }
@@ -0,0 +1,114 @@
+package arango
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"github.com/Nubes3/common/models/arangodb"
+	"github.com/Nubes3/common/utils"
+	"github.com/arangodb/go-driver"
+	"time"
+)
+
+// Kinds of keys a path scope can be attached to.
+const (
+	AccessKeyScope = "accessKey"
+	KeyPairScope   = "keyPair"
+)
+
+type keyScopeDoc struct {
+	Kind      string    `json:"kind"`
+	Key       string    `json:"key"`
+	BucketId  string    `json:"bucket_id"`
+	Scopes    []string  `json:"scopes"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+func keyScopeId(kind string, key string) string {
+	sum := sha1.Sum([]byte(kind + ":" + key))
+	return hex.EncodeToString(sum[:])
+}
+
+// FindKeyScopes returns the path scopes of a key. Scopes recorded for another
+// bucket than the key's are ignored, so an owner can only narrow keys of
+// their own buckets.
+func FindKeyScopes(kind string, key string, bid string) ([]string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*arangodb.ContextExpiredTime)
+	defer cancel()
+
+	var doc keyScopeDoc
+	_, err := keyScopeCol.ReadDocument(ctx, keyScopeId(kind, key), &doc)
+	if driver.IsNotFound(err) {
+		return []string{}, nil
+	} else if err != nil {
+		return nil, &utils.ModelError{
+			Msg:     err.Error(),
+			ErrType: utils.DbError,
+		}
+	}
+
+	if doc.BucketId != bid {
+		return []string{}, nil
+	}
+
+	return doc.Scopes, nil
+}
+
+// SetKeyScopes replaces the path scopes of a key; no scopes lifts the
+// restriction. Scopes recorded for another bucket are never replaced or
+// removed, the caller must still check that the key belongs to bid.
+func SetKeyScopes(kind string, key string, bid string, scopes []string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*arangodb.ContextExpiredTime)
+	defer cancel()
+
+	id := keyScopeId(kind, key)
+	var old keyScopeDoc
+	_, err := keyScopeCol.ReadDocument(ctx, id, &old)
+	if err != nil && !driver.IsNotFound(err) {
+		return &utils.ModelError{
+			Msg:     err.Error(),
+			ErrType: utils.DbError,
+		}
+	}
+	if err == nil && old.BucketId != bid {
+		return &utils.ModelError{
+			Msg:     "key scopes belong to another bucket",
+			ErrType: utils.Invalid,
+		}
+	}
+
+	if len(scopes) == 0 {
+		_, err := keyScopeCol.RemoveDocument(ctx, id)
+		if err != nil && !driver.IsNotFound(err) {
+			return &utils.ModelError{
+				Msg:     err.Error(),
+				ErrType: utils.DbError,
+			}
+		}
+
+		return nil
+	}
+
+	query := "UPSERT { _key: @id } INSERT MERGE({ _key: @id }, @doc) REPLACE @doc IN keyScopes"
+	bindVars := map[string]interface{}{
+		"id": id,
+		"doc": keyScopeDoc{
+			Kind:      kind,
+			Key:       key,
+			BucketId:  bid,
+			Scopes:    scopes,
+			UpdatedAt: time.Now(),
+		},
+	}
+
+	cursor, err := db.Query(ctx, query, bindVars)
+	if err != nil {
+		return &utils.ModelError{
+			Msg:     err.Error(),
+			ErrType: utils.DbError,
+		}
+	}
+	_ = cursor.Close()
+
+	return nil
+}
@@ -0,0 +1,93 @@
+package arango
+
+import (
+	"context"
+	"github.com/Nubes3/file-service/internal/metrics"
+	"github.com/arangodb/go-driver"
+	"time"
+)
+
+// db is the database the DAOs use. It times every call it forwards; a
+// document that is not found is an answer, not a failure.
+var db driver.Database
+
+type instrumentedDb struct {
+	driver.Database
+}
+
+type instrumentedCol struct {
+	driver.Collection
+}
+
+func observeDb(operation string, start time.Time, err error) {
+	if driver.IsNotFound(err) || driver.IsConflict(err) {
+		err = nil
+	}
+	metrics.ObserveDb(operation, start, &err)
+}
+
+func (d instrumentedDb) Query(ctx context.Context, query string, bindVars map[string]interface{}) (driver.Cursor, error) {
+	start := time.Now()
+	cursor, err := d.Database.Query(ctx, query, bindVars)
+	observeDb("query", start, err)
+	return cursor, err
+}
+
+func (d instrumentedDb) BeginTransaction(ctx context.Context, cols driver.TransactionCollections,
+	opts *driver.BeginTransactionOptions) (driver.TransactionID, error) {
+	start := time.Now()
+	tid, err := d.Database.BeginTransaction(ctx, cols, opts)
+	observeDb("begin_transaction", start, err)
+	return tid, err
+}
+
+func (d instrumentedDb) CommitTransaction(ctx context.Context, tid driver.TransactionID,
+	opts *driver.CommitTransactionOptions) error {
+	start := time.Now()
+	err := d.Database.CommitTransaction(ctx, tid, opts)
+	observeDb("commit_transaction", start, err)
+	return err
+}
+
+func (d instrumentedDb) AbortTransaction(ctx context.Context, tid driver.TransactionID,
+	opts *driver.AbortTransactionOptions) error {
+	start := time.Now()
+	err := d.Database.AbortTransaction(ctx, tid, opts)
+	observeDb("abort_transaction", start, err)
+	return err
+}
+
+func (c instrumentedCol) ReadDocument(ctx context.Context, key string, result interface{}) (driver.DocumentMeta, error) {
+	start := time.Now()
+	meta, err := c.Collection.ReadDocument(ctx, key, result)
+	observeDb("read_document", start, err)
+	return meta, err
+}
+
+func (c instrumentedCol) CreateDocument(ctx context.Context, document interface{}) (driver.DocumentMeta, error) {
+	start := time.Now()
+	meta, err := c.Collection.CreateDocument(ctx, document)
+	observeDb("create_document", start, err)
+	return meta, err
+}
+
+func (c instrumentedCol) UpdateDocument(ctx context.Context, key string, update interface{}) (driver.DocumentMeta, error) {
+	start := time.Now()
+	meta, err := c.Collection.UpdateDocument(ctx, key, update)
+	observeDb("update_document", start, err)
+	return meta, err
+}
+
+func (c instrumentedCol) ReplaceDocument(ctx context.Context, key string, document interface{}) (driver.DocumentMeta, error) {
+	start := time.Now()
+	meta, err := c.Collection.ReplaceDocument(ctx, key, document)
+	observeDb("replace_document", start, err)
+	return meta, err
+}
+
+func (c instrumentedCol) RemoveDocument(ctx context.Context, key string) (driver.DocumentMeta, error) {
+	start := time.Now()
+	meta, err := c.Collection.RemoveDocument(ctx, key)
+	observeDb("remove_document", start, err)
+	return meta, err
+}
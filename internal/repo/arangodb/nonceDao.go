@@ -0,0 +1,43 @@
+package arango
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"github.com/Nubes3/common/models/arangodb"
+	"github.com/Nubes3/common/utils"
+	"github.com/arangodb/go-driver"
+	"time"
+)
+
+type nonceDoc struct {
+	Key       string `json:"_key"`
+	ExpiresAt int64  `json:"expires_at"`
+}
+
+// ConsumeNonce records that nonce was used by the key pair public until
+// until. Using it again before then fails as Duplicated, which is how
+// replayed signed calls are told apart.
+func ConsumeNonce(public string, nonce string, until time.Time) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*arangodb.ContextExpiredTime)
+	defer cancel()
+
+	sum := sha1.Sum([]byte(public + ":" + nonce))
+	_, err := nonceCol.CreateDocument(ctx, nonceDoc{
+		Key:       hex.EncodeToString(sum[:]),
+		ExpiresAt: until.Unix(),
+	})
+	if driver.IsConflict(err) {
+		return &utils.ModelError{
+			Msg:     "nonce already used",
+			ErrType: utils.Duplicated,
+		}
+	} else if err != nil {
+		return &utils.ModelError{
+			Msg:     err.Error(),
+			ErrType: utils.DbError,
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,98 @@
+package arango
+
+import (
+	"context"
+	"github.com/Nubes3/common/models/arangodb"
+	"github.com/Nubes3/common/utils"
+	"github.com/arangodb/go-driver"
+	"time"
+)
+
+// ObjectListEntry is one entry of an object listing: either a file, or a
+// common prefix grouping every key that continues past the delimiter.
+type ObjectListEntry struct {
+	Key    string
+	Prefix bool
+	File   *arangodb.FileMetadata
+}
+
+// ListObjects pages through the files of a bucket by object key, the full
+// path without the leading /bucketName/, in the way S3's ListObjectsV2 does.
+// Only keys starting with prefix and sorting after marker are listed; with a
+// delimiter, keys continuing past it after the prefix are rolled up into one
+// common prefix entry. dir is the deepest folder holding every such key, so
+// the rest of the bucket is never read. A non empty scope is a regular
+// expression the full paths must match, see policy.PathPattern.
+func ListObjects(bid string, bucketName string, dir string, prefix string, delimiter string, marker string,
+	limit int, showHidden bool, scope string) ([]ObjectListEntry, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*arangodb.ContextExpiredTime)
+	defer cancel()
+
+	query := "FOR fm IN fileMetadata FILTER fm.bucket_id == @bid AND fm.is_deleted == false " +
+		"AND (fm.path == @dir OR STARTS_WITH(fm.path, CONCAT(@dir, '/'))) "
+	bindVars := map[string]interface{}{
+		"bid":       bid,
+		"dir":       dir,
+		"base":      "/" + bucketName + "/",
+		"prefix":    prefix,
+		"delimiter": delimiter,
+		"marker":    marker,
+		"limit":     limit,
+	}
+	if !showHidden {
+		query += "AND fm.is_hidden == false "
+	}
+	query += "LET full = " + fullPathExpr + " "
+	if scope != "" {
+		query += "FILTER REGEX_TEST(full, @scope) "
+		bindVars["scope"] = scope
+	}
+	query += "LET key = SUBSTRING(full, LENGTH(@base)) " +
+		"FILTER STARTS_WITH(key, @prefix) " +
+		"LET rest = SUBSTRING(key, LENGTH(@prefix)) " +
+		"LET i = @delimiter == '' ? -1 : FIND_FIRST(rest, @delimiter) " +
+		"LET group = i < 0 ? key : CONCAT(@prefix, LEFT(rest, i + LENGTH(@delimiter))) " +
+		"FILTER group > @marker " +
+		"COLLECT g = group AGGREGATE id = MIN(fm._key), grouped = MAX(group != key) " +
+		"SORT g LIMIT @limit " +
+		"RETURN { key: g, prefix: grouped, file: grouped ? null : DOCUMENT(fileMetadata, id) }"
+
+	cursor, err := db.Query(ctx, query, bindVars)
+	if err != nil {
+		return nil, &utils.ModelError{
+			Msg:     err.Error(),
+			ErrType: utils.DbError,
+		}
+	}
+	defer cursor.Close()
+
+	entries := []ObjectListEntry{}
+	for {
+		var doc struct {
+			Key    string `json:"key"`
+			Prefix bool   `json:"prefix"`
+			File   *struct {
+				arangodb.FileMetadataRes
+				Key string `json:"_key"`
+			} `json:"file"`
+		}
+		_, err := cursor.ReadDocument(ctx, &doc)
+		if driver.IsNoMoreDocuments(err) {
+			break
+		} else if err != nil {
+			return nil, &utils.ModelError{
+				Msg:     err.Error(),
+				ErrType: utils.DbError,
+			}
+		}
+
+		entry := ObjectListEntry{Key: doc.Key, Prefix: doc.Prefix}
+		if doc.File != nil {
+			fm := toFileMetadata(doc.File.Key, &doc.File.FileMetadataRes)
+			entry.File = &fm
+		}
+		entries = append(entries, entry)
+	}
+
+	return entries, nil
+}
@@ -0,0 +1,307 @@
+package arango
+
+import (
+	"context"
+	"github.com/Nubes3/common/models/arangodb"
+	"github.com/Nubes3/common/utils"
+	"github.com/Nubes3/file-service/internal/repo/nats"
+	"github.com/arangodb/go-driver"
+	"time"
+)
+
+type outboxDoc struct {
+	Seq         int64           `json:"seq"`
+	Event       *nats.FileEvent `json:"event"`
+	Delivered   bool            `json:"delivered"`
+	Attempts    int             `json:"attempts"`
+	NextAttempt int64           `json:"next_attempt"`
+	LastError   string          `json:"last_error"`
+	CreatedAt   time.Time       `json:"created_at"`
+	DeliveredAt time.Time       `json:"delivered_at"`
+}
+
+// outboxSeqKey is the counter document events take their sequence from.
+const outboxSeqKey = "seq"
+
+type OutboxEvent struct {
+	Id       string
+	Seq      int64
+	Event    *nats.FileEvent
+	Attempts int
+}
+
+// withTransaction runs fn in a stream transaction writing to the given
+// collections; fn must pass the context it receives to every operation.
+func withTransaction(write []string, fn func(ctx context.Context) error) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*arangodb.ContextExpiredTime)
+	defer cancel()
+
+	cols := driver.TransactionCollections{Write: write}
+	for _, col := range write {
+		if col == outboxColName {
+			// The sequence counter is held until commit, so events commit
+			// in sequence order, see insertOutboxEvent.
+			cols.Exclusive = []string{outboxSeqColName}
+		}
+	}
+
+	tid, err := db.BeginTransaction(ctx, cols, nil)
+	if err != nil {
+		return &utils.ModelError{
+			Msg:     err.Error(),
+			ErrType: utils.DbError,
+		}
+	}
+
+	err = fn(driver.WithTransactionID(ctx, tid))
+	if err != nil {
+		_ = db.AbortTransaction(ctx, tid, nil)
+		return err
+	}
+
+	err = db.CommitTransaction(ctx, tid, nil)
+	if err != nil {
+		return &utils.ModelError{
+			Msg:     err.Error(),
+			ErrType: utils.DbError,
+		}
+	}
+
+	return nil
+}
+
+// initOutboxSeq creates the sequence counter, starting after the events
+// already logged.
+func initOutboxSeq(ctx context.Context) {
+	query := "LET last = MAX(FOR o IN fileEventOutbox RETURN o.seq) " +
+		"UPSERT { _key: @key } INSERT { _key: @key, value: last || 0 } UPDATE {} IN fileEventOutboxSeq"
+
+	cursor, err := db.Query(ctx, query, map[string]interface{}{
+		"key": outboxSeqKey,
+	})
+	if err != nil {
+		panic(err)
+	}
+	_ = cursor.Close()
+}
+
+// insertOutboxEvent logs event with the next sequence number. The counter is
+// updated in the caller's transaction, which holds it exclusively, so the
+// sequence of the log is also its commit order.
+func insertOutboxEvent(ctx context.Context, event *nats.FileEvent) error {
+	query := "FOR c IN fileEventOutboxSeq FILTER c._key == @key " +
+		"UPDATE c WITH { value: c.value + 1 } IN fileEventOutboxSeq RETURN NEW.value"
+
+	cursor, err := db.Query(ctx, query, map[string]interface{}{
+		"key": outboxSeqKey,
+	})
+	if err != nil {
+		return &utils.ModelError{
+			Msg:     err.Error(),
+			ErrType: utils.DbError,
+		}
+	}
+	defer cursor.Close()
+
+	var seq int64
+	_, err = cursor.ReadDocument(ctx, &seq)
+	if err != nil {
+		return &utils.ModelError{
+			Msg:     err.Error(),
+			ErrType: utils.DbError,
+		}
+	}
+
+	_, err = outboxCol.CreateDocument(ctx, outboxDoc{
+		Seq:         seq,
+		Event:       event,
+		NextAttempt: time.Now().UnixNano() / int64(time.Millisecond),
+		CreatedAt:   time.Now(),
+	})
+	if err != nil {
+		return &utils.ModelError{
+			Msg:     err.Error(),
+			ErrType: utils.DbError,
+		}
+	}
+
+	return nil
+}
+
+// LastOutboxSeq returns the sequence of the last committed event. Every
+// event up to it is committed.
+func LastOutboxSeq() (int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*arangodb.ContextExpiredTime)
+	defer cancel()
+
+	var doc struct {
+		Value int64 `json:"value"`
+	}
+	_, err := outboxSeqCol.ReadDocument(ctx, outboxSeqKey, &doc)
+	if err != nil {
+		return 0, &utils.ModelError{
+			Msg:     err.Error(),
+			ErrType: utils.DbError,
+		}
+	}
+
+	return doc.Value, nil
+}
+
+func FindPendingOutboxEvents(limit int) ([]OutboxEvent, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*arangodb.ContextExpiredTime)
+	defer cancel()
+
+	query := "FOR o IN fileEventOutbox FILTER o.delivered == false AND o.next_attempt <= @now " +
+		"SORT o.created_at LIMIT @limit RETURN o"
+	bindVars := map[string]interface{}{
+		"now":   time.Now().UnixNano() / int64(time.Millisecond),
+		"limit": limit,
+	}
+
+	cursor, err := db.Query(ctx, query, bindVars)
+	if err != nil {
+		return nil, &utils.ModelError{
+			Msg:     err.Error(),
+			ErrType: utils.DbError,
+		}
+	}
+	defer cursor.Close()
+
+	events := []OutboxEvent{}
+	for {
+		var doc outboxDoc
+		meta, err := cursor.ReadDocument(ctx, &doc)
+		if driver.IsNoMoreDocuments(err) {
+			break
+		} else if err != nil {
+			return nil, &utils.ModelError{
+				Msg:     err.Error(),
+				ErrType: utils.DbError,
+			}
+		}
+		events = append(events, OutboxEvent{
+			Id:       meta.Key,
+			Seq:      doc.Seq,
+			Event:    doc.Event,
+			Attempts: doc.Attempts,
+		})
+	}
+
+	return events, nil
+}
+
+// FindBucketEvents reads the retained events of a bucket with a sequence
+// after the given one, optionally limited to files under pathPrefix. The
+// outbox doubles as the event log until delivered events are purged.
+func FindBucketEvents(bid string, pathPrefix string, showHidden bool,
+	after int64, limit int) ([]OutboxEvent, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*arangodb.ContextExpiredTime)
+	defer cancel()
+
+	query := "FOR o IN fileEventOutbox FILTER o.event.file.bucket_id == @bid " +
+		"AND o.seq > @after "
+	bindVars := map[string]interface{}{
+		"bid":   bid,
+		"after": after,
+		"limit": limit,
+	}
+	if pathPrefix != "" {
+		query += "AND (o.event.file.path == @prefix OR STARTS_WITH(o.event.file.path, CONCAT(@prefix, '/'))) "
+		bindVars["prefix"] = pathPrefix
+	}
+	if !showHidden {
+		query += "AND o.event.file.is_hidden == false "
+	}
+	query += "SORT o.seq LIMIT @limit RETURN o"
+
+	cursor, err := db.Query(ctx, query, bindVars)
+	if err != nil {
+		return nil, &utils.ModelError{
+			Msg:     err.Error(),
+			ErrType: utils.DbError,
+		}
+	}
+	defer cursor.Close()
+
+	events := []OutboxEvent{}
+	for {
+		var doc outboxDoc
+		meta, err := cursor.ReadDocument(ctx, &doc)
+		if driver.IsNoMoreDocuments(err) {
+			break
+		} else if err != nil {
+			return nil, &utils.ModelError{
+				Msg:     err.Error(),
+				ErrType: utils.DbError,
+			}
+		}
+		events = append(events, OutboxEvent{
+			Id:       meta.Key,
+			Seq:      doc.Seq,
+			Event:    doc.Event,
+			Attempts: doc.Attempts,
+		})
+	}
+
+	return events, nil
+}
+
+func MarkOutboxDelivered(id string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*arangodb.ContextExpiredTime)
+	defer cancel()
+
+	_, err := outboxCol.UpdateDocument(ctx, id, map[string]interface{}{
+		"delivered":    true,
+		"delivered_at": time.Now(),
+	})
+	if err != nil {
+		return &utils.ModelError{
+			Msg:     err.Error(),
+			ErrType: utils.DbError,
+		}
+	}
+
+	return nil
+}
+
+func MarkOutboxFailed(id string, attempts int, nextAttempt time.Time, reason string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*arangodb.ContextExpiredTime)
+	defer cancel()
+
+	_, err := outboxCol.UpdateDocument(ctx, id, map[string]interface{}{
+		"attempts":     attempts,
+		"next_attempt": nextAttempt.UnixNano() / int64(time.Millisecond),
+		"last_error":   reason,
+	})
+	if err != nil {
+		return &utils.ModelError{
+			Msg:     err.Error(),
+			ErrType: utils.DbError,
+		}
+	}
+
+	return nil
+}
+
+// PurgeDeliveredOutbox removes delivered events created before the given time.
+func PurgeDeliveredOutbox(before time.Time) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*arangodb.ContextExpiredTime)
+	defer cancel()
+
+	query := "FOR o IN fileEventOutbox FILTER o.delivered == true " +
+		"AND DATE_TIMESTAMP(o.created_at) < @before REMOVE o IN fileEventOutbox"
+	bindVars := map[string]interface{}{
+		"before": before.UnixNano() / int64(time.Millisecond),
+	}
+
+	cursor, err := db.Query(ctx, query, bindVars)
+	if err != nil {
+		return &utils.ModelError{
+			Msg:     err.Error(),
+			ErrType: utils.DbError,
+		}
+	}
+
+	return cursor.Close()
+}
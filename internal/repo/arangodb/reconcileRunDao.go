@@ -0,0 +1,122 @@
+package arango
+
+import (
+	"context"
+	"github.com/Nubes3/common/models/arangodb"
+	"github.com/Nubes3/common/utils"
+	"github.com/arangodb/go-driver"
+	"time"
+)
+
+// States of a reconcile run.
+const (
+	ReconcileRunning = "running"
+	ReconcileDone    = "done"
+	ReconcileFailed  = "failed"
+)
+
+// ReconcileRun is a reconcile started by an admin and its report once it
+// finished.
+type ReconcileRun struct {
+	Id         string      `json:"id"`
+	BucketId   string      `json:"bucket_id,omitempty"`
+	Repair     bool        `json:"repair"`
+	Status     string      `json:"status"`
+	Error      string      `json:"error,omitempty"`
+	Report     interface{} `json:"report,omitempty"`
+	StartedAt  time.Time   `json:"started_at"`
+	FinishedAt time.Time   `json:"finished_at"`
+}
+
+type reconcileRunDoc struct {
+	BucketId   string      `json:"bucket_id"`
+	Repair     bool        `json:"repair"`
+	Status     string      `json:"status"`
+	Error      string      `json:"error"`
+	Report     interface{} `json:"report"`
+	StartedAt  time.Time   `json:"started_at"`
+	FinishedAt time.Time   `json:"finished_at"`
+}
+
+func toReconcileRun(key string, doc *reconcileRunDoc) *ReconcileRun {
+	return &ReconcileRun{
+		Id:         key,
+		BucketId:   doc.BucketId,
+		Repair:     doc.Repair,
+		Status:     doc.Status,
+		Error:      doc.Error,
+		Report:     doc.Report,
+		StartedAt:  doc.StartedAt,
+		FinishedAt: doc.FinishedAt,
+	}
+}
+
+func CreateReconcileRun(bid string, repair bool) (*ReconcileRun, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*arangodb.ContextExpiredTime)
+	defer cancel()
+
+	doc := reconcileRunDoc{
+		BucketId:  bid,
+		Repair:    repair,
+		Status:    ReconcileRunning,
+		StartedAt: time.Now(),
+	}
+	meta, err := reconcileRunCol.CreateDocument(ctx, doc)
+	if err != nil {
+		return nil, &utils.ModelError{
+			Msg:     err.Error(),
+			ErrType: utils.DbError,
+		}
+	}
+
+	return toReconcileRun(meta.Key, &doc), nil
+}
+
+// FinishReconcileRun stores the report of a run, or why it failed when
+// cause is set.
+func FinishReconcileRun(id string, report interface{}, cause error) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*arangodb.ContextExpiredTime)
+	defer cancel()
+
+	patch := map[string]interface{}{
+		"status":      ReconcileDone,
+		"report":      report,
+		"finished_at": time.Now(),
+	}
+	if cause != nil {
+		patch["status"] = ReconcileFailed
+		patch["error"] = cause.Error()
+	}
+
+	_, err := reconcileRunCol.UpdateDocument(ctx, id, patch)
+	if err != nil {
+		return &utils.ModelError{
+			Msg:     err.Error(),
+			ErrType: utils.DbError,
+		}
+	}
+
+	return nil
+}
+
+func FindReconcileRun(id string) (*ReconcileRun, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*arangodb.ContextExpiredTime)
+	defer cancel()
+
+	var doc reconcileRunDoc
+	meta, err := reconcileRunCol.ReadDocument(ctx, id, &doc)
+	if err != nil {
+		if driver.IsNotFound(err) {
+			return nil, &utils.ModelError{
+				Msg:     "reconcile run not found",
+				ErrType: utils.NotFound,
+			}
+		}
+		return nil, &utils.ModelError{
+			Msg:     err.Error(),
+			ErrType: utils.DbError,
+		}
+	}
+
+	return toReconcileRun(meta.Key, &doc), nil
+}
@@ -0,0 +1,302 @@
+package arango
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"github.com/Nubes3/common/models/arangodb"
+	"github.com/Nubes3/common/utils"
+	"github.com/arangodb/go-driver"
+	"time"
+)
+
+const (
+	ShareFile   = "file"
+	ShareFolder = "folder"
+)
+
+// consumeRetries bounds how often a download is retried when concurrent
+// downloads of the same share conflict.
+const consumeRetries = 5
+
+type Share struct {
+	Id           string    `json:"id"`
+	BucketId     string    `json:"bucket_id"`
+	OwnerId      string    `json:"owner_id"`
+	Type         string    `json:"type"`
+	FileId       string    `json:"file_id,omitempty"`
+	Path         string    `json:"path,omitempty"`
+	HasPassword  bool      `json:"has_password"`
+	PasswordHash string    `json:"-"`
+	MaxDownloads int       `json:"max_downloads"`
+	Downloads    int       `json:"downloads"`
+	ExpiredDate  time.Time `json:"expired_date"`
+	Revoked      bool      `json:"revoked"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+type shareDoc struct {
+	Key          string    `json:"_key,omitempty"`
+	BucketId     string    `json:"bucket_id"`
+	OwnerId      string    `json:"owner_id"`
+	Type         string    `json:"type"`
+	FileId       string    `json:"file_id"`
+	Path         string    `json:"path"`
+	PasswordHash string    `json:"password_hash"`
+	MaxDownloads int       `json:"max_downloads"`
+	Downloads    int       `json:"downloads"`
+	ExpiredDate  time.Time `json:"expired_date"`
+	Revoked      bool      `json:"revoked"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+func toShare(key string, doc *shareDoc) *Share {
+	return &Share{
+		Id:           key,
+		BucketId:     doc.BucketId,
+		OwnerId:      doc.OwnerId,
+		Type:         doc.Type,
+		FileId:       doc.FileId,
+		Path:         doc.Path,
+		HasPassword:  doc.PasswordHash != "",
+		PasswordHash: doc.PasswordHash,
+		MaxDownloads: doc.MaxDownloads,
+		Downloads:    doc.Downloads,
+		ExpiredDate:  doc.ExpiredDate,
+		Revoked:      doc.Revoked,
+		CreatedAt:    doc.CreatedAt,
+	}
+}
+
+// CreateShare stores a share link. Its id is a random token, so the link
+// cannot be guessed from other shares.
+func CreateShare(bid string, ownerId string, shareType string, fileId string, path string,
+	passwordHash string, maxDownloads int, expiredDate time.Time) (*Share, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*arangodb.ContextExpiredTime)
+	defer cancel()
+
+	token := make([]byte, 16)
+	if _, err := rand.Read(token); err != nil {
+		return nil, &utils.ModelError{
+			Msg:     err.Error(),
+			ErrType: utils.Other,
+		}
+	}
+
+	doc := shareDoc{
+		Key:          hex.EncodeToString(token),
+		BucketId:     bid,
+		OwnerId:      ownerId,
+		Type:         shareType,
+		FileId:       fileId,
+		Path:         path,
+		PasswordHash: passwordHash,
+		MaxDownloads: maxDownloads,
+		ExpiredDate:  expiredDate,
+		CreatedAt:    time.Now(),
+	}
+
+	meta, err := shareCol.CreateDocument(ctx, doc)
+	if err != nil {
+		return nil, &utils.ModelError{
+			Msg:     err.Error(),
+			ErrType: utils.DbError,
+		}
+	}
+
+	return toShare(meta.Key, &doc), nil
+}
+
+func FindShareById(id string) (*Share, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*arangodb.ContextExpiredTime)
+	defer cancel()
+
+	var doc shareDoc
+	meta, err := shareCol.ReadDocument(ctx, id, &doc)
+	if err != nil {
+		if driver.IsNotFound(err) {
+			return nil, &utils.ModelError{
+				Msg:     "share not found",
+				ErrType: utils.NotFound,
+			}
+		}
+		return nil, &utils.ModelError{
+			Msg:     err.Error(),
+			ErrType: utils.DbError,
+		}
+	}
+
+	return toShare(meta.Key, &doc), nil
+}
+
+// FindSharesByBid lists the shares of a bucket, revoked ones included.
+func FindSharesByBid(bid string) ([]Share, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*arangodb.ContextExpiredTime)
+	defer cancel()
+
+	query := "FOR s IN shares FILTER s.bucket_id == @bid SORT s.created_at DESC RETURN s"
+	bindVars := map[string]interface{}{
+		"bid": bid,
+	}
+
+	cursor, err := db.Query(ctx, query, bindVars)
+	if err != nil {
+		return nil, &utils.ModelError{
+			Msg:     err.Error(),
+			ErrType: utils.DbError,
+		}
+	}
+	defer cursor.Close()
+
+	shares := []Share{}
+	for {
+		var doc shareDoc
+		meta, err := cursor.ReadDocument(ctx, &doc)
+		if driver.IsNoMoreDocuments(err) {
+			break
+		} else if err != nil {
+			return nil, &utils.ModelError{
+				Msg:     err.Error(),
+				ErrType: utils.DbError,
+			}
+		}
+		shares = append(shares, *toShare(meta.Key, &doc))
+	}
+
+	return shares, nil
+}
+
+// RevokeShare disables a share link. The record is kept so owners still
+// see its download count.
+func RevokeShare(id string) (*Share, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*arangodb.ContextExpiredTime)
+	defer cancel()
+
+	var doc shareDoc
+	ctx = driver.WithReturnNew(ctx, &doc)
+	meta, err := shareCol.UpdateDocument(ctx, id, map[string]interface{}{
+		"revoked": true,
+	})
+	if err != nil {
+		if driver.IsNotFound(err) {
+			return nil, &utils.ModelError{
+				Msg:     "share not found",
+				ErrType: utils.NotFound,
+			}
+		}
+		return nil, &utils.ModelError{
+			Msg:     err.Error(),
+			ErrType: utils.DbError,
+		}
+	}
+
+	return toShare(meta.Key, &doc), nil
+}
+
+// ConsumeShareDownload counts one download of a share. The checks and the
+// increment run as one AQL update, so concurrent downloads can never exceed
+// the share's limit.
+func ConsumeShareDownload(id string) (*Share, error) {
+	query := "FOR s IN shares FILTER s._key == @id AND s.revoked == false AND DATE_TIMESTAMP(s.expired_date) > @now " +
+		"AND (s.max_downloads == 0 OR s.downloads < s.max_downloads) " +
+		"UPDATE s WITH { downloads: s.downloads + 1 } IN shares RETURN NEW"
+	bindVars := map[string]interface{}{
+		"id":  id,
+		"now": time.Now().UnixNano() / int64(time.Millisecond),
+	}
+
+	var err error
+	for i := 0; i < consumeRetries; i++ {
+		var share *Share
+		share, err = updateShare(query, bindVars)
+		if err == nil && share != nil {
+			return share, nil
+		}
+		if err == nil {
+			return nil, shareUnavailable(id)
+		}
+		if !driver.IsConflict(err) {
+			break
+		}
+	}
+
+	return nil, &utils.ModelError{
+		Msg:     err.Error(),
+		ErrType: utils.DbError,
+	}
+}
+
+// RefundShareDownload gives back a download counted for a transfer that
+// failed before anything was sent.
+func RefundShareDownload(id string) error {
+	query := "FOR s IN shares FILTER s._key == @id AND s.downloads > 0 " +
+		"UPDATE s WITH { downloads: s.downloads - 1 } IN shares RETURN NEW"
+	bindVars := map[string]interface{}{
+		"id": id,
+	}
+
+	var err error
+	for i := 0; i < consumeRetries; i++ {
+		_, err = updateShare(query, bindVars)
+		if err == nil {
+			return nil
+		}
+		if !driver.IsConflict(err) {
+			break
+		}
+	}
+
+	return &utils.ModelError{
+		Msg:     err.Error(),
+		ErrType: utils.DbError,
+	}
+}
+
+// updateShare runs an AQL update of a single share and returns the updated
+// share, nil when the filter matched nothing.
+func updateShare(query string, bindVars map[string]interface{}) (*Share, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*arangodb.ContextExpiredTime)
+	defer cancel()
+
+	cursor, err := db.Query(ctx, query, bindVars)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close()
+
+	var doc shareDoc
+	meta, err := cursor.ReadDocument(ctx, &doc)
+	if driver.IsNoMoreDocuments(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	return toShare(meta.Key, &doc), nil
+}
+
+// shareUnavailable explains why a share can no longer be downloaded.
+func shareUnavailable(id string) error {
+	share, err := FindShareById(id)
+	if err != nil {
+		return err
+	}
+
+	if share.Revoked {
+		return &utils.ModelError{
+			Msg:     "share not found",
+			ErrType: utils.NotFound,
+		}
+	}
+	if !share.ExpiredDate.After(time.Now()) {
+		return &utils.ModelError{
+			Msg:     "share link expired",
+			ErrType: utils.Expired,
+		}
+	}
+
+	return &utils.ModelError{
+		Msg:     "download limit reached",
+		ErrType: utils.Expired,
+	}
+}
@@ -0,0 +1,362 @@
+package arango
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"github.com/Nubes3/common/models/arangodb"
+	"github.com/Nubes3/common/utils"
+	"github.com/Nubes3/file-service/internal/repo/nats"
+	"github.com/Nubes3/file-service/internal/webhook"
+	"github.com/arangodb/go-driver"
+	"time"
+)
+
+const (
+	DeliveryPending   = webhook.StatusPending
+	DeliveryDelivered = webhook.StatusDelivered
+	DeliveryDead      = webhook.StatusDead
+)
+
+type Webhook struct {
+	Id        string    `json:"id"`
+	BucketId  string    `json:"bucket_id"`
+	Url       string    `json:"url"`
+	Events    []string  `json:"events"`
+	Secret    string    `json:"secret,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type webhookDoc struct {
+	BucketId  string    `json:"bucket_id"`
+	Url       string    `json:"url"`
+	Events    []string  `json:"events"`
+	Secret    string    `json:"secret"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type WebhookDelivery struct {
+	Id           string          `json:"id"`
+	WebhookId    string          `json:"webhook_id"`
+	BucketId     string          `json:"bucket_id"`
+	Event        *nats.FileEvent `json:"event"`
+	Status       string          `json:"status"`
+	Attempts     int             `json:"attempts"`
+	NextAttempt  int64           `json:"next_attempt"`
+	ResponseCode int             `json:"response_code"`
+	LastError    string          `json:"last_error"`
+	CreatedAt    time.Time       `json:"created_at"`
+	DeliveredAt  time.Time       `json:"delivered_at"`
+}
+
+type PendingWebhookDelivery struct {
+	WebhookDelivery
+	Url    string `json:"url"`
+	Secret string `json:"secret"`
+}
+
+// WebhookFilter maps an event type to the filter name webhooks subscribe
+// with, or "" when webhooks are not notified of it.
+func WebhookFilter(eventType nats.EventType) string {
+	switch eventType {
+	case nats.UploadedEvent:
+		return "upload"
+	case nats.DeletedEvent, nats.ExpiredEvent:
+		return "delete"
+	case nats.HiddenToggledEvent:
+		return "hidden"
+	default:
+		return ""
+	}
+}
+
+func CreateWebhook(bid string, url string, events []string, secret string) (*Webhook, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*arangodb.ContextExpiredTime)
+	defer cancel()
+
+	doc := webhookDoc{
+		BucketId:  bid,
+		Url:       url,
+		Events:    events,
+		Secret:    secret,
+		CreatedAt: time.Now(),
+	}
+
+	meta, err := webhookCol.CreateDocument(ctx, doc)
+	if err != nil {
+		return nil, &utils.ModelError{
+			Msg:     err.Error(),
+			ErrType: utils.DbError,
+		}
+	}
+
+	return &Webhook{
+		Id:        meta.Key,
+		BucketId:  doc.BucketId,
+		Url:       doc.Url,
+		Events:    doc.Events,
+		Secret:    doc.Secret,
+		CreatedAt: doc.CreatedAt,
+	}, nil
+}
+
+// FindWebhooksByBid lists the webhooks of a bucket without their secrets.
+func FindWebhooksByBid(bid string) ([]Webhook, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*arangodb.ContextExpiredTime)
+	defer cancel()
+
+	query := "FOR w IN webhooks FILTER w.bucket_id == @bid SORT w.created_at RETURN w"
+	bindVars := map[string]interface{}{
+		"bid": bid,
+	}
+
+	cursor, err := db.Query(ctx, query, bindVars)
+	if err != nil {
+		return nil, &utils.ModelError{
+			Msg:     err.Error(),
+			ErrType: utils.DbError,
+		}
+	}
+	defer cursor.Close()
+
+	webhooks := []Webhook{}
+	for {
+		var doc webhookDoc
+		meta, err := cursor.ReadDocument(ctx, &doc)
+		if driver.IsNoMoreDocuments(err) {
+			break
+		} else if err != nil {
+			return nil, &utils.ModelError{
+				Msg:     err.Error(),
+				ErrType: utils.DbError,
+			}
+		}
+		webhooks = append(webhooks, Webhook{
+			Id:        meta.Key,
+			BucketId:  doc.BucketId,
+			Url:       doc.Url,
+			Events:    doc.Events,
+			CreatedAt: doc.CreatedAt,
+		})
+	}
+
+	return webhooks, nil
+}
+
+func FindWebhookById(id string) (*Webhook, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*arangodb.ContextExpiredTime)
+	defer cancel()
+
+	var doc webhookDoc
+	meta, err := webhookCol.ReadDocument(ctx, id, &doc)
+	if err != nil {
+		if driver.IsNotFound(err) {
+			return nil, &utils.ModelError{
+				Msg:     "webhook not found",
+				ErrType: utils.NotFound,
+			}
+		}
+
+		return nil, &utils.ModelError{
+			Msg:     err.Error(),
+			ErrType: utils.DbError,
+		}
+	}
+
+	return &Webhook{
+		Id:        meta.Key,
+		BucketId:  doc.BucketId,
+		Url:       doc.Url,
+		Events:    doc.Events,
+		CreatedAt: doc.CreatedAt,
+	}, nil
+}
+
+func DeleteWebhook(id string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*arangodb.ContextExpiredTime)
+	defer cancel()
+
+	_, err := webhookCol.RemoveDocument(ctx, id)
+	if err != nil {
+		if driver.IsNotFound(err) {
+			return &utils.ModelError{
+				Msg:     "webhook not found",
+				ErrType: utils.NotFound,
+			}
+		}
+
+		return &utils.ModelError{
+			Msg:     err.Error(),
+			ErrType: utils.DbError,
+		}
+	}
+
+	return nil
+}
+
+// EnqueueWebhookDeliveries queues a delivery of event for every webhook of
+// its bucket subscribed to it. Delivery keys derive from the webhook and
+// event ids, so enqueueing the same event twice is a no-op.
+func EnqueueWebhookDeliveries(event *nats.FileEvent) error {
+	filter := WebhookFilter(event.Type)
+	if filter == "" {
+		return nil
+	}
+
+	webhooks, err := FindWebhooksByBid(event.File.BucketId)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*arangodb.ContextExpiredTime)
+	defer cancel()
+
+	for _, w := range webhooks {
+		if !containsString(w.Events, filter) {
+			continue
+		}
+
+		sum := sha1.Sum([]byte(w.Id + "/" + event.Id))
+		_, err := webhookDeliveryCol.CreateDocument(ctx, map[string]interface{}{
+			"_key":         hex.EncodeToString(sum[:]),
+			"webhook_id":   w.Id,
+			"bucket_id":    w.BucketId,
+			"event":        event,
+			"status":       DeliveryPending,
+			"attempts":     0,
+			"next_attempt": time.Now().UnixNano() / int64(time.Millisecond),
+			"created_at":   time.Now(),
+		})
+		if err != nil && !driver.IsConflict(err) {
+			return &utils.ModelError{
+				Msg:     err.Error(),
+				ErrType: utils.DbError,
+			}
+		}
+	}
+
+	return nil
+}
+
+func FindPendingWebhookDeliveries(limit int) ([]PendingWebhookDelivery, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*arangodb.ContextExpiredTime)
+	defer cancel()
+
+	query := "FOR d IN webhookDeliveries FILTER d.status == @status AND d.next_attempt <= @now " +
+		"SORT d.next_attempt LIMIT @limit LET w = DOCUMENT(webhooks, d.webhook_id) " +
+		"RETURN MERGE(d, { url: w.url, secret: w.secret })"
+	bindVars := map[string]interface{}{
+		"status": DeliveryPending,
+		"now":    time.Now().UnixNano() / int64(time.Millisecond),
+		"limit":  limit,
+	}
+
+	cursor, err := db.Query(ctx, query, bindVars)
+	if err != nil {
+		return nil, &utils.ModelError{
+			Msg:     err.Error(),
+			ErrType: utils.DbError,
+		}
+	}
+	defer cursor.Close()
+
+	deliveries := []PendingWebhookDelivery{}
+	for {
+		var d PendingWebhookDelivery
+		meta, err := cursor.ReadDocument(ctx, &d)
+		if driver.IsNoMoreDocuments(err) {
+			break
+		} else if err != nil {
+			return nil, &utils.ModelError{
+				Msg:     err.Error(),
+				ErrType: utils.DbError,
+			}
+		}
+		d.Id = meta.Key
+		deliveries = append(deliveries, d)
+	}
+
+	return deliveries, nil
+}
+
+func UpdateWebhookDelivery(id string, status string, attempts int, nextAttempt time.Time,
+	responseCode int, lastError string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*arangodb.ContextExpiredTime)
+	defer cancel()
+
+	patch := map[string]interface{}{
+		"status":        status,
+		"attempts":      attempts,
+		"next_attempt":  nextAttempt.UnixNano() / int64(time.Millisecond),
+		"response_code": responseCode,
+		"last_error":    lastError,
+	}
+	if status == DeliveryDelivered {
+		patch["delivered_at"] = time.Now()
+	}
+
+	_, err := webhookDeliveryCol.UpdateDocument(ctx, id, patch)
+	if err != nil {
+		return &utils.ModelError{
+			Msg:     err.Error(),
+			ErrType: utils.DbError,
+		}
+	}
+
+	return nil
+}
+
+// FindWebhookDeliveries returns the most recent deliveries of a webhook,
+// optionally only those with the given status.
+func FindWebhookDeliveries(webhookId string, status string, limit int64) ([]WebhookDelivery, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*arangodb.ContextExpiredTime)
+	defer cancel()
+
+	query := "FOR d IN webhookDeliveries FILTER d.webhook_id == @wid "
+	bindVars := map[string]interface{}{
+		"wid":   webhookId,
+		"limit": limit,
+	}
+	if status != "" {
+		query += "AND d.status == @status "
+		bindVars["status"] = status
+	}
+	query += "SORT d.created_at DESC LIMIT @limit RETURN d"
+
+	cursor, err := db.Query(ctx, query, bindVars)
+	if err != nil {
+		return nil, &utils.ModelError{
+			Msg:     err.Error(),
+			ErrType: utils.DbError,
+		}
+	}
+	defer cursor.Close()
+
+	deliveries := []WebhookDelivery{}
+	for {
+		var d WebhookDelivery
+		meta, err := cursor.ReadDocument(ctx, &d)
+		if driver.IsNoMoreDocuments(err) {
+			break
+		} else if err != nil {
+			return nil, &utils.ModelError{
+				Msg:     err.Error(),
+				ErrType: utils.DbError,
+			}
+		}
+		d.Id = meta.Key
+		deliveries = append(deliveries, d)
+	}
+
+	return deliveries, nil
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+
+	return false
+}
@@ -5,6 +5,7 @@ import (
 	"github.com/Nubes3/common/models/arangodb"
 	"github.com/Nubes3/common/models/nats"
 	"github.com/Nubes3/common/utils"
+	"github.com/Nubes3/file-service/internal/metrics"
 	"strconv"
 	"time"
 )
@@ -18,6 +19,7 @@ func FindFolderByFullpath(fullname string) (*arangodb.Folder, error) {
 	messageJson, _ := json.Marshal(message)
 	rawRep, err := nats.Nc.Request(nats.FolderSubj, messageJson, time.Second*10)
 	if err != nil {
+		metrics.NatsTimeout(nats.FolderSubj)
 		return nil, &utils.ModelError{
 			Msg:     err.Error(),
 			ErrType: utils.Timeout,
@@ -54,6 +56,7 @@ func InsertFile(fid, fname, parentId string, isHidden bool) (*arangodb.Folder, e
 	messageJson, _ := json.Marshal(message)
 	rawRep, err := nats.Nc.Request(nats.FolderSubj, messageJson, time.Second*10)
 	if err != nil {
+		metrics.NatsTimeout(nats.FolderSubj)
 		return nil, &utils.ModelError{
 			Msg:     err.Error(),
 			ErrType: utils.Timeout,
@@ -90,6 +93,44 @@ func UpdateHiddenStatusOfFolderChild(path, fid, name string, hiddenStatus bool)
 	messageJson, _ := json.Marshal(message)
 	rawRep, err := nats.Nc.Request(nats.FolderSubj, messageJson, time.Second*10)
 	if err != nil {
+		metrics.NatsTimeout(nats.FolderSubj)
+		return nil, &utils.ModelError{
+			Msg:     err.Error(),
+			ErrType: utils.Timeout,
+		}
+	}
+
+	var rep nats.MsgResponse
+	_ = json.Unmarshal(rawRep.Data, &rep)
+	if rep.IsErr {
+		return nil, &utils.ModelError{
+			Msg:     rep.Data,
+			ErrType: utils.Other,
+		}
+	}
+
+	var folder arangodb.Folder
+	err = json.Unmarshal([]byte(rep.Data), &folder)
+	if err != nil {
+		return nil, &utils.ModelError{
+			Msg:     err.Error(),
+			ErrType: utils.Other,
+		}
+	}
+
+	return &folder, nil
+}
+
+func RemoveFile(path, fid string) (*arangodb.Folder, error) {
+	message := nats.Msg{
+		ReqType:   nats.Remove,
+		Data:      fid,
+		ExtraData: []string{path},
+	}
+	messageJson, _ := json.Marshal(message)
+	rawRep, err := nats.Nc.Request(nats.FolderSubj, messageJson, time.Second*10)
+	if err != nil {
+		metrics.NatsTimeout(nats.FolderSubj)
 		return nil, &utils.ModelError{
 			Msg:     err.Error(),
 			ErrType: utils.Timeout,
@@ -0,0 +1,47 @@
+package nats
+
+import (
+	"encoding/json"
+	"github.com/Nubes3/common/models/arangodb"
+	"github.com/Nubes3/common/models/nats"
+	"github.com/Nubes3/common/utils"
+	"github.com/Nubes3/file-service/internal/metrics"
+	"time"
+)
+
+func FindAccessKeyByKey(key string) (*arangodb.AccessKey, error) {
+	message := nats.Msg{
+		ReqType:   nats.GetById,
+		Data:      key,
+		ExtraData: nil,
+	}
+	messageJson, _ := json.Marshal(message)
+	rawRep, err := nats.Nc.Request(nats.AccessKeySubj, messageJson, time.Second*10)
+	if err != nil {
+		metrics.NatsTimeout(nats.AccessKeySubj)
+		return nil, &utils.ModelError{
+			Msg:     err.Error(),
+			ErrType: utils.Timeout,
+		}
+	}
+
+	var rep nats.MsgResponse
+	_ = json.Unmarshal(rawRep.Data, &rep)
+	if rep.IsErr {
+		return nil, &utils.ModelError{
+			Msg:     rep.Data,
+			ErrType: utils.Other,
+		}
+	}
+
+	var accessKey arangodb.AccessKey
+	err = json.Unmarshal([]byte(rep.Data), &accessKey)
+	if err != nil {
+		return nil, &utils.ModelError{
+			Msg:     err.Error(),
+			ErrType: utils.Other,
+		}
+	}
+
+	return &accessKey, nil
+}
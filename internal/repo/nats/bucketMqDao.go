@@ -5,6 +5,7 @@ import (
 	"github.com/Nubes3/common/models/arangodb"
 	"github.com/Nubes3/common/models/nats"
 	"github.com/Nubes3/common/utils"
+	"github.com/Nubes3/file-service/internal/metrics"
 	"time"
 )
 
@@ -17,6 +18,7 @@ func FindBucketById(id string) (*arangodb.Bucket, error) {
 	messageJson, _ := json.Marshal(message)
 	rawRep, err := nats.Nc.Request(nats.BucketSubj, messageJson, time.Second*10)
 	if err != nil {
+		metrics.NatsTimeout(nats.BucketSubj)
 		return nil, &utils.ModelError{
 			Msg:     err.Error(),
 			ErrType: utils.Timeout,
@@ -0,0 +1,185 @@
+package nats
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"github.com/Nubes3/common/models/arangodb"
+	"github.com/Nubes3/common/models/nats"
+	"github.com/Nubes3/common/utils"
+	"github.com/Nubes3/file-service/internal/config"
+	natsGo "github.com/nats-io/nats.go"
+	"log"
+	"time"
+)
+
+// EventSchemaVersion is bumped on any incompatible change to FileEvent.
+const EventSchemaVersion = 1
+
+const (
+	HiddenToggledFileSubj = "nubes3_hidden_toggled_file"
+	DeletedFileSubj       = "nubes3_deleted_file"
+	ExpiredFileSubj       = "nubes3_expired_file"
+)
+
+type EventType string
+
+const (
+	StagingEvent       EventType = "file.staging"
+	UploadedEvent      EventType = "file.uploaded"
+	DownloadedEvent    EventType = "file.downloaded"
+	HiddenToggledEvent EventType = "file.hidden_toggled"
+	DeletedEvent       EventType = "file.deleted"
+	ExpiredEvent       EventType = "file.expired"
+)
+
+func (t EventType) Subject() string {
+	switch t {
+	case StagingEvent:
+		return nats.StagingFileSubj
+	case UploadedEvent:
+		return nats.UploadFileSuccessSubj
+	case DownloadedEvent:
+		return nats.DownloadFileSubj
+	case HiddenToggledEvent:
+		return HiddenToggledFileSubj
+	case DeletedEvent:
+		return DeletedFileSubj
+	default:
+		return ExpiredFileSubj
+	}
+}
+
+type FileEvent struct {
+	Version    int           `json:"version"`
+	Id         string        `json:"id"`
+	Type       EventType     `json:"type"`
+	OccurredAt time.Time     `json:"occurred_at"`
+	File       FileEventData `json:"file"`
+}
+
+type FileEventData struct {
+	Id           string    `json:"id,omitempty"`
+	BucketId     string    `json:"bucket_id"`
+	Path         string    `json:"path"`
+	Name         string    `json:"name"`
+	ContentType  string    `json:"content_type"`
+	Size         int64     `json:"size"`
+	IsHidden     bool      `json:"is_hidden"`
+	UploadedDate time.Time `json:"upload_date,omitempty"`
+	ExpiredDate  time.Time `json:"expired_date,omitempty"`
+}
+
+var events chan *FileEvent
+
+func init() {
+	events = make(chan *FileEvent, config.Conf.EventBufferSize)
+	go publishEvents()
+}
+
+func publishEvents() {
+	for event := range events {
+		if err := PublishFileEvent(event); err != nil {
+			log.Println("publish file event failed: " + err.Error())
+		}
+	}
+}
+
+// PublishFileEvent publishes event synchronously. It goes through JetStream
+// when a stream captures the subject, de-duplicated on the event id, and
+// falls back to a flushed core NATS publish otherwise.
+func PublishFileEvent(event *FileEvent) error {
+	if nats.Nc == nil {
+		return &utils.ModelError{
+			Msg:     "nats not connected",
+			ErrType: utils.Other,
+		}
+	}
+
+	data, _ := json.Marshal(event)
+	if js, err := nats.Nc.JetStream(); err == nil {
+		_, err = js.Publish(event.Type.Subject(), data, natsGo.MsgId(event.Id))
+		if err == nil {
+			return nil
+		}
+		if err != natsGo.ErrNoStreamResponse {
+			return &utils.ModelError{
+				Msg:     err.Error(),
+				ErrType: utils.Timeout,
+			}
+		}
+	}
+
+	err := nats.Nc.Publish(event.Type.Subject(), data)
+	if err == nil {
+		err = nats.Nc.FlushTimeout(time.Second * 10)
+	}
+	if err != nil {
+		return &utils.ModelError{
+			Msg:     err.Error(),
+			ErrType: utils.Timeout,
+		}
+	}
+
+	return nil
+}
+
+func NewFileEvent(eventType EventType, data FileEventData) *FileEvent {
+	return &FileEvent{
+		Version:    EventSchemaVersion,
+		Id:         newEventId(),
+		Type:       eventType,
+		OccurredAt: time.Now(),
+		File:       data,
+	}
+}
+
+func newEventId() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func FileEventDataOf(fm *arangodb.FileMetadata) FileEventData {
+	return FileEventData{
+		Id:           fm.Id,
+		BucketId:     fm.BucketId,
+		Path:         fm.Path,
+		Name:         fm.Name,
+		ContentType:  fm.ContentType,
+		Size:         fm.Size,
+		IsHidden:     fm.IsHidden,
+		UploadedDate: fm.UploadedDate,
+		ExpiredDate:  fm.ExpiredDate,
+	}
+}
+
+// SendFileEvent queues event for publishing without blocking the caller.
+// The event is dropped when the buffer is full, so it is only meant for
+// events not tied to a metadata change; those go through the outbox.
+func SendFileEvent(event *FileEvent) error {
+	select {
+	case events <- event:
+		return nil
+	default:
+		return &utils.ModelError{
+			Msg:     "event buffer full",
+			ErrType: utils.Other,
+		}
+	}
+}
+
+func SendStagingFileEvent(name string, size int64, bid, contentType, path string, isHidden bool) error {
+	return SendFileEvent(NewFileEvent(StagingEvent, FileEventData{
+		BucketId:    bid,
+		Path:        path,
+		Name:        name,
+		ContentType: contentType,
+		Size:        size,
+		IsHidden:    isHidden,
+	}))
+}
+
+func SendDownloadFileEvent(fm *arangodb.FileMetadata) error {
+	return SendFileEvent(NewFileEvent(DownloadedEvent, FileEventDataOf(fm)))
+}
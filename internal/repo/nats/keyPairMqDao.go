@@ -0,0 +1,47 @@
+package nats
+
+import (
+	"encoding/json"
+	"github.com/Nubes3/common/models/arangodb"
+	"github.com/Nubes3/common/models/nats"
+	"github.com/Nubes3/common/utils"
+	"github.com/Nubes3/file-service/internal/metrics"
+	"time"
+)
+
+func FindKeyPairByPublic(public string) (*arangodb.KeyPair, error) {
+	message := nats.Msg{
+		ReqType:   nats.GetById,
+		Data:      public,
+		ExtraData: nil,
+	}
+	messageJson, _ := json.Marshal(message)
+	rawRep, err := nats.Nc.Request(nats.KeyPairSubj, messageJson, time.Second*10)
+	if err != nil {
+		metrics.NatsTimeout(nats.KeyPairSubj)
+		return nil, &utils.ModelError{
+			Msg:     err.Error(),
+			ErrType: utils.Timeout,
+		}
+	}
+
+	var rep nats.MsgResponse
+	_ = json.Unmarshal(rawRep.Data, &rep)
+	if rep.IsErr {
+		return nil, &utils.ModelError{
+			Msg:     rep.Data,
+			ErrType: utils.Other,
+		}
+	}
+
+	var keyPair arangodb.KeyPair
+	err = json.Unmarshal([]byte(rep.Data), &keyPair)
+	if err != nil {
+		return nil, &utils.ModelError{
+			Msg:     err.Error(),
+			ErrType: utils.Other,
+		}
+	}
+
+	return &keyPair, nil
+}
@@ -0,0 +1,121 @@
+package seaweed
+
+import (
+	"github.com/Nubes3/common/models/seaweedfs"
+	"github.com/Nubes3/common/utils"
+	"github.com/Nubes3/file-service/internal/metrics"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+var headClient = &http.Client{Timeout: time.Second * 10}
+
+// blobClient has no overall timeout since it streams whole blobs.
+var blobClient = &http.Client{}
+
+// BlobExists asks the volume server holding fid whether the blob is still
+// stored.
+func BlobExists(fid string) (exist bool, err error) {
+	defer metrics.ObserveStorage("head", time.Now(), &err)
+
+	url, err := seaweedfs.Sw.LookupFileID(fid, nil, true)
+	if err != nil {
+		return false, &utils.ModelError{
+			Msg:     err.Error(),
+			ErrType: utils.FsError,
+		}
+	}
+
+	res, err := headClient.Head(url)
+	if err != nil {
+		return false, &utils.ModelError{
+			Msg:     err.Error(),
+			ErrType: utils.FsError,
+		}
+	}
+	_ = res.Body.Close()
+
+	switch {
+	case res.StatusCode == http.StatusNotFound:
+		return false, nil
+	case res.StatusCode < 300:
+		return true, nil
+	}
+
+	return false, &utils.ModelError{
+		Msg:     "unexpected volume server status " + res.Status,
+		ErrType: utils.FsError,
+	}
+}
+
+func DeleteBlob(fid string) (err error) {
+	defer metrics.ObserveStorage("delete", time.Now(), &err)
+
+	err = seaweedfs.Sw.DeleteFile(fid, nil)
+	if err != nil {
+		return &utils.ModelError{
+			Msg:     err.Error(),
+			ErrType: utils.FsError,
+		}
+	}
+
+	return nil
+}
+
+// OpenBlob streams the blob fid from offset on, asking the volume server for
+// a range so readers can seek without downloading what they skip.
+func OpenBlob(fid string, offset int64) (body io.ReadCloser, err error) {
+	defer metrics.ObserveStorage("open", time.Now(), &err)
+
+	url, err := seaweedfs.Sw.LookupFileID(fid, nil, true)
+	if err != nil {
+		return nil, &utils.ModelError{
+			Msg:     err.Error(),
+			ErrType: utils.FsError,
+		}
+	}
+
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return nil, &utils.ModelError{
+			Msg:     err.Error(),
+			ErrType: utils.FsError,
+		}
+	}
+	if offset > 0 {
+		req.Header.Set("Range", "bytes="+strconv.FormatInt(offset, 10)+"-")
+	}
+
+	res, err := blobClient.Do(req)
+	if err != nil {
+		return nil, &utils.ModelError{
+			Msg:     err.Error(),
+			ErrType: utils.FsError,
+		}
+	}
+
+	switch {
+	case res.StatusCode == http.StatusNotFound:
+		_ = res.Body.Close()
+		return nil, &utils.ModelError{
+			Msg:     "blob not found",
+			ErrType: utils.NotFound,
+		}
+	case offset > 0 && res.StatusCode != http.StatusPartialContent:
+		_ = res.Body.Close()
+		return nil, &utils.ModelError{
+			Msg:     "volume server ignored range, status " + res.Status,
+			ErrType: utils.FsError,
+		}
+	case res.StatusCode >= 300:
+		_ = res.Body.Close()
+		return nil, &utils.ModelError{
+			Msg:     "unexpected volume server status " + res.Status,
+			ErrType: utils.FsError,
+		}
+	}
+
+	return res.Body, nil
+}
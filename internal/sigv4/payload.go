@@ -0,0 +1,164 @@
+package sigv4
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"hash"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const (
+	chunkAlgorithm = "AWS4-HMAC-SHA256-PAYLOAD"
+	maxChunkSize   = 16 << 20
+)
+
+// Body returns the payload of r as the client meant it: aws-chunked bodies
+// are decoded, and every signed chunk or declared payload hash is checked
+// as the reader is consumed. A mismatch surfaces as a read error, so a
+// caller streaming the body somewhere aborts before accepting it.
+func (s *Signature) Body(r *http.Request, secret string) io.Reader {
+	switch s.PayloadHash {
+	case UnsignedPayload:
+		return r.Body
+	case StreamingPayload:
+		return &chunkReader{
+			reader:    bufio.NewReader(r.Body),
+			signature: s,
+			key:       s.signingKey(secret),
+			previous:  s.Signature,
+		}
+	case StreamingUnsignedTrailer:
+		return &chunkReader{reader: bufio.NewReader(r.Body)}
+	default:
+		return &hashReader{reader: r.Body, hash: sha256.New(), expected: s.PayloadHash}
+	}
+}
+
+// ContentLength is the length of the decoded payload, -1 when unknown.
+func (s *Signature) ContentLength(r *http.Request) int64 {
+	if strings.HasPrefix(s.PayloadHash, "STREAMING-") {
+		n, err := strconv.ParseInt(r.Header.Get(DecodedLengthHeader), 10, 64)
+		if err != nil {
+			return -1
+		}
+		return n
+	}
+
+	return r.ContentLength
+}
+
+type hashReader struct {
+	reader   io.Reader
+	hash     hash.Hash
+	expected string
+}
+
+func (h *hashReader) Read(p []byte) (int, error) {
+	n, err := h.reader.Read(p)
+	h.hash.Write(p[:n])
+	if err == io.EOF && hex.EncodeToString(h.hash.Sum(nil)) != h.expected {
+		return n, invalid("payload does not match " + ContentSha256Header)
+	}
+
+	return n, err
+}
+
+// chunkReader decodes an aws-chunked body. Chunks are checked against the
+// signature chain when signature is set; trailers are skipped.
+type chunkReader struct {
+	reader    *bufio.Reader
+	signature *Signature
+	key       []byte
+	previous  string
+	chunk     []byte
+	done      bool
+}
+
+func (c *chunkReader) Read(p []byte) (int, error) {
+	for len(c.chunk) == 0 {
+		if c.done {
+			return 0, io.EOF
+		}
+		if err := c.next(); err != nil {
+			return 0, err
+		}
+	}
+
+	n := copy(p, c.chunk)
+	c.chunk = c.chunk[n:]
+	return n, nil
+}
+
+// next reads the following chunk into c.chunk, verifying its signature.
+func (c *chunkReader) next() error {
+	line, err := c.readLine()
+	if err != nil {
+		return err
+	}
+
+	header := strings.SplitN(line, ";", 2)
+	size, err := strconv.ParseInt(header[0], 16, 64)
+	if err != nil || size < 0 || size > maxChunkSize {
+		return invalid("malformed chunk")
+	}
+
+	data := make([]byte, size)
+	if _, err := io.ReadFull(c.reader, data); err != nil {
+		return invalid("truncated chunk")
+	}
+
+	if c.signature != nil {
+		if len(header) != 2 || !strings.HasPrefix(header[1], "chunk-signature=") {
+			return invalid("missing chunk signature")
+		}
+		if err := c.verify(strings.TrimPrefix(header[1], "chunk-signature="), data); err != nil {
+			return err
+		}
+	}
+
+	if size == 0 {
+		c.done = true
+		return nil
+	}
+
+	if crlf, err := c.readLine(); err != nil || crlf != "" {
+		return invalid("malformed chunk")
+	}
+	c.chunk = data
+
+	return nil
+}
+
+func (c *chunkReader) verify(signature string, data []byte) error {
+	stringToSign := strings.Join([]string{
+		chunkAlgorithm,
+		c.signature.Time.Format(timeFormat),
+		c.signature.scope(),
+		c.previous,
+		EmptySha256,
+		hashHex(data),
+	}, "\n")
+
+	expected := hex.EncodeToString(hmacSha256(c.key, stringToSign))
+	if !hmac.Equal([]byte(expected), []byte(signature)) {
+		return invalid("chunk signature does not match")
+	}
+	c.previous = signature
+
+	return nil
+}
+
+func (c *chunkReader) readLine() (string, error) {
+	line, err := c.reader.ReadSlice('\n')
+	if err != nil {
+		return "", invalid("malformed chunk")
+	}
+
+	return string(bytes.TrimRight(line, "\r\n")), nil
+}
@@ -0,0 +1,228 @@
+// Package sigv4 verifies requests signed with AWS Signature Version 4, the
+// scheme S3 clients use, including the aws-chunked streaming payloads they
+// send for uploads.
+package sigv4
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"github.com/Nubes3/common/utils"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	Algorithm = "AWS4-HMAC-SHA256"
+
+	UnsignedPayload          = "UNSIGNED-PAYLOAD"
+	StreamingPayload         = "STREAMING-AWS4-HMAC-SHA256-PAYLOAD"
+	StreamingUnsignedTrailer = "STREAMING-UNSIGNED-PAYLOAD-TRAILER"
+
+	DateHeader          = "X-Amz-Date"
+	ContentSha256Header = "X-Amz-Content-Sha256"
+	DecodedLengthHeader = "X-Amz-Decoded-Content-Length"
+
+	timeFormat = "20060102T150405Z"
+	maxSkew    = 15 * time.Minute
+)
+
+// EmptySha256 is the hex SHA-256 of an empty payload.
+var EmptySha256 = hashHex(nil)
+
+// now is the clock requests are checked against.
+var now = time.Now
+
+// Signature is the parsed Authorization header of a signed request.
+type Signature struct {
+	AccessKey     string
+	Date          string
+	Region        string
+	Service       string
+	SignedHeaders []string
+	Signature     string
+	Time          time.Time
+	PayloadHash   string
+}
+
+// Parse reads the signature of r from its Authorization header.
+func Parse(r *http.Request) (*Signature, error) {
+	auth := r.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, Algorithm+" ") {
+		return nil, invalid("missing or unsupported authorization")
+	}
+
+	s := &Signature{}
+	for _, part := range strings.Split(strings.TrimPrefix(auth, Algorithm+" "), ",") {
+		kv := strings.SplitN(strings.TrimSpace(part), "=", 2)
+		if len(kv) != 2 {
+			return nil, invalid("malformed authorization")
+		}
+
+		switch kv[0] {
+		case "Credential":
+			scope := strings.Split(kv[1], "/")
+			if len(scope) != 5 || scope[4] != "aws4_request" {
+				return nil, invalid("malformed credential")
+			}
+			s.AccessKey, s.Date, s.Region, s.Service = scope[0], scope[1], scope[2], scope[3]
+		case "SignedHeaders":
+			s.SignedHeaders = strings.Split(kv[1], ";")
+		case "Signature":
+			s.Signature = kv[1]
+		}
+	}
+
+	if s.AccessKey == "" || len(s.SignedHeaders) == 0 || s.Signature == "" {
+		return nil, invalid("malformed authorization")
+	}
+
+	t, err := time.Parse(timeFormat, r.Header.Get(DateHeader))
+	if err != nil {
+		return nil, invalid("missing or malformed " + DateHeader)
+	}
+	s.Time = t
+
+	s.PayloadHash = r.Header.Get(ContentSha256Header)
+	if s.PayloadHash == "" {
+		s.PayloadHash = UnsignedPayload
+	}
+
+	return s, nil
+}
+
+// Verify checks the signature against secret, the secret of the access key
+// the request names, and that the request was signed recently.
+func (s *Signature) Verify(r *http.Request, secret string) error {
+	if d := now().Sub(s.Time); d > maxSkew || d < -maxSkew {
+		return &utils.ModelError{
+			Msg:     "request time too skewed",
+			ErrType: utils.Expired,
+		}
+	}
+	if s.Date != s.Time.Format("20060102") {
+		return invalid("credential date does not match " + DateHeader)
+	}
+
+	stringToSign := strings.Join([]string{
+		Algorithm,
+		s.Time.Format(timeFormat),
+		s.scope(),
+		hashHex([]byte(s.canonicalRequest(r))),
+	}, "\n")
+
+	if !hmac.Equal([]byte(hex.EncodeToString(hmacSha256(s.signingKey(secret), stringToSign))), []byte(s.Signature)) {
+		return invalid("signature does not match")
+	}
+
+	return nil
+}
+
+func (s *Signature) scope() string {
+	return strings.Join([]string{s.Date, s.Region, s.Service, "aws4_request"}, "/")
+}
+
+func (s *Signature) signingKey(secret string) []byte {
+	key := hmacSha256([]byte("AWS4"+secret), s.Date)
+	key = hmacSha256(key, s.Region)
+	key = hmacSha256(key, s.Service)
+	return hmacSha256(key, "aws4_request")
+}
+
+func (s *Signature) canonicalRequest(r *http.Request) string {
+	headers := make([]string, 0, len(s.SignedHeaders))
+	for _, name := range s.SignedHeaders {
+		headers = append(headers, name+":"+headerValue(r, name))
+	}
+
+	return strings.Join([]string{
+		r.Method,
+		Escape(r.URL.Path, false),
+		canonicalQuery(r.URL.Query()),
+		strings.Join(headers, "\n") + "\n",
+		strings.Join(s.SignedHeaders, ";"),
+		s.PayloadHash,
+	}, "\n")
+}
+
+// headerValue is the canonical value of a signed header: the trimmed values
+// joined by commas with inner runs of spaces collapsed.
+func headerValue(r *http.Request, name string) string {
+	switch name {
+	case "host":
+		return r.Host
+	case "content-length":
+		if r.Header.Get("Content-Length") == "" {
+			return strconv.FormatInt(r.ContentLength, 10)
+		}
+	}
+
+	var values []string
+	for _, v := range r.Header.Values(name) {
+		values = append(values, strings.Join(strings.Fields(v), " "))
+	}
+
+	return strings.Join(values, ",")
+}
+
+// canonicalQuery sorts the encoded parameters by name, then value.
+func canonicalQuery(query url.Values) string {
+	pairs := make([][2]string, 0, len(query))
+	for k, values := range query {
+		for _, v := range values {
+			pairs = append(pairs, [2]string{Escape(k, true), Escape(v, true)})
+		}
+	}
+	sort.Slice(pairs, func(i, j int) bool {
+		if pairs[i][0] != pairs[j][0] {
+			return pairs[i][0] < pairs[j][0]
+		}
+		return pairs[i][1] < pairs[j][1]
+	})
+
+	encoded := make([]string, len(pairs))
+	for i, pair := range pairs {
+		encoded[i] = pair[0] + "=" + pair[1]
+	}
+
+	return strings.Join(encoded, "&")
+}
+
+// Escape percent encodes s the way SigV4 does: everything but unreserved
+// characters, and slashes unless encodeSlash is set.
+func Escape(s string, encodeSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if ('A' <= c && c <= 'Z') || ('a' <= c && c <= 'z') || ('0' <= c && c <= '9') ||
+			c == '-' || c == '_' || c == '.' || c == '~' || (c == '/' && !encodeSlash) {
+			b.WriteByte(c)
+			continue
+		}
+		b.WriteString("%" + strings.ToUpper(hex.EncodeToString([]byte{c})))
+	}
+
+	return b.String()
+}
+
+func hmacSha256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+func hashHex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func invalid(msg string) error {
+	return &utils.ModelError{
+		Msg:     msg,
+		ErrType: utils.Invalid,
+	}
+}
@@ -0,0 +1,130 @@
+package webhook
+
+import (
+	"context"
+	"errors"
+	"github.com/Nubes3/common/utils"
+	"github.com/Nubes3/file-service/internal/config"
+	"net"
+	"net/http"
+	"net/url"
+	"syscall"
+	"time"
+)
+
+// blockedNets are the address ranges webhooks may not be sent to, so a
+// webhook cannot make the service call hosts of its own network.
+var blockedNets = parseNets(
+	"0.0.0.0/8",      // this network
+	"10.0.0.0/8",     // private
+	"100.64.0.0/10",  // carrier grade NAT
+	"127.0.0.0/8",    // loopback
+	"169.254.0.0/16", // link local, cloud metadata
+	"172.16.0.0/12",  // private
+	"192.0.0.0/24",   // protocol assignments
+	"192.168.0.0/16", // private
+	"198.18.0.0/15",  // benchmarking
+	"224.0.0.0/4",    // multicast
+	"240.0.0.0/4",    // reserved, broadcast
+	"::/128",         // unspecified
+	"::1/128",        // loopback
+	"64:ff9b::/96",   // IPv4 translation
+	"fc00::/7",       // unique local
+	"fe80::/10",      // link local
+	"ff00::/8",       // multicast
+)
+
+// allowedNets are exempted from blockedNets, see config.WebhookAllowedNets.
+var allowedNets = parseNets(config.Conf.WebhookAllowedNets...)
+
+var errBlockedAddress = errors.New("webhook address not allowed")
+
+func parseNets(cidrs ...string) []*net.IPNet {
+	nets := make([]*net.IPNet, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		_, n, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+		nets = append(nets, n)
+	}
+
+	return nets
+}
+
+func allowed(ip net.IP) bool {
+	for _, n := range allowedNets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	for _, n := range blockedNets {
+		if n.Contains(ip) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// CheckUrl accepts http and https URLs whose host only resolves to public
+// or explicitly allowed addresses.
+func CheckUrl(raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Hostname() == "" {
+		return &utils.ModelError{
+			Msg:     "invalid url",
+			ErrType: utils.Invalid,
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, u.Hostname())
+	if err != nil || len(addrs) == 0 {
+		return &utils.ModelError{
+			Msg:     "url host does not resolve",
+			ErrType: utils.Invalid,
+		}
+	}
+	for _, addr := range addrs {
+		if !allowed(addr.IP) {
+			return &utils.ModelError{
+				Msg:     "url host resolves to a private address",
+				ErrType: utils.Invalid,
+			}
+		}
+	}
+
+	return nil
+}
+
+// NewClient returns the client deliveries are sent with. It checks the
+// address of every connection it opens, redirects included, as the host of
+// a registered URL may resolve elsewhere by the time it is delivered to.
+func NewClient(timeout time.Duration) *http.Client {
+	dialer := &net.Dialer{
+		Timeout: timeout,
+		Control: func(network string, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if ip := net.ParseIP(host); ip == nil || !allowed(ip) {
+				return errBlockedAddress
+			}
+
+			return nil
+		},
+	}
+
+	return &http.Client{
+		Timeout: timeout,
+		Transport: &http.Transport{
+			DialContext:         dialer.DialContext,
+			TLSHandshakeTimeout: timeout,
+			MaxIdleConnsPerHost: 2,
+		},
+	}
+}
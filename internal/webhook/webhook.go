@@ -0,0 +1,111 @@
+package webhook
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"github.com/Nubes3/common/utils"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Statuses of a delivery.
+const (
+	StatusPending   = "pending"
+	StatusDelivered = "delivered"
+	StatusDead      = "dead"
+)
+
+const (
+	SignatureHeader = "X-Nubes3-Signature"
+	TimestampHeader = "X-Nubes3-Timestamp"
+	EventHeader     = "X-Nubes3-Event"
+	DeliveryHeader  = "X-Nubes3-Delivery"
+)
+
+func NewSecret() string {
+	b := make([]byte, 32)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// Sign returns the signature receivers check against SignatureHeader: the
+// hex HMAC-SHA256 of "<timestamp>.<body>" keyed with the webhook secret.
+func Sign(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10)))
+	mac.Write([]byte("."))
+	mac.Write(body)
+
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Deliver posts a signed payload to url and returns the response status.
+// Any non 2xx status is reported as an error.
+func Deliver(client *http.Client, url string, secret string, deliveryId string,
+	eventType string, body []byte) (int, error) {
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return 0, &utils.ModelError{
+			Msg:     err.Error(),
+			ErrType: utils.Invalid,
+		}
+	}
+
+	timestamp := time.Now().Unix()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(EventHeader, eventType)
+	req.Header.Set(DeliveryHeader, deliveryId)
+	req.Header.Set(TimestampHeader, strconv.FormatInt(timestamp, 10))
+	req.Header.Set(SignatureHeader, Sign(secret, timestamp, body))
+
+	res, err := client.Do(req)
+	if err != nil {
+		return 0, &utils.ModelError{
+			Msg:     err.Error(),
+			ErrType: utils.Timeout,
+		}
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		return res.StatusCode, &utils.ModelError{
+			Msg:     "receiver responded " + res.Status,
+			ErrType: utils.Other,
+		}
+	}
+
+	return res.StatusCode, nil
+}
+
+// Outcome is the state a delivery is left in by an attempt.
+type Outcome struct {
+	Status      string
+	Code        int
+	Reason      string
+	NextAttempt time.Time
+}
+
+// Attempt makes attempt number attempts at a delivery. A failed attempt is
+// retried after backoff(attempts) until maxAttempts were made, then the
+// delivery is dead lettered.
+func Attempt(client *http.Client, url string, secret string, deliveryId string, eventType string,
+	body []byte, attempts int, maxAttempts int, backoff func(attempts int) time.Duration) Outcome {
+	code, err := Deliver(client, url, secret, deliveryId, eventType, body)
+	if err == nil {
+		return Outcome{Status: StatusDelivered, Code: code, NextAttempt: time.Now()}
+	}
+	if attempts >= maxAttempts {
+		return Outcome{Status: StatusDead, Code: code, Reason: err.Error(), NextAttempt: time.Now()}
+	}
+
+	return Outcome{
+		Status:      StatusPending,
+		Code:        code,
+		Reason:      err.Error(),
+		NextAttempt: time.Now().Add(backoff(attempts)),
+	}
+}
@@ -0,0 +1,171 @@
+package webhook
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+const testSecret = "secret"
+
+// allowLoopback lets the test reach httptest receivers, which listen on
+// loopback.
+func allowLoopback(t *testing.T) {
+	prev := allowedNets
+	allowedNets = parseNets("127.0.0.0/8", "::1/128")
+	t.Cleanup(func() { allowedNets = prev })
+}
+
+// receiver answers the given statuses in turn, repeating the last one, and
+// counts the requests it got.
+func receiver(t *testing.T, statuses ...int) (*httptest.Server, *int32) {
+	var calls int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		i := int(atomic.AddInt32(&calls, 1)) - 1
+		if i >= len(statuses) {
+			i = len(statuses) - 1
+		}
+		w.WriteHeader(statuses[i])
+	}))
+	t.Cleanup(srv.Close)
+
+	return srv, &calls
+}
+
+func fixedBackoff(attempts int) time.Duration {
+	return time.Duration(attempts) * time.Minute
+}
+
+func TestDeliverSignature(t *testing.T) {
+	allowLoopback(t)
+
+	body := []byte(`{"type":"file.uploaded"}`)
+	var got *http.Request
+	var gotBody []byte
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r
+		gotBody, _ = ioutil.ReadAll(r.Body)
+	}))
+	defer srv.Close()
+
+	code, err := Deliver(NewClient(time.Second), srv.URL, testSecret, "d1", "file.uploaded", body)
+	if err != nil || code != http.StatusOK {
+		t.Fatalf("Deliver = %d, %v", code, err)
+	}
+
+	if string(gotBody) != string(body) {
+		t.Errorf("body = %q", gotBody)
+	}
+	if got.Header.Get(EventHeader) != "file.uploaded" || got.Header.Get(DeliveryHeader) != "d1" {
+		t.Errorf("event %q, delivery %q", got.Header.Get(EventHeader), got.Header.Get(DeliveryHeader))
+	}
+
+	timestamp := got.Header.Get(TimestampHeader)
+	if _, err := strconv.ParseInt(timestamp, 10, 64); err != nil {
+		t.Fatalf("timestamp %q: %v", timestamp, err)
+	}
+	mac := hmac.New(sha256.New, []byte(testSecret))
+	mac.Write([]byte(timestamp + "." + string(body)))
+	if want := "sha256=" + hex.EncodeToString(mac.Sum(nil)); got.Header.Get(SignatureHeader) != want {
+		t.Errorf("signature = %q, want %q", got.Header.Get(SignatureHeader), want)
+	}
+}
+
+func TestAttempt(t *testing.T) {
+	allowLoopback(t)
+
+	tests := []struct {
+		name     string
+		status   int
+		attempts int
+		want     string
+		backoff  time.Duration
+	}{
+		{name: "delivered", status: http.StatusNoContent, attempts: 1, want: StatusDelivered},
+		{name: "retried", status: http.StatusInternalServerError, attempts: 2, want: StatusPending,
+			backoff: 2 * time.Minute},
+		{name: "dead lettered", status: http.StatusBadGateway, attempts: 3, want: StatusDead},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv, _ := receiver(t, tt.status)
+
+			start := time.Now()
+			o := Attempt(NewClient(time.Second), srv.URL, testSecret, "d1", "file.uploaded", []byte("{}"),
+				tt.attempts, 3, fixedBackoff)
+			if o.Status != tt.want || o.Code != tt.status {
+				t.Fatalf("Attempt = %+v", o)
+			}
+			if (o.Reason == "") != (tt.want == StatusDelivered) {
+				t.Errorf("Reason = %q", o.Reason)
+			}
+
+			wait := o.NextAttempt.Sub(start)
+			if wait < tt.backoff || wait > tt.backoff+time.Second {
+				t.Errorf("NextAttempt %v after the attempt, want %v", wait, tt.backoff)
+			}
+		})
+	}
+}
+
+func TestAttemptRetriesUntilDelivered(t *testing.T) {
+	allowLoopback(t)
+
+	srv, calls := receiver(t, http.StatusServiceUnavailable, http.StatusInternalServerError, http.StatusOK)
+	client := NewClient(time.Second)
+
+	var backoffs []int
+	backoff := func(attempts int) time.Duration {
+		backoffs = append(backoffs, attempts)
+		return fixedBackoff(attempts)
+	}
+
+	var statuses []string
+	for attempts := 1; attempts <= 5; attempts++ {
+		o := Attempt(client, srv.URL, testSecret, "d1", "file.uploaded", []byte("{}"), attempts, 5, backoff)
+		statuses = append(statuses, o.Status)
+		if o.Status != StatusPending {
+			break
+		}
+	}
+
+	want := []string{StatusPending, StatusPending, StatusDelivered}
+	if len(statuses) != len(want) || statuses[0] != want[0] || statuses[1] != want[1] || statuses[2] != want[2] {
+		t.Errorf("statuses = %v, want %v", statuses, want)
+	}
+	if len(backoffs) != 2 || backoffs[0] != 1 || backoffs[1] != 2 {
+		t.Errorf("backoff called with %v", backoffs)
+	}
+	if *calls != 3 {
+		t.Errorf("receiver got %d requests", *calls)
+	}
+}
+
+func TestBlockedReceiver(t *testing.T) {
+	srv, calls := receiver(t, http.StatusOK)
+
+	if err := CheckUrl(srv.URL); err == nil {
+		t.Error("CheckUrl accepted a loopback receiver")
+	}
+	o := Attempt(NewClient(time.Second), srv.URL, testSecret, "d1", "file.uploaded", []byte("{}"),
+		1, 1, fixedBackoff)
+	if o.Status != StatusDead || o.Code != 0 {
+		t.Errorf("Attempt = %+v", o)
+	}
+	if *calls != 0 {
+		t.Errorf("receiver got %d requests", *calls)
+	}
+
+	allowLoopback(t)
+	if err := CheckUrl(srv.URL); err != nil {
+		t.Errorf("CheckUrl with loopback allowed: %v", err)
+	}
+}